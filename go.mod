@@ -2,6 +2,9 @@ module github.com/mdgspace/sysreplicate
 
 go 1.24.3
 
-require golang.org/x/term v0.32.0
+require (
+	golang.org/x/crypto v0.39.0
+	golang.org/x/term v0.32.0
+)
 
 require golang.org/x/sys v0.33.0 // indirect
@@ -0,0 +1,324 @@
+package backup
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// user catalog inside the sysreplicate config dir, merged over DefaultCatalog
+const catalogFileName = "catalog.json"
+
+// CatalogEntry is one well known credential location.
+// Patterns without a slash match file or directory names, others the path relative to Path.
+type CatalogEntry struct {
+	Name    string   `json:"name"`
+	Type    string   `json:"type"`
+	Path    string   `json:"path"`              // file or directory, may start with ~/
+	Include []string `json:"include,omitempty"` // empty means every file
+	Exclude []string `json:"exclude,omitempty"` // excluded directories are not descended into
+	OptIn   bool     `json:"opt_in,omitempty"`  // only backed up when enabled by name
+}
+
+// DefaultCatalog lists the locations known out of the box, more can be added in catalog.json
+var DefaultCatalog = []CatalogEntry{
+	{Name: "ssh", Type: "ssh", Path: "~/.ssh",
+		Include: []string{"id_*", "*.pub", "*.pem", "*.key", "authorized_keys*", "known_hosts*", "config"}},
+	//the gpg type is collected by discoverGPGFiles and follows GNUPGHOME
+	{Name: "gnupg", Type: "gpg", Path: "~/.gnupg"},
+	{Name: "aws", Type: "aws", Path: "~/.aws", Include: []string{"credentials", "config"}},
+	{Name: "kube", Type: "kubeconfig", Path: "~/.kube/config"},
+	{Name: "docker", Type: "docker", Path: "~/.docker/config.json"},
+	{Name: "netrc", Type: "netrc", Path: "~/.netrc"},
+	{Name: "pgpass", Type: "pgpass", Path: "~/.pgpass"},
+	{Name: "git-credentials", Type: "git-credentials", Path: "~/.git-credentials"},
+	{Name: "git-credentials-xdg", Type: "git-credentials", Path: "~/.config/git/credentials"},
+	{Name: "age", Type: "age", Path: "~/.config/age"},
+	{Name: "sops-age", Type: "age", Path: "~/.config/sops/age/keys.txt"},
+	//caches of short lived tokens and logs are left out, logging in again recreates them
+	{Name: "gcloud", Type: "gcloud", Path: "~/.config/gcloud", OptIn: true,
+		Include: []string{"credentials.db", "application_default_credentials.json", "legacy_credentials/*/*", "configurations/*", "active_config"},
+		Exclude: []string{"logs"}},
+	{Name: "azure", Type: "azure", Path: "~/.azure", OptIn: true,
+		Include: []string{"msal_token_cache.*", "service_principal_entries.*", "azureProfile.json", "config"},
+		Exclude: []string{"logs", "commands", "telemetry"}},
+	//entries are already gpg encrypted, but the store can be large
+	{Name: "pass", Type: "pass", Path: "~/.password-store", OptIn: true, Exclude: []string{".git"}},
+}
+
+// format of catalog.json
+type catalogFile struct {
+	Entries []CatalogEntry `json:"entries"`           // new entries, or replacements for defaults of the same name
+	Enable  []string       `json:"enable,omitempty"`  // opt-in entries to back up
+	Disable []string       `json:"disable,omitempty"` // entries to skip
+}
+
+// Catalog is the merged list of locations and which of them are backed up
+type Catalog struct {
+	Entries []CatalogEntry
+	enabled map[string]bool
+}
+
+// CatalogPath is where teams put their own entries
+func CatalogPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, catalogFileName), nil
+}
+
+// LoadCatalog merges the user catalog, when there is one, over the defaults
+func LoadCatalog() (*Catalog, error) {
+	catalog := &Catalog{enabled: make(map[string]bool)}
+	for _, entry := range DefaultCatalog {
+		catalog.add(entry)
+	}
+
+	catalogPath, err := CatalogPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(catalogPath)
+	if os.IsNotExist(err) {
+		return catalog, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var file catalogFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", catalogPath, err)
+	}
+	for _, entry := range file.Entries {
+		if err := entry.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %w", catalogPath, err)
+		}
+		catalog.add(entry)
+	}
+	if err := catalog.Enable(file.Enable...); err != nil {
+		return nil, fmt.Errorf("%s: %w", catalogPath, err)
+	}
+	for _, name := range file.Disable {
+		if catalog.find(name) == nil {
+			return nil, fmt.Errorf("%s: unknown catalog entry %q", catalogPath, name)
+		}
+		catalog.enabled[name] = false
+	}
+	return catalog, nil
+}
+
+// add appends an entry or replaces the one with the same name
+func (c *Catalog) add(entry CatalogEntry) {
+	if existing := c.find(entry.Name); existing != nil {
+		*existing = entry
+	} else {
+		c.Entries = append(c.Entries, entry)
+	}
+	c.enabled[entry.Name] = !entry.OptIn
+}
+
+// find returns the entry with the given name, or nil
+func (c *Catalog) find(name string) *CatalogEntry {
+	for i := range c.Entries {
+		if c.Entries[i].Name == name {
+			return &c.Entries[i]
+		}
+	}
+	return nil
+}
+
+// Enable turns on entries by name, including opt-in and disabled ones
+func (c *Catalog) Enable(names ...string) error {
+	for _, name := range names {
+		if c.find(name) == nil {
+			return fmt.Errorf("unknown catalog entry %q", name)
+		}
+		c.enabled[name] = true
+	}
+	return nil
+}
+
+// Enabled reports whether an entry is backed up
+func (c *Catalog) Enabled(name string) bool {
+	return c.enabled[name]
+}
+
+// OptIn lists the names of entries that are not backed up unless enabled
+func (c *Catalog) OptIn() []string {
+	var names []string
+	for _, entry := range c.Entries {
+		if !c.enabled[entry.Name] {
+			names = append(names, entry.Name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TypeFor names the type of the entry a path falls under, "custom" outside the catalog
+func (c *Catalog) TypeFor(filePath string) string {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "custom"
+	}
+	for _, entry := range c.Entries {
+		root := entry.expandPath(homeDir)
+		if filePath == root || strings.HasPrefix(filePath, root+string(filepath.Separator)) {
+			return entry.Type
+		}
+	}
+	return "custom"
+}
+
+// Locations collects the files of every enabled entry
+func (c *Catalog) Locations() ([]KeyLocation, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var locations []KeyLocation
+	for _, entry := range c.Entries {
+		if !c.enabled[entry.Name] {
+			continue
+		}
+
+		location, err := entry.collect(homeDir)
+		if err != nil {
+			fmt.Printf("Warning: Cannot scan %s (%s): %v\n", entry.Name, location.Path, err)
+			continue
+		}
+		if len(location.Files) > 0 {
+			locations = append(locations, location)
+		}
+	}
+	return locations, nil
+}
+
+// expandPath resolves ~/ and, for gpg entries, GNUPGHOME
+func (e CatalogEntry) expandPath(homeDir string) string {
+	if e.Type == "gpg" {
+		if gpgHome, err := GPGHome(); err == nil {
+			return gpgHome
+		}
+	}
+	if strings.HasPrefix(e.Path, "~/") {
+		return filepath.Join(homeDir, e.Path[2:])
+	}
+	return filepath.Clean(e.Path)
+}
+
+// collect returns the entry's location with the files matching its patterns,
+// a missing path is not an error
+func (e CatalogEntry) collect(homeDir string) (KeyLocation, error) {
+	root := e.expandPath(homeDir)
+	location := KeyLocation{Path: root, Type: e.Type}
+
+	info, err := os.Stat(root)
+	if os.IsNotExist(err) {
+		return location, nil
+	}
+	if err != nil {
+		return location, err
+	}
+
+	if !info.IsDir() {
+		if !matchAny(e.Exclude, filepath.Base(root)) {
+			location.Files = []string{root}
+		}
+		return location, nil
+	}
+
+	location.IsDirectory = true
+	if e.Type == "gpg" {
+		//GnuPG 2.x spreads keys over subdirectories next to sockets and lock files
+		location.Files, err = discoverGPGFiles(root)
+		return location, err
+	}
+
+	err = filepath.WalkDir(root, func(filePath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if filePath == root {
+			return nil
+		}
+		rel, err := filepath.Rel(root, filePath)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if matchAny(e.Exclude, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		//sockets and devices are never credentials, symlinks are checked when read
+		if !d.Type().IsRegular() && d.Type()&fs.ModeSymlink == 0 {
+			return nil
+		}
+		if matchAny(e.Exclude, rel) || (len(e.Include) > 0 && !matchAny(e.Include, rel)) {
+			return nil
+		}
+		location.Files = append(location.Files, filePath)
+		return nil
+	})
+	return location, err
+}
+
+// validate checks a user supplied entry
+func (e CatalogEntry) validate() error {
+	if e.Name == "" || e.Type == "" || e.Path == "" {
+		return fmt.Errorf("catalog entries need a name, type and path")
+	}
+	if !strings.HasPrefix(e.Path, "~/") && !filepath.IsAbs(e.Path) {
+		return fmt.Errorf("catalog entry %s: path must be absolute or start with ~/", e.Name)
+	}
+	for _, pattern := range append(append([]string{}, e.Include...), e.Exclude...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("catalog entry %s: invalid pattern %q", e.Name, pattern)
+		}
+	}
+	return nil
+}
+
+// matchAny matches slash separated rel against the patterns
+func matchAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		target := rel
+		if !strings.Contains(pattern, "/") {
+			target = path.Base(rel)
+		}
+		if matched, _ := path.Match(pattern, target); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// GetCatalogOptIns offers the opt-in catalog entries and returns the ones the user picked
+func GetCatalogOptIns(catalog *Catalog) []string {
+	optIn := catalog.OptIn()
+	if len(optIn) == 0 {
+		return nil
+	}
+
+	fmt.Println("\nOptional credential locations:", strings.Join(optIn, ", "))
+	fmt.Print("Also back up (comma separated, empty for none): ")
+	var names []string
+	for _, name := range strings.Split(readLine(), ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+	return names
+}
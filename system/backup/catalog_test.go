@@ -0,0 +1,183 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMatchAny(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		rel      string
+		want     bool
+	}{
+		{[]string{"id_*"}, "id_ed25519", true},
+		{[]string{"id_*"}, "keys/id_rsa", true}, // no slash matches the base name
+		{[]string{"*.pub"}, "id_ed25519", false},
+		{[]string{"configurations/*"}, "configurations/config_default", true},
+		{[]string{"configurations/*"}, "other/configurations/config_default", false},
+		{[]string{"legacy_credentials/*/*"}, "legacy_credentials/me@example.com/adc.json", true},
+		{[]string{"legacy_credentials/*/*"}, "legacy_credentials/adc.json", false},
+		{[]string{"logs", ".git"}, ".git", true},
+		{nil, "anything", false},
+	}
+	for _, test := range tests {
+		if got := matchAny(test.patterns, test.rel); got != test.want {
+			t.Errorf("matchAny(%q, %q) = %v, want %v", test.patterns, test.rel, got, test.want)
+		}
+	}
+}
+
+func TestCatalogCollect(t *testing.T) {
+	home := testHome(t)
+	writeHomeFiles(t, home, map[string]string{
+		".ssh/id_ed25519":                              "key",
+		".ssh/id_ed25519.pub":                          "key",
+		".ssh/config":                                  "Host *",
+		".ssh/agent.sock":                              "not a key",
+		".ssh/keys/deploy.pem":                         "key",
+		".config/gcloud/credentials.db":                "db",
+		".config/gcloud/configurations/config_default": "config",
+		".config/gcloud/legacy_credentials/me@example.com/adc.json": "token",
+		".config/gcloud/logs/2024.01.01/00.00.00.log":               "log",
+		".config/gcloud/logs/credentials.db":                        "an excluded directory is never entered",
+		".password-store/email/work.gpg":                            "entry",
+		".password-store/.git/objects/ab/cdef":                      "object",
+		".netrc":                                                    "machine example.com",
+	})
+
+	tests := []struct {
+		entry CatalogEntry
+		want  []string
+	}{
+		{catalogEntry(t, "ssh"), []string{".ssh/config", ".ssh/id_ed25519", ".ssh/id_ed25519.pub", ".ssh/keys/deploy.pem"}},
+		{catalogEntry(t, "gcloud"), []string{
+			".config/gcloud/configurations/config_default", ".config/gcloud/credentials.db",
+			".config/gcloud/legacy_credentials/me@example.com/adc.json",
+		}},
+		{catalogEntry(t, "pass"), []string{".password-store/email/work.gpg"}},
+		{catalogEntry(t, "netrc"), []string{".netrc"}},
+		{CatalogEntry{Name: "excluded-file", Type: "netrc", Path: "~/.netrc", Exclude: []string{".netrc"}}, nil},
+		{catalogEntry(t, "aws"), nil}, // a missing path is not an error
+	}
+	for _, test := range tests {
+		location, err := test.entry.collect(home)
+		if err != nil {
+			t.Errorf("%s: %v", test.entry.Name, err)
+			continue
+		}
+		var got []string
+		for _, file := range location.Files {
+			rel, _ := filepath.Rel(home, file)
+			got = append(got, rel)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.entry.Name, got, test.want)
+		}
+	}
+}
+
+// catalogEntry returns a default entry by name
+func catalogEntry(t *testing.T, name string) CatalogEntry {
+	t.Helper()
+	for _, entry := range DefaultCatalog {
+		if entry.Name == name {
+			return entry
+		}
+	}
+	t.Fatalf("no default catalog entry %q", name)
+	return CatalogEntry{}
+}
+
+func TestLoadCatalog(t *testing.T) {
+	home := testHome(t)
+	catalogPath, err := CatalogPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Dir(catalogPath), 0700); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		catalog string // catalog.json, "" for none
+		fails   bool
+		optIn   []string
+		typeFor map[string]string // path below home to its type
+	}{
+		{"defaults", "", false, []string{"azure", "gcloud", "pass"},
+			map[string]string{".ssh/id_rsa": "ssh", ".config/gcloud/credentials.db": "gcloud", ".bashrc": "custom"}},
+		{"added and replaced entries", `{"entries": [
+			{"name": "vault", "type": "vault", "path": "~/.vault-token"},
+			{"name": "aws", "type": "aws-sso", "path": "~/.aws/sso"}
+		]}`, false, []string{"azure", "gcloud", "pass"},
+			map[string]string{".vault-token": "vault", ".aws/sso/cache": "aws-sso", ".aws/credentials": "custom"}},
+		{"enabled and disabled", `{"enable": ["gcloud"], "disable": ["ssh"]}`, false, []string{"azure", "pass", "ssh"}, nil},
+		{"new opt-in entry", `{"entries": [{"name": "terraform", "type": "terraform", "path": "~/.terraform.d/credentials.tfrc.json", "opt_in": true}]}`,
+			false, []string{"azure", "gcloud", "pass", "terraform"}, nil},
+		{"unknown enabled entry", `{"enable": ["vault"]}`, true, nil, nil},
+		{"unknown disabled entry", `{"disable": ["vault"]}`, true, nil, nil},
+		{"relative path", `{"entries": [{"name": "vault", "type": "vault", "path": ".vault-token"}]}`, true, nil, nil},
+		{"missing type", `{"entries": [{"name": "vault", "path": "~/.vault-token"}]}`, true, nil, nil},
+		{"invalid pattern", `{"entries": [{"name": "vault", "type": "vault", "path": "~/.vault", "include": ["["]}]}`, true, nil, nil},
+		{"broken json", `{"entries": [`, true, nil, nil},
+	}
+	for _, test := range tests {
+		os.Remove(catalogPath)
+		if test.catalog != "" {
+			if err := os.WriteFile(catalogPath, []byte(test.catalog), 0600); err != nil {
+				t.Fatal(err)
+			}
+		}
+		catalog, err := LoadCatalog()
+		if (err != nil) != test.fails {
+			t.Errorf("%s: load returned %v", test.name, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := catalog.OptIn(); !reflect.DeepEqual(got, test.optIn) {
+			t.Errorf("%s: opt-in %v, want %v", test.name, got, test.optIn)
+		}
+		for rel, want := range test.typeFor {
+			if got := catalog.TypeFor(filepath.Join(home, rel)); got != want {
+				t.Errorf("%s: %s has type %q, want %q", test.name, rel, got, want)
+			}
+		}
+	}
+
+	//opt-in entries are only collected once enabled
+	os.Remove(catalogPath)
+	writeHomeFiles(t, home, map[string]string{".config/gcloud/credentials.db": "db"})
+	catalog, err := LoadCatalog()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := catalog.Enable("vault"); err == nil {
+		t.Error("enabled an unknown entry")
+	}
+	for _, enable := range []bool{false, true} {
+		if enable {
+			if err := catalog.Enable("gcloud"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		locations, err := catalog.Locations()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, location := range locations {
+			found = found || location.Type == "gcloud"
+		}
+		if found != enable {
+			t.Errorf("gcloud enabled %v, but collected %v", enable, found)
+		}
+	}
+}
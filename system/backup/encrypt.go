@@ -3,26 +3,145 @@ package backup
 import (
     "crypto/aes"
     "crypto/cipher"
+    "crypto/hmac"
     "crypto/rand"
+    "crypto/sha256"
     "encoding/base64"
+    "encoding/hex"
     "fmt"
     "io"
     "os"
+
+    "github.com/mdgspace/sysreplicate/system/output"
+    "golang.org/x/crypto/argon2"
+)
+
+//argon2id defaults, roughly 64MiB and well under a second on a laptop
+const (
+    kdfTime    = 3
+    kdfMemory  = 64 * 1024
+    kdfThreads = 4
+    kdfSaltLen = 16
+    keyLen     = 32
+
+    //upper bound accepted from a manifest so a crafted backup cannot exhaust memory
+    maxKDFMemory = 4 * 1024 * 1024
 )
 
-//simplified encryption config without password
+//context string mixed into the verifier so it is never equal to anything else derived from the key
+const verifierContext = "sysreplicate backup key verifier v1"
+
+//encryption config holding the data key and how it was obtained
 type EncryptionConfig struct {
-    Key []byte // Direct 32-byte key instead of password+salt
+    Key  []byte                // 32-byte AES-256 data key, never written to the backup
+    Info output.EncryptionInfo // what goes into backup.json to get the key back
 }
 
-//AES-GCM encryption with direct key (no password derivation)
-func EncryptFile(filePath string, config *EncryptionConfig) (string, error) {
-    data, err := os.ReadFile(filePath)
+//derive a fresh data key from a passphrase with a random salt
+func NewPassphraseConfig(passphrase []byte) (*EncryptionConfig, error) {
+    salt := make([]byte, kdfSaltLen)
+    if _, err := rand.Read(salt); err != nil {
+        return nil, fmt.Errorf("failed to generate salt: %w", err)
+    }
+
+    params := &output.KDFParams{
+        Algorithm: "argon2id",
+        Salt:      salt,
+        Time:      kdfTime,
+        Memory:    kdfMemory,
+        Threads:   kdfThreads,
+    }
+    key, err := DeriveKey(passphrase, params)
+    if err != nil {
+        return nil, err
+    }
+
+    return &EncryptionConfig{
+        Key: key,
+        Info: output.EncryptionInfo{
+            Mode:     output.EncryptionModePassphrase,
+            KDF:      params,
+            Verifier: keyVerifier(key),
+        },
+    }, nil
+}
+
+//re-derive the data key of an existing backup and check it against the stored verifier
+func UnlockWithPassphrase(info output.EncryptionInfo, passphrase []byte) (*EncryptionConfig, error) {
+    if info.Mode != output.EncryptionModePassphrase || info.KDF == nil {
+        return nil, fmt.Errorf("backup is not passphrase encrypted")
+    }
+
+    key, err := DeriveKey(passphrase, info.KDF)
+    if err != nil {
+        return nil, err
+    }
+
+    expected, err := base64.StdEncoding.DecodeString(info.Verifier)
+    if err != nil {
+        return nil, fmt.Errorf("invalid key verifier: %w", err)
+    }
+    actual, _ := base64.StdEncoding.DecodeString(keyVerifier(key))
+    if !hmac.Equal(expected, actual) {
+        return nil, fmt.Errorf("incorrect passphrase")
+    }
+
+    return &EncryptionConfig{Key: key, Info: info}, nil
+}
+
+//run the KDF described by params over the passphrase
+func DeriveKey(passphrase []byte, params *output.KDFParams) ([]byte, error) {
+    if params.Algorithm != "argon2id" {
+        return nil, fmt.Errorf("unsupported key derivation %q", params.Algorithm)
+    }
+    if len(params.Salt) < kdfSaltLen {
+        return nil, fmt.Errorf("key derivation salt too short")
+    }
+    if params.Time == 0 || params.Threads == 0 || params.Memory == 0 || params.Memory > maxKDFMemory {
+        return nil, fmt.Errorf("invalid key derivation parameters")
+    }
+    return argon2.IDKey(passphrase, params.Salt, params.Time, params.Memory, params.Threads, keyLen), nil
+}
+
+//keyVerifier lets us reject a wrong passphrase without storing anything that decrypts data
+func keyVerifier(key []byte) string {
+    mac := hmac.New(sha256.New, key)
+    mac.Write([]byte(verifierContext))
+    return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+//stream encrypt exactly size bytes of a file into dst, memory use is one chunk
+//returns the hex SHA-256 of the plaintext that was encrypted
+func EncryptFile(dst io.Writer, filePath string, size int64, config *EncryptionConfig) (string, error) {
+    file, err := os.Open(filePath)
+    if err != nil {
+        return "", fmt.Errorf("failed to open file %s: %w", filePath, err)
+    }
+    defer file.Close()
+
+    encrypter, err := NewEncryptWriter(dst, config)
+    if err != nil {
+        return "", err
+    }
+
+    //the tar header already promised EncryptedSize(size) bytes
+    hash := sha256.New()
+    copied, err := io.Copy(io.MultiWriter(encrypter, hash), io.LimitReader(file, size))
     if err != nil {
         return "", fmt.Errorf("failed to read file %s: %w", filePath, err)
     }
+    if copied != size {
+        return "", fmt.Errorf("file %s shrank during backup", filePath)
+    }
+    if err := encrypter.Close(); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
 
-    //use direct key (no password derivation)
+//AES-GCM encryption of an in-memory buffer, nonce is prepended and the result base64 encoded
+func EncryptData(data []byte, config *EncryptionConfig) (string, error) {
+    //use the data key (derived or unwrapped by the caller)
     block, err := aes.NewCipher(config.Key)
     if err != nil {
         return "", fmt.Errorf("failed to create cipher: %w", err)
@@ -50,7 +169,37 @@ func EncryptFile(filePath string, config *EncryptionConfig) (string, error) {
 
 //generate a random 32-byte key for AES-256
 func GenerateKey() ([]byte, error) {
-    key := make([]byte, 32) // 32 bytes for AES-256
+    key := make([]byte, keyLen) // 32 bytes for AES-256
     _, err := rand.Read(key)
     return key, err
 }
+
+//AES-GCM decryption of data produced by EncryptData
+func DecryptData(encoded string, config *EncryptionConfig) ([]byte, error) {
+    ciphertext, err := base64.StdEncoding.DecodeString(encoded)
+    if err != nil {
+        return nil, fmt.Errorf("failed to decode data: %w", err)
+    }
+
+    block, err := aes.NewCipher(config.Key)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create cipher: %w", err)
+    }
+
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, fmt.Errorf("failed to create GCM: %w", err)
+    }
+
+    //nonce is stored in front of the ciphertext
+    if len(ciphertext) < gcm.NonceSize() {
+        return nil, fmt.Errorf("ciphertext too short")
+    }
+    nonce, sealed := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+    data, err := gcm.Open(nil, nonce, sealed, nil)
+    if err != nil {
+        return nil, fmt.Errorf("failed to decrypt data: %w", err)
+    }
+    return data, nil
+}
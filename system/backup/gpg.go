@@ -0,0 +1,245 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// files at the top of a GnuPG home worth keeping, the rest is runtime state
+// like agent sockets, random_seed and lock files
+var gpgHomeFiles = map[string]bool{
+	"pubring.kbx":    true, // 2.1+ public keyring
+	"pubring.gpg":    true, // 1.x and migrated homes
+	"secring.gpg":    true,
+	"trustdb.gpg":    true,
+	"tofu.db":        true,
+	"sshcontrol":     true,
+	"gpg.conf":       true,
+	"gpg-agent.conf": true,
+	"dirmngr.conf":   true,
+	"scdaemon.conf":  true,
+	"common.conf":    true,
+}
+
+// subdirectories of a GnuPG home and the suffix of the files kept from them
+var gpgHomeDirs = map[string]string{
+	"private-keys-v1.d": ".key", // one file per secret key, named by keygrip
+	"openpgp-revocs.d":  ".rev", // revocation certificates made at key generation
+}
+
+// tar entries holding the gpg exports
+const (
+	gpgSecretKeysEntry = "gpg/secret-keys.asc"
+	gpgOwnertrustEntry = "gpg/ownertrust.txt"
+)
+
+// SetGPGExport also stores gpg --export-secret-keys and --export-ownertrust output,
+// which restores on any GnuPG version instead of depending on the keyring file layout
+func (bm *BackupManager) SetGPGExport(enabled bool) {
+	bm.gpgExport = enabled
+}
+
+// GPGHome returns the GnuPG home gpg itself would use
+func GPGHome() (string, error) {
+	if home := os.Getenv("GNUPGHOME"); home != "" {
+		return filepath.Abs(home)
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(homeDir, ".gnupg"), nil
+}
+
+// discoverGPGFiles lists the keyring, key and config files of a GnuPG 1.x or 2.x home
+func discoverGPGFiles(home string) ([]string, error) {
+	entries, err := os.ReadDir(home)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.Type().IsRegular() && gpgHomeFiles[name] {
+			files = append(files, filepath.Join(home, name))
+			continue
+		}
+
+		suffix, ok := gpgHomeDirs[name]
+		if !ok || !entry.IsDir() {
+			continue
+		}
+		dirEntries, err := os.ReadDir(filepath.Join(home, name))
+		if err != nil {
+			fmt.Printf("Warning: Cannot read %s: %v\n", filepath.Join(home, name), err)
+			continue
+		}
+		for _, dirEntry := range dirEntries {
+			if dirEntry.Type().IsRegular() && strings.HasSuffix(dirEntry.Name(), suffix) {
+				files = append(files, filepath.Join(home, name, dirEntry.Name()))
+			}
+		}
+	}
+	return files, nil
+}
+
+// exportGPG runs gpg to export secret keys and ownertrust and returns their tar entries.
+// gpg may ask for each key's passphrase to allow the export.
+func (bm *BackupManager) exportGPG(backupData *output.BackupData) ([]output.TarballEntry, error) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return nil, fmt.Errorf("gpg is not installed")
+	}
+	home, err := GPGHome()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(home); err != nil {
+		return nil, fmt.Errorf("no GnuPG home: %w", err)
+	}
+
+	secretKeys, err := runGPG(home, nil, "--armor", "--export-secret-keys")
+	if err != nil {
+		return nil, fmt.Errorf("failed to export secret keys: %w", err)
+	}
+	ownertrust, err := runGPG(home, nil, "--export-ownertrust")
+	if err != nil {
+		return nil, fmt.Errorf("failed to export ownertrust: %w", err)
+	}
+
+	export := &output.GPGExport{Home: home}
+	var entries []output.TarballEntry
+	if len(secretKeys) > 0 {
+		export.SecretKeys, entries = bm.exportEntry(gpgSecretKeysEntry, secretKeys, entries)
+	}
+	if len(ownertrust) > 0 {
+		export.Ownertrust, entries = bm.exportEntry(gpgOwnertrustEntry, ownertrust, entries)
+	}
+	if len(entries) == 0 {
+		return nil, nil
+	}
+	backupData.GPG = export
+	return entries, nil
+}
+
+// exportEntry describes in-memory export data and appends the tar entry that encrypts it
+func (bm *BackupManager) exportEntry(name string, data []byte, entries []output.TarballEntry) (*output.EncryptedKey, []output.TarballEntry) {
+	digest := sha256.Sum256(data)
+	key := &output.EncryptedKey{
+		KeyType:     "gpg-export",
+		Entry:       name,
+		Size:        int64(len(data)),
+		SHA256:      hex.EncodeToString(digest[:]),
+		ModTime:     time.Now(),
+		Permissions: 0600,
+	}
+
+	entries = append(entries, output.TarballEntry{
+		Name: name,
+		Size: EncryptedSize(int64(len(data))),
+		Write: func(w io.Writer) error {
+			encrypter, err := NewEncryptWriter(w, bm.config)
+			if err != nil {
+				return err
+			}
+			if _, err := encrypter.Write(data); err != nil {
+				return err
+			}
+			return encrypter.Close()
+		},
+	})
+	return key, entries
+}
+
+// gpgExports maps the export entries of a manifest to their records
+func gpgExports(backupData *output.BackupData) map[string]*output.EncryptedKey {
+	exports := make(map[string]*output.EncryptedKey)
+	if backupData.GPG == nil {
+		return exports
+	}
+	if backupData.GPG.SecretKeys != nil {
+		exports[backupData.GPG.SecretKeys.Entry] = backupData.GPG.SecretKeys
+	}
+	if backupData.GPG.Ownertrust != nil {
+		exports[backupData.GPG.Ownertrust.Entry] = backupData.GPG.Ownertrust
+	}
+	return exports
+}
+
+// sortedExportNames lists the export entries in a stable order
+func sortedExportNames(backupData *output.BackupData) []string {
+	var names []string
+	for name := range gpgExports(backupData) {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// readExport decrypts an export entry into memory and checks it against the manifest
+func readExport(key *output.EncryptedKey, r io.Reader, config *EncryptionConfig) ([]byte, error) {
+	plaintext, err := NewDecryptReader(r, config)
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(plaintext)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPlaintext(*key, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// importGPG imports exported secret keys and then reapplies ownertrust, which only
+// refers to keys by fingerprint and so must come second
+func importGPG(export *output.GPGExport, exported map[string][]byte) error {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		return fmt.Errorf("gpg is not installed")
+	}
+	if err := ensureParentDir(filepath.Join(export.Home, "private-keys-v1.d")); err != nil {
+		return err
+	}
+
+	if export.SecretKeys != nil && len(exported[export.SecretKeys.Entry]) > 0 {
+		if _, err := runGPG(export.Home, exported[export.SecretKeys.Entry], "--import"); err != nil {
+			return fmt.Errorf("failed to import secret keys: %w", err)
+		}
+	}
+	if export.Ownertrust != nil && len(exported[export.Ownertrust.Entry]) > 0 {
+		if _, err := runGPG(export.Home, exported[export.Ownertrust.Entry], "--import-ownertrust"); err != nil {
+			return fmt.Errorf("failed to import ownertrust: %w", err)
+		}
+	}
+	return nil
+}
+
+// runGPG runs gpg in batch mode on the given home and returns its stdout
+func runGPG(home string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.Command("gpg", append([]string{"--homedir", home, "--batch", "--quiet"}, args...)...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return nil, fmt.Errorf("%w: %s", err, message)
+		}
+		return nil, err
+	}
+	return stdout.Bytes(), nil
+}
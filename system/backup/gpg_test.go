@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestDiscoverGPGFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		want  []string
+	}{
+		{"gnupg 1.x", []string{"pubring.gpg", "secring.gpg", "trustdb.gpg", "gpg.conf", "random_seed", "pubring.gpg~"},
+			[]string{"gpg.conf", "pubring.gpg", "secring.gpg", "trustdb.gpg"}},
+		{"gnupg 2.x", []string{
+			"pubring.kbx", "pubring.kbx~", "trustdb.gpg", "gpg-agent.conf", "sshcontrol", "S.gpg-agent",
+			"private-keys-v1.d/0123ABCD.key", "private-keys-v1.d/0123ABCD.key.lock",
+			"openpgp-revocs.d/FEDCBA98.rev", "crls.d/DIR.txt",
+		}, []string{
+			"gpg-agent.conf", "openpgp-revocs.d/FEDCBA98.rev", "private-keys-v1.d/0123ABCD.key",
+			"pubring.kbx", "sshcontrol", "trustdb.gpg",
+		}},
+		{"empty home", nil, nil},
+	}
+	for _, test := range tests {
+		home := t.TempDir()
+		for _, name := range test.files {
+			writeHomeFiles(t, home, map[string]string{name: "data"})
+		}
+		files, err := discoverGPGFiles(home)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var got []string
+		for _, file := range files {
+			rel, _ := filepath.Rel(home, file)
+			got = append(got, rel)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	if _, err := discoverGPGFiles(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("listed a GnuPG home that does not exist")
+	}
+}
+
+func TestGPGHome(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Chdir(home)
+	tests := []struct {
+		gnupgHome string
+		want      string
+	}{
+		{"", filepath.Join(home, ".gnupg")},
+		{"/srv/gnupg", "/srv/gnupg"},
+		{"keys", filepath.Join(home, "keys")},
+	}
+	for _, test := range tests {
+		t.Setenv("GNUPGHOME", test.gnupgHome)
+		if got, err := GPGHome(); err != nil || got != test.want {
+			t.Errorf("GNUPGHOME=%q: got %q (%v), want %q", test.gnupgHome, got, err, test.want)
+		}
+	}
+}
+
+// TestGPGExport backs up and restores through a stub gpg that exports fixed data and
+// records what is imported, in the order it is imported
+func TestGPGExport(t *testing.T) {
+	home := testHome(t)
+	gnupgHome := filepath.Join(home, ".gnupg")
+	writeHomeFiles(t, home, map[string]string{".gnupg/pubring.kbx": "keybox"})
+
+	stubs := t.TempDir()
+	imports := filepath.Join(stubs, "imports")
+	stub := "#!/bin/sh\n" +
+		"for arg; do last=$arg; done\n" +
+		"case $last in\n" +
+		"--export-secret-keys) echo SECRET ;;\n" +
+		"--export-ownertrust) echo TRUST ;;\n" +
+		"--import|--import-ownertrust) echo \"$last $(cat)\" >> '" + imports + "' ;;\n" +
+		"esac\n"
+	if err := os.WriteFile(filepath.Join(stubs, "gpg"), []byte(stub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", stubs+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	bm := passphraseManager(testPassphrase)
+	bm.SetGPGExport(true)
+	tarballPath := createTestBackup(t, bm)
+
+	backupData, err := bm.readManifest(tarballPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backupData.GPG == nil || backupData.GPG.Home != gnupgHome {
+		t.Fatalf("manifest records gpg exports %+v, want exports from %s", backupData.GPG, gnupgHome)
+	}
+	for name, want := range map[string]string{"secret keys": gpgSecretKeysEntry, "ownertrust": gpgOwnertrustEntry} {
+		if _, ok := gpgExports(backupData)[want]; !ok {
+			t.Errorf("%s are not exported to %s", name, want)
+		}
+	}
+
+	//the keyring files exist, so the exports alone are what gets imported
+	if err := passphraseManager(testPassphrase).RestoreBackup(tarballPath, false); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(imports)
+	if want := "--import SECRET\n--import-ownertrust TRUST\n"; err != nil || string(data) != want {
+		t.Errorf("imported %q (%v), want %q", data, err, want)
+	}
+}
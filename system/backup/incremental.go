@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// SetIncremental makes CreateBackup store only files changed since the latest backup
+func (bm *BackupManager) SetIncremental(enabled bool) {
+	bm.incremental = enabled
+}
+
+// loadParent returns the newest backup in dir and its manifest, or nil when there is none
+func (bm *BackupManager) loadParent(dir string) (string, *output.BackupData, error) {
+	backups, err := FindBackups(dir)
+	if err != nil || len(backups) == 0 {
+		return "", nil, err
+	}
+
+	//manifests are readable without any key, but must carry a trusted signature
+	parent, err := bm.readManifest(backups[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read previous backup %s: %w", backups[0], err)
+	}
+	return filepath.Base(backups[0]), parent, nil
+}
+
+// unchangedSince reports whether a file still matches the parent's record.
+// Equal mtime and size are trusted like rsync does, otherwise the content hash decides.
+func unchangedSince(parentKey output.EncryptedKey, filePath string, fileInfo os.FileInfo) bool {
+	//inline version 1 data cannot be referenced from another archive
+	if parentKey.Entry == "" || parentKey.SHA256 == "" || parentKey.Size != fileInfo.Size() {
+		return false
+	}
+	if parentKey.ModTime.Equal(fileInfo.ModTime()) {
+		return true
+	}
+
+	digest, err := hashFile(filePath)
+	if err != nil {
+		return false
+	}
+	return digest == parentKey.SHA256
+}
+
+// inheritKey records current metadata for a file whose data stays in an older archive
+func inheritKey(parentName string, parentKey, current output.EncryptedKey) output.EncryptedKey {
+	current.Entry = parentKey.Entry
+	current.SHA256 = parentKey.SHA256
+
+	//always point at the archive that really holds the data, never at an intermediate one
+	current.Source = parentKey.Source
+	if current.Source == "" {
+		current.Source = parentName
+	}
+	return current
+}
+
+// tombstones lists the files of the parent that are gone from the new backup
+func tombstones(parent, current *output.BackupData) []string {
+	present := make(map[string]bool)
+	for _, key := range current.EncryptedKeys {
+		present[key.OriginalPath] = true
+	}
+
+	var deleted []string
+	for _, key := range parent.EncryptedKeys {
+		if !present[key.OriginalPath] {
+			deleted = append(deleted, key.OriginalPath)
+		}
+	}
+	sort.Strings(deleted)
+	return deleted
+}
+
+// sourcePath resolves a key's Source next to the archive that references it
+func sourcePath(tarballPath, source string) (string, error) {
+	if source == "" {
+		return tarballPath, nil
+	}
+	if filepath.Base(source) != source || source == "." || source == ".." {
+		return "", fmt.Errorf("invalid source archive name %q", source)
+	}
+	return filepath.Join(filepath.Dir(tarballPath), source), nil
+}
+
+// hashFile streams a file through SHA-256
+func hashFile(filePath string) (string, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,150 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+func TestTombstones(t *testing.T) {
+	backupOf := func(paths ...string) *output.BackupData {
+		backupData := &output.BackupData{EncryptedKeys: make(map[string]output.EncryptedKey)}
+		for _, path := range paths {
+			backupData.EncryptedKeys[path] = output.EncryptedKey{OriginalPath: path}
+		}
+		return backupData
+	}
+	tests := []struct {
+		name            string
+		parent, current []string
+		want            []string
+	}{
+		{"nothing deleted", []string{"/a", "/b"}, []string{"/a", "/b", "/c"}, nil},
+		{"deleted files are sorted", []string{"/c", "/a", "/b"}, []string{"/b"}, []string{"/a", "/c"}},
+		{"everything deleted", []string{"/a"}, nil, []string{"/a"}},
+	}
+	for _, test := range tests {
+		if got := tombstones(backupOf(test.parent...), backupOf(test.current...)); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+// keyByPath finds the manifest record of a backed up file
+func keyByPath(backupData *output.BackupData, path string) (output.EncryptedKey, bool) {
+	for _, key := range backupData.EncryptedKeys {
+		if key.OriginalPath == path {
+			return key, true
+		}
+	}
+	return output.EncryptedKey{}, false
+}
+
+// ageBackup renames a backup to an older timestamp, so the next one in the same second
+// still sorts after it
+func ageBackup(t *testing.T, tarballPath, stamp string) string {
+	t.Helper()
+	aged := filepath.Join(backupDir, "key-backup-"+stamp+".tar.gz")
+	if err := os.Rename(tarballPath, aged); err != nil {
+		t.Fatal(err)
+	}
+	return aged
+}
+
+func TestIncrementalChain(t *testing.T) {
+	home := testHome(t)
+	writeHomeFiles(t, home, map[string]string{
+		".ssh/id_ed25519":  "unchanged key",
+		".ssh/id_rsa":      "old rsa key",
+		".aws/credentials": "[default]\n",
+	})
+	base := filepath.Base(ageBackup(t, createTestBackup(t, passphraseManager(testPassphrase)), "2000-01-01-00-00-00"))
+
+	incremental := func() string {
+		bm := passphraseManager(testPassphrase)
+		bm.SetIncremental(true)
+		return createTestBackup(t, bm)
+	}
+
+	//the first delta changes one file and deletes another
+	writeHomeFiles(t, home, map[string]string{".ssh/id_rsa": "new rsa key!"})
+	os.Remove(filepath.Join(home, ".aws/credentials"))
+	middle := filepath.Base(ageBackup(t, incremental(), "2000-01-01-00-00-01"))
+
+	//the second delta adds a file on top of the first
+	writeHomeFiles(t, home, map[string]string{".netrc": "machine example.com\n"})
+	latest := incremental()
+
+	backupData, err := passphraseManager(testPassphrase).readManifest(latest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if backupData.Parent != middle {
+		t.Errorf("parent is %q, want %q", backupData.Parent, middle)
+	}
+
+	tests := []struct {
+		path   string
+		source string // archive holding the data, "" for the latest
+	}{
+		{".ssh/id_ed25519", base},
+		{".ssh/id_rsa", middle},
+		{".netrc", ""},
+	}
+	for _, test := range tests {
+		key, ok := keyByPath(backupData, filepath.Join(home, test.path))
+		if !ok {
+			t.Errorf("%s is missing from the latest backup", test.path)
+			continue
+		}
+		if key.Source != test.source {
+			t.Errorf("%s: data in %q, want %q", test.path, key.Source, test.source)
+		}
+	}
+	if _, ok := keyByPath(backupData, filepath.Join(home, ".aws/credentials")); ok {
+		t.Error("the deleted credentials are still in the latest backup")
+	}
+
+	//a tombstone is only recorded by the delta that saw the file go
+	middleData, err := passphraseManager(testPassphrase).readManifest(filepath.Join(backupDir, middle))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{filepath.Join(home, ".aws/credentials")}; !reflect.DeepEqual(middleData.Deleted, want) {
+		t.Errorf("middle backup deleted %v, want %v", middleData.Deleted, want)
+	}
+
+	//restoring the latest backup pulls every file from its own archive of the chain
+	for _, dir := range []string{".ssh", ".aws", ".netrc"} {
+		os.RemoveAll(filepath.Join(home, dir))
+	}
+	if err := passphraseManager(testPassphrase).RestoreBackup(latest, false); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		".ssh/id_ed25519": "unchanged key",
+		".ssh/id_rsa":     "new rsa key!",
+		".netrc":          "machine example.com\n",
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(filepath.Join(home, name))
+		if err != nil || string(data) != content {
+			t.Errorf("restored %s holds %q (%v), want %q", name, data, err, content)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(home, ".aws/credentials")); !os.IsNotExist(err) {
+		t.Errorf("deleted credentials were restored (%v)", err)
+	}
+
+	//the chain is only as good as its oldest archive
+	if err := os.Remove(filepath.Join(backupDir, base)); err != nil {
+		t.Fatal(err)
+	}
+	report, err := passphraseManager(testPassphrase).VerifyBackup(latest)
+	if err != nil || !report.Failed() {
+		t.Errorf("verified a chain without its base: failed %v, %v", report != nil && report.Failed(), err)
+	}
+}
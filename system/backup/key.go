@@ -2,116 +2,263 @@ package backup
 
 import (
 	"bufio"
+	"bytes"
+	"crypto/ecdh"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 	"github.com/mdgspace/sysreplicate/system/output"
+	"golang.org/x/term"
 )
 
+//where key backups are written and looked up
+const backupDir = "dist"
+
 //backup and restore operations
 type BackupManager struct {
-    config *EncryptionConfig
+    config      *EncryptionConfig
+    passphrase  []byte
+    recipients  []*ecdh.PublicKey
+    identity    *ecdh.PrivateKey
+    incremental bool
+    force       bool
+    gpgExport   bool
+    enabled     []string // opt-in catalog entries
+
+    //previous backup an incremental run compares against
+    parentName string
+    parent     *output.BackupData
 }
 
 func NewBackupManager() *BackupManager {
     return &BackupManager{}
 }
 
-//create a complete backup of keys (no password required)
-func (bm *BackupManager) CreateBackup(customPaths []string) error {
+//use a passphrase to derive backup keys and to unlock passphrase backups on restore
+func (bm *BackupManager) UsePassphrase(passphrase []byte) {
+    bm.passphrase = passphrase
+}
+
+//encrypt backups to these public keys instead of a passphrase, no prompt needed
+func (bm *BackupManager) UseRecipients(recipients []*ecdh.PublicKey) {
+    bm.recipients = recipients
+}
+
+//back up opt-in catalog entries as well, e.g. "gcloud" or "pass"
+func (bm *BackupManager) EnableCatalogEntries(names []string) {
+    bm.enabled = names
+}
+
+//private key used to unlock recipient backups on restore
+func (bm *BackupManager) UseIdentity(identity *ecdh.PrivateKey) {
+    bm.identity = identity
+}
+
+//create a complete backup of keys encrypted to the configured recipients or passphrase
+//returns the tarball path, empty when there was nothing to back up
+func (bm *BackupManager) CreateBackup(customPaths []string) (string, error) {
     fmt.Println("Starting key backup process...")
 
-    //generate random encryption key (no password needed)
-    key, err := GenerateKey()
-    if err != nil {
-        return fmt.Errorf("failed to generate encryption key: %w", err)
+    //a fresh data key (or salt) for every backup
+    var config *EncryptionConfig
+    var err error
+    switch {
+    case len(bm.recipients) > 0:
+        config, err = NewRecipientConfig(bm.recipients)
+    case len(bm.passphrase) > 0:
+        config, err = NewPassphraseConfig(bm.passphrase)
+    default:
+        return "", fmt.Errorf("no passphrase or recipients configured for backup encryption")
     }
-
-    bm.config = &EncryptionConfig{
-        Key: key,
+    if err != nil {
+        return "", fmt.Errorf("failed to set up encryption: %w", err)
     }
+    bm.config = config
 
-    // search standard locations
+    // search the catalog of well known credential locations
     fmt.Println("searching standard key locations...")
-    standardLocations, err := searchStandardLocations()
+    catalog, err := LoadCatalog()
+    if err != nil {
+        return "", fmt.Errorf("failed to load catalog: %w", err)
+    }
+    if err := catalog.Enable(bm.enabled...); err != nil {
+        return "", err
+    }
+    standardLocations, err := catalog.Locations()
     if err != nil {
-        return fmt.Errorf("failed to search standard locations: %w", err)
+        return "", fmt.Errorf("failed to search standard locations: %w", err)
     }
 
     //add custom paths
-    customLocations := bm.processCustomPaths(customPaths)
+    customLocations := bm.processCustomPaths(customPaths, catalog)
 
     //combine all locations
     allLocations := append(standardLocations, customLocations...)
     if len(allLocations) == 0 {
         fmt.Println("No key locations found to backup.")
-        return nil
+        return "", nil
     }
 
     //create backup data
     backupData := &output.BackupData{
+        Version:       output.BackupVersion,
         Timestamp:     time.Now(),
         SystemInfo:    bm.getSystemInfo(),
+        Encryption:    bm.config.Info, // KDF parameters or wrapped keys, never the key itself
         EncryptedKeys: make(map[string]output.EncryptedKey),
-        EncryptionKey: key, // Store the key in backup data
     }
 
-    //encrypt and store keys
-    fmt.Println("Encrypting keys...")
+    //every manifest is signed so restores can detect tampering
+    signingKey, err := loadOrCreateSigningKey()
+    if err != nil {
+        return "", fmt.Errorf("failed to load signing key: %w", err)
+    }
+
+    //incremental runs only store what changed since the newest backup
+    bm.parentName, bm.parent = "", nil
+    if bm.incremental {
+        bm.parentName, bm.parent, err = bm.loadParent(backupDir)
+        if err != nil {
+            fmt.Printf("Warning: Cannot build on the previous backup: %v\n", err)
+            bm.parentName, bm.parent = "", nil
+        }
+        if bm.parent == nil {
+            fmt.Println("No previous backup found, creating a full backup.")
+        } else {
+            fmt.Println("Creating incremental backup on top of", bm.parentName)
+            backupData.Parent = bm.parentName
+        }
+    }
+
+    //collect keys, each one is encrypted while it is streamed into the tarball
+    var entries []output.TarballEntry
     for _, location := range allLocations {
-        err := bm.processLocation(location, backupData)
+        locationEntries, err := bm.processLocation(location, backupData)
         if err != nil {
             fmt.Printf("Warning: Failed to process location %s: %v\n", location.Path, err)
             continue
         }
+        entries = append(entries, locationEntries...)
+    }
+
+    if bm.parent != nil {
+        backupData.Deleted = tombstones(bm.parent, backupData)
+    }
+    changed := len(entries)
+
+    //exports are small and always stored in full, even in incremental backups
+    if bm.gpgExport {
+        fmt.Println("Exporting GnuPG secret keys and ownertrust...")
+        exportEntries, err := bm.exportGPG(backupData)
+        if err != nil {
+            fmt.Printf("Warning: GnuPG export skipped: %v\n", err)
+        }
+        entries = append(entries, exportEntries...)
     }
 
     //creating tarball for the backup storing
-    fmt.Println("Creating backup tarball...")
-    tarballPath := fmt.Sprintf("dist/key-backup-%s.tar.gz",
-        time.Now().Format("2006-01-02-15-04-05"))
-    err = output.CreateBackupTarball(backupData, tarballPath)
+    fmt.Println("Encrypting keys into backup tarball...")
+    tarballPath := filepath.Join(backupDir, fmt.Sprintf("key-backup-%s.tar.gz",
+        time.Now().Format("2006-01-02-15-04-05")))
+    err = output.CreateBackupTarball(backupData, entries, tarballPath, manifestSigner(signingKey))
     if err != nil {
-        return fmt.Errorf("failed to create tarball: %w", err)
+        os.Remove(tarballPath) //never leave a half written backup behind
+        return "", fmt.Errorf("failed to create tarball: %w", err)
     }
 
     fmt.Printf("Backup completed successfully: %s\n", tarballPath)
     fmt.Printf("Backed up %d key files\n", len(backupData.EncryptedKeys))
-    return nil
+    if bm.parent != nil {
+        fmt.Printf("%d changed or new, %d unchanged, %d deleted since %s\n",
+            changed, len(backupData.EncryptedKeys)-changed, len(backupData.Deleted), bm.parentName)
+    }
+    if backupData.GPG != nil {
+        fmt.Println("Included GnuPG exports from", backupData.GPG.Home)
+    }
+    return tarballPath, nil
 }
 
 
-// processLocation processes a single key location
-func (bm *BackupManager) processLocation(location KeyLocation, backupData *output.BackupData) error {
+// processLocation adds the manifest entries for a single key location and returns their tar entries
+func (bm *BackupManager) processLocation(location KeyLocation, backupData *output.BackupData) ([]output.TarballEntry, error) {
+    var entries []output.TarballEntry
     for _, filePath := range location.Files {
-        //get file info for permissions
-        fileInfo, err := os.Stat(filePath)
+        keyID := filepath.Base(filePath) + "_" + strings.ReplaceAll(filePath, "/", "_")
+        if _, ok := backupData.EncryptedKeys[keyID]; ok {
+            continue //already picked up through another location
+        }
+
+        //make sure the file is readable now, the tar header is written before its data
+        fileInfo, err := statReadable(filePath)
         if err != nil {
+            fmt.Printf("Warning: Skipping %s: %v\n", filePath, err)
             continue
         }
 
-        // call encryption of the file
-        encryptedData, err := EncryptFile(filePath, bm.config)
-        if err != nil {
-            return fmt.Errorf("failed to encrypt %s: %w", filePath, err)
+        // store key metadata, the data itself lives in its own tar entry
+        entryName := "keys/" + keyID
+        key := output.EncryptedKey{
+            OriginalPath: filePath,
+            KeyType:      location.Type,
+            Entry:        entryName,
+            Size:         fileInfo.Size(),
+            ModTime:      fileInfo.ModTime(),
+            Permissions:  uint32(fileInfo.Mode()),
+            Info:         ClassifyKeyFile(filePath),
         }
 
-        // store encrypted key
-        keyID := filepath.Base(filePath) + "_" + strings.ReplaceAll(filePath, "/", "_")
-        backupData.EncryptedKeys[keyID] = output.EncryptedKey{
-            OriginalPath:  filePath,
-            KeyType:       location.Type,
-            EncryptedData: encryptedData,
-            Permissions:   uint32(fileInfo.Mode()),
+        //unchanged files keep pointing at the archive that already holds them
+        if bm.parent != nil {
+            if parentKey, ok := bm.parent.EncryptedKeys[keyID]; ok && unchangedSince(parentKey, filePath, fileInfo) {
+                backupData.EncryptedKeys[keyID] = inheritKey(bm.parentName, parentKey, key)
+                continue
+            }
         }
+        backupData.EncryptedKeys[keyID] = key
+
+        size := fileInfo.Size()
+        entries = append(entries, output.TarballEntry{
+            Name: entryName,
+            Size: EncryptedSize(size),
+            Write: func(w io.Writer) error {
+                digest, err := EncryptFile(w, filePath, size, bm.config)
+                if err != nil {
+                    return err
+                }
+                //manifest is written after all entries, so the digest can still go in
+                key := backupData.EncryptedKeys[keyID]
+                key.SHA256 = digest
+                backupData.EncryptedKeys[keyID] = key
+                return nil
+            },
+        })
+    }
+    return entries, nil
+}
+
+// statReadable opens the file to check access and returns its info
+func statReadable(filePath string) (os.FileInfo, error) {
+    file, err := os.Open(filePath)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    fileInfo, err := file.Stat()
+    if err != nil {
+        return nil, err
     }
-    return nil
+    if !fileInfo.Mode().IsRegular() {
+        return nil, fmt.Errorf("not a regular file")
+    }
+    return fileInfo, nil
 }
 
 // processCustomPaths converts custom paths to KeyLocation objects
-func (bm *BackupManager) processCustomPaths(customPaths []string) []KeyLocation {
+func (bm *BackupManager) processCustomPaths(customPaths []string, catalog *Catalog) []KeyLocation {
     var locations []KeyLocation
     for _, path := range customPaths {
         if path == "" {
@@ -142,7 +289,7 @@ func (bm *BackupManager) processCustomPaths(customPaths []string) []KeyLocation
             if len(files) > 0 {
                 locations = append(locations, KeyLocation{
                     Path:        path,
-                    Type:        "custom",
+                    Type:        catalog.TypeFor(path),
                     Files:       files,
                     IsDirectory: true,
                 })
@@ -151,7 +298,7 @@ func (bm *BackupManager) processCustomPaths(customPaths []string) []KeyLocation
             // Or Process single file
             locations = append(locations, KeyLocation{
                 Path:        path,
-                Type:        "custom",
+                Type:        catalog.TypeFor(path),
                 Files:       []string{path},
                 IsDirectory: false,
             })
@@ -180,7 +327,7 @@ func GetCustomPaths() []string {
     scanner := bufio.NewScanner(os.Stdin)
     fmt.Println("\nEnter additional key locations (one per line, empty line to finish):")
     fmt.Println("Examples: ~/mykeys/, /opt/certificates/, ~/.config/app/keys")
-    fmt.Println("Note: .ssh and .gnupg are default scouting locations")
+    fmt.Println("Note: .ssh, .gnupg and the other catalog locations are scouted by default")
     
     for {
         fmt.Print("Path: ")
@@ -196,3 +343,43 @@ func GetCustomPaths() []string {
     }
     return paths
 }
+
+// read a single trimmed line from stdin
+func readLine() string {
+    scanner := bufio.NewScanner(os.Stdin)
+    if !scanner.Scan() {
+        return ""
+    }
+    return strings.TrimSpace(scanner.Text())
+}
+
+// read a passphrase without echoing it when stdin is a terminal
+func ReadPassphrase(prompt string) ([]byte, error) {
+    fmt.Print(prompt)
+    fd := int(os.Stdin.Fd())
+    if !term.IsTerminal(fd) {
+        return []byte(readLine()), nil
+    }
+    passphrase, err := term.ReadPassword(fd)
+    fmt.Println()
+    return passphrase, err
+}
+
+// prompt for a new passphrase twice and make sure both match
+func ReadNewPassphrase() ([]byte, error) {
+    passphrase, err := ReadPassphrase("Backup passphrase: ")
+    if err != nil {
+        return nil, err
+    }
+    if len(passphrase) == 0 {
+        return nil, fmt.Errorf("passphrase cannot be empty")
+    }
+    confirm, err := ReadPassphrase("Repeat passphrase: ")
+    if err != nil {
+        return nil, err
+    }
+    if !bytes.Equal(passphrase, confirm) {
+        return nil, fmt.Errorf("passphrases do not match")
+    }
+    return passphrase, nil
+}
@@ -0,0 +1,73 @@
+package backup
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// ListBackup prints what a backup contains from its manifest alone, nothing is decrypted
+func ListBackup(tarballPath string, w io.Writer) error {
+	backupData, manifest, signature, err := output.ReadSignedBackup(tarballPath)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "Backup from %s@%s taken at %s\n",
+		backupData.SystemInfo.Username, backupData.SystemInfo.Hostname,
+		backupData.Timestamp.Format("2006-01-02 15:04:05"))
+	if err := checkSignature(manifest, signature); err != nil {
+		fmt.Fprintf(w, "Signature: NOT ACCEPTED (%v)\n", err)
+	} else {
+		fmt.Fprintf(w, "Signature: ok (%s)\n", signature.PublicKey)
+	}
+	if backupData.Parent != "" {
+		fmt.Fprintf(w, "Incremental on top of %s, %d files deleted since\n", backupData.Parent, len(backupData.Deleted))
+	}
+
+	for _, keyID := range sortedKeyIDs(backupData) {
+		key := backupData.EncryptedKeys[keyID]
+		fmt.Fprintf(w, "  %s  %s  %s\n", os.FileMode(key.Permissions).Perm(), key.OriginalPath, DescribeKey(key.Info))
+	}
+	if backupData.GPG != nil {
+		fmt.Fprintf(w, "GnuPG exports from %s, imported on restore:\n", backupData.GPG.Home)
+		for _, name := range sortedExportNames(backupData) {
+			fmt.Fprintf(w, "  %s  %d bytes\n", name, gpgExports(backupData)[name].Size)
+		}
+	}
+	return nil
+}
+
+// DescribeKey renders a KeyInfo on one line, e.g. "private-key openssh ssh-ed25519 256 SHA256:... (passphrase)"
+func DescribeKey(info *output.KeyInfo) string {
+	if info == nil {
+		return output.KindUnknown
+	}
+
+	parts := []string{info.Kind}
+	if info.Format != "" {
+		parts = append(parts, info.Format)
+	}
+	if info.Algorithm != "" {
+		parts = append(parts, info.Algorithm)
+	}
+	if info.Bits > 0 {
+		parts = append(parts, fmt.Sprint(info.Bits))
+	}
+	if info.Fingerprint != "" {
+		parts = append(parts, info.Fingerprint)
+	}
+	if info.Entries > 0 {
+		parts = append(parts, fmt.Sprintf("%d entries", info.Entries))
+	}
+	switch {
+	case info.Kind == output.KindPrivateKey && info.Encrypted:
+		parts = append(parts, "(passphrase)")
+	case info.Kind == output.KindPrivateKey && info.Format != "gpg-agent-shadowed":
+		parts = append(parts, "(no passphrase)")
+	}
+	return strings.Join(parts, " ")
+}
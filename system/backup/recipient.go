@@ -0,0 +1,279 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// text prefixes so keys are recognisable when pasted around
+const (
+	publicKeyPrefix = "x25519:"
+	secretKeyPrefix = "X25519-SECRET-KEY:"
+)
+
+// info string for deriving wrapping keys, changing it breaks every existing backup
+const wrapContext = "sysreplicate x25519 key wrap v1"
+
+// default file names inside the sysreplicate config dir
+const (
+	identityFileName   = "identity"
+	recipientsFileName = "recipients"
+)
+
+// configDir returns ~/.config/sysreplicate (or the XDG equivalent)
+func configDir() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sysreplicate"), nil
+}
+
+// DefaultIdentityPath is where the private key used for restores is kept
+func DefaultIdentityPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, identityFileName), nil
+}
+
+// DefaultRecipientsPath lists the public keys unattended backups are encrypted to
+func DefaultRecipientsPath() (string, error) {
+	dir, err := configDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, recipientsFileName), nil
+}
+
+// encode a public key for recipients files and backup.json
+func FormatPublicKey(key *ecdh.PublicKey) string {
+	return publicKeyPrefix + base64.StdEncoding.EncodeToString(key.Bytes())
+}
+
+// parse a public key written by FormatPublicKey
+func ParsePublicKey(text string) (*ecdh.PublicKey, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(text), publicKeyPrefix)
+	if !ok {
+		return nil, fmt.Errorf("public key must start with %q", publicKeyPrefix)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key encoding: %w", err)
+	}
+	return ecdh.X25519().NewPublicKey(raw)
+}
+
+// GenerateIdentity creates a new X25519 identity file with 0600 permissions and returns its public key
+func GenerateIdentity(path string) (*ecdh.PublicKey, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("identity %s already exists", path)
+	}
+
+	identity, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate identity: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s%s\n",
+		time.Now().Format(time.RFC3339),
+		FormatPublicKey(identity.PublicKey()),
+		secretKeyPrefix, base64.StdEncoding.EncodeToString(identity.Bytes()))
+
+	//O_EXCL so an identity is never silently replaced
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create identity file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(content); err != nil {
+		return nil, fmt.Errorf("failed to write identity file: %w", err)
+	}
+	return identity.PublicKey(), nil
+}
+
+// LoadIdentity reads the private key written by GenerateIdentity
+func LoadIdentity(path string) (*ecdh.PrivateKey, error) {
+	lines, err := readKeyLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, line := range lines {
+		encoded, ok := strings.CutPrefix(line, secretKeyPrefix)
+		if !ok {
+			continue
+		}
+		raw, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid identity encoding: %w", err)
+		}
+		return ecdh.X25519().NewPrivateKey(raw)
+	}
+	return nil, fmt.Errorf("no secret key found in %s", path)
+}
+
+// LoadRecipients reads one public key per line, ignoring blanks and # comments
+func LoadRecipients(path string) ([]*ecdh.PublicKey, error) {
+	lines, err := readKeyLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var recipients []*ecdh.PublicKey
+	for i, line := range lines {
+		recipient, err := ParsePublicKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: entry %d: %w", path, i+1, err)
+		}
+		recipients = append(recipients, recipient)
+	}
+	return recipients, nil
+}
+
+// readKeyLines returns the non-empty, non-comment lines of a key file
+func readKeyLines(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines, scanner.Err()
+}
+
+// NewRecipientConfig creates a random data key and wraps it to every recipient
+func NewRecipientConfig(recipients []*ecdh.PublicKey) (*EncryptionConfig, error) {
+	if len(recipients) == 0 {
+		return nil, fmt.Errorf("no recipients given")
+	}
+
+	key, err := GenerateKey()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	info := output.EncryptionInfo{Mode: output.EncryptionModeRecipients}
+	for _, recipient := range recipients {
+		stanza, err := wrapKey(key, recipient)
+		if err != nil {
+			return nil, err
+		}
+		info.Recipients = append(info.Recipients, stanza)
+	}
+
+	return &EncryptionConfig{Key: key, Info: info}, nil
+}
+
+// UnlockWithIdentity unwraps the data key from the stanza addressed to identity
+func UnlockWithIdentity(info output.EncryptionInfo, identity *ecdh.PrivateKey) (*EncryptionConfig, error) {
+	if info.Mode != output.EncryptionModeRecipients {
+		return nil, fmt.Errorf("backup is not encrypted to recipients")
+	}
+
+	publicKey := FormatPublicKey(identity.PublicKey())
+	for _, stanza := range info.Recipients {
+		if stanza.PublicKey != publicKey {
+			continue
+		}
+		key, err := unwrapKey(stanza, identity)
+		if err != nil {
+			return nil, err
+		}
+		return &EncryptionConfig{Key: key, Info: info}, nil
+	}
+	return nil, fmt.Errorf("backup is not encrypted to %s", publicKey)
+}
+
+// wrapKey encrypts the data key to one recipient using an ephemeral X25519 exchange
+func wrapKey(key []byte, recipient *ecdh.PublicKey) (output.RecipientStanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return output.RecipientStanza{}, fmt.Errorf("failed to generate ephemeral key: %w", err)
+	}
+
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return output.RecipientStanza{}, fmt.Errorf("key exchange failed: %w", err)
+	}
+
+	wrappingKey, err := deriveWrappingKey(shared, ephemeral.PublicKey(), recipient)
+	if err != nil {
+		return output.RecipientStanza{}, err
+	}
+
+	wrapped, err := EncryptData(key, &EncryptionConfig{Key: wrappingKey})
+	if err != nil {
+		return output.RecipientStanza{}, fmt.Errorf("failed to wrap data key: %w", err)
+	}
+
+	return output.RecipientStanza{
+		PublicKey:    FormatPublicKey(recipient),
+		EphemeralKey: base64.StdEncoding.EncodeToString(ephemeral.PublicKey().Bytes()),
+		WrappedKey:   wrapped,
+	}, nil
+}
+
+// unwrapKey reverses wrapKey with the recipient's private key
+func unwrapKey(stanza output.RecipientStanza, identity *ecdh.PrivateKey) ([]byte, error) {
+	raw, err := base64.StdEncoding.DecodeString(stanza.EphemeralKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key encoding: %w", err)
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ephemeral key: %w", err)
+	}
+
+	shared, err := identity.ECDH(ephemeral)
+	if err != nil {
+		return nil, fmt.Errorf("key exchange failed: %w", err)
+	}
+
+	wrappingKey, err := deriveWrappingKey(shared, ephemeral, identity.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := DecryptData(stanza.WrappedKey, &EncryptionConfig{Key: wrappingKey})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	return key, nil
+}
+
+// deriveWrappingKey binds the shared secret to both public keys like age does
+func deriveWrappingKey(shared []byte, ephemeral, recipient *ecdh.PublicKey) ([]byte, error) {
+	salt := append(ephemeral.Bytes(), recipient.Bytes()...)
+	key, err := hkdf.Key(sha256.New, shared, salt, wrapContext, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive wrapping key: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,117 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// testIdentity generates an identity file at path and loads it back
+func testIdentity(t *testing.T, path string) *ecdh.PrivateKey {
+	t.Helper()
+	publicKey, err := GenerateIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	identity, err := LoadIdentity(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !identity.PublicKey().Equal(publicKey) {
+		t.Fatalf("%s loads a different key than it was created with", path)
+	}
+	return identity
+}
+
+func TestRecipientWrapping(t *testing.T) {
+	dir := t.TempDir()
+	alice := testIdentity(t, filepath.Join(dir, "alice"))
+	bob := testIdentity(t, filepath.Join(dir, "bob"))
+	mallory := testIdentity(t, filepath.Join(dir, "mallory"))
+
+	config, err := NewRecipientConfig([]*ecdh.PublicKey{alice.PublicKey(), bob.PublicKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(config.Info.Recipients) != 2 {
+		t.Fatalf("got %d stanzas, want one per recipient", len(config.Info.Recipients))
+	}
+
+	//a stanza for alice relabelled with mallory's public key
+	relabelled := config.Info
+	relabelled.Recipients = []output.RecipientStanza{config.Info.Recipients[0]}
+	relabelled.Recipients[0].PublicKey = FormatPublicKey(mallory.PublicKey())
+
+	tampered := config.Info
+	tampered.Recipients = []output.RecipientStanza{config.Info.Recipients[0]}
+	wrapped := []byte(tampered.Recipients[0].WrappedKey)
+	wrapped[len(wrapped)/2] ^= 1
+	tampered.Recipients[0].WrappedKey = string(wrapped)
+
+	tests := []struct {
+		name     string
+		info     output.EncryptionInfo
+		identity *ecdh.PrivateKey
+		fails    bool
+	}{
+		{"first recipient", config.Info, alice, false},
+		{"second recipient", config.Info, bob, false},
+		{"not a recipient", config.Info, mallory, true},
+		{"wrong identity for the stanza", relabelled, mallory, true},
+		{"tampered wrapped key", tampered, alice, true},
+		{"passphrase backup", output.EncryptionInfo{Mode: output.EncryptionModePassphrase}, alice, true},
+	}
+	for _, test := range tests {
+		unlocked, err := UnlockWithIdentity(test.info, test.identity)
+		if (err != nil) != test.fails {
+			t.Errorf("%s: unlock returned %v", test.name, err)
+			continue
+		}
+		if err == nil && !bytes.Equal(unlocked.Key, config.Key) {
+			t.Errorf("%s: unwrapped a different data key", test.name)
+		}
+	}
+
+	if _, err := NewRecipientConfig(nil); err == nil {
+		t.Error("created a config without recipients")
+	}
+	if _, err := GenerateIdentity(filepath.Join(dir, "alice")); err == nil {
+		t.Error("replaced an existing identity")
+	}
+}
+
+func TestRecipientBackup(t *testing.T) {
+	home := testHome(t)
+	writeHomeFiles(t, home, map[string]string{".ssh/id_ed25519": "private key"})
+	alice := testIdentity(t, filepath.Join(home, "alice"))
+	mallory := testIdentity(t, filepath.Join(home, "mallory"))
+
+	bm := NewBackupManager()
+	bm.UseRecipients([]*ecdh.PublicKey{alice.PublicKey()})
+	tarballPath := createTestBackup(t, bm)
+
+	tests := []struct {
+		name     string
+		identity *ecdh.PrivateKey
+		fails    bool
+	}{
+		{"recipient", alice, false},
+		{"someone else", mallory, true},
+	}
+	for _, test := range tests {
+		os.RemoveAll(filepath.Join(home, ".ssh"))
+		bm := NewBackupManager()
+		bm.UseIdentity(test.identity)
+		if err := bm.RestoreBackup(tarballPath, false); (err != nil) != test.fails {
+			t.Errorf("%s: restore returned %v", test.name, err)
+		}
+		data, err := os.ReadFile(filepath.Join(home, ".ssh/id_ed25519"))
+		if restored := err == nil && string(data) == "private key"; restored == test.fails {
+			t.Errorf("%s: id_ed25519 holds %q (%v)", test.name, data, err)
+		}
+	}
+}
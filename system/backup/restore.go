@@ -0,0 +1,365 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// directories that must never be readable by other users
+var privateDirs = map[string]bool{
+	".ssh":   true,
+	".gnupg": true,
+}
+
+// RestoreBackup decrypts every key in the tarball and writes it back to its original path.
+// Existing files are skipped unless overwrite is set.
+func (bm *BackupManager) RestoreBackup(tarballPath string, overwrite bool) error {
+	fmt.Println("Starting key restore process...")
+
+	backupData, err := bm.readManifest(tarballPath)
+	if err != nil {
+		return fmt.Errorf("failed to read backup: %w", err)
+	}
+
+	config, err := bm.unlock(backupData)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Backup from %s@%s taken at %s\n",
+		backupData.SystemInfo.Username, backupData.SystemInfo.Hostname,
+		backupData.Timestamp.Format("2006-01-02 15:04:05"))
+
+	restored, skipped, failed := 0, 0, 0
+	//streamed keys grouped by the archive of the chain that holds their data
+	pending := make(map[string]map[string]output.EncryptedKey)
+	for _, keyID := range sortedKeyIDs(backupData) {
+		key := backupData.EncryptedKeys[keyID]
+
+		if _, err := os.Stat(key.OriginalPath); err == nil && !overwrite {
+			fmt.Printf("Skipping %s: file already exists\n", key.OriginalPath)
+			skipped++
+			continue
+		}
+
+		//version 1 backups keep the ciphertext inline in backup.json
+		if key.Entry == "" {
+			if err := restoreInlineKey(key, config); err != nil {
+				fmt.Printf("Warning: Failed to restore %s: %v\n", key.OriginalPath, err)
+				failed++
+				continue
+			}
+			restored++
+			continue
+		}
+
+		if pending[key.Source] == nil {
+			pending[key.Source] = make(map[string]output.EncryptedKey)
+		}
+		pending[key.Source][key.Entry] = key
+	}
+
+	//gpg exports are always read from this archive, even when every file is skipped
+	exports := gpgExports(backupData)
+	exported := make(map[string][]byte)
+	if len(exports) > 0 && pending[""] == nil {
+		pending[""] = make(map[string]output.EncryptedKey)
+	}
+
+	//stream the remaining keys straight from each archive, this one first
+	for _, source := range sortedSources(pending) {
+		keys := pending[source]
+		var known *output.BackupData
+		if source == "" {
+			known = backupData
+		}
+
+		err := bm.walkArchive(tarballPath, source, known, func(name string, r io.Reader, config *EncryptionConfig) error {
+			if export, ok := exports[name]; ok && source == "" {
+				data, err := readExport(export, r, config)
+				if err != nil {
+					fmt.Printf("Warning: Failed to read gpg export %s: %v\n", name, err)
+					failed++
+					return nil
+				}
+				exported[name] = data
+				return nil
+			}
+
+			key, ok := keys[name]
+			if !ok {
+				return nil
+			}
+			delete(keys, name)
+
+			if err := restoreStreamedKey(key, r, config); err != nil {
+				fmt.Printf("Warning: Failed to restore %s: %v\n", key.OriginalPath, err)
+				failed++
+				return nil
+			}
+			restored++
+			return nil
+		})
+		if err != nil {
+			fmt.Printf("Warning: Failed to read %s: %v\n", archiveLabel(tarballPath, source), err)
+		}
+
+		for _, key := range keys {
+			fmt.Printf("Warning: Failed to restore %s: data missing from %s\n", key.OriginalPath, archiveLabel(tarballPath, source))
+			failed++
+		}
+	}
+
+	//imported after the keyring files, so the exports merge into the restored keyring
+	if len(exported) > 0 {
+		fmt.Println("Importing GnuPG secret keys and ownertrust into", backupData.GPG.Home)
+		if err := importGPG(backupData.GPG, exported); err != nil {
+			fmt.Printf("Warning: GnuPG import failed: %v\n", err)
+			failed++
+		}
+	}
+
+	if len(backupData.Deleted) > 0 {
+		fmt.Printf("%d files were deleted before this backup and are not restored\n", len(backupData.Deleted))
+	}
+	fmt.Printf("Restored %d key files (%d skipped, %d failed)\n", restored, skipped, failed)
+	if failed > 0 {
+		return fmt.Errorf("%d key files could not be restored", failed)
+	}
+	return nil
+}
+
+// entryVisitor receives a raw encrypted entry and the config that decrypts it
+type entryVisitor func(name string, r io.Reader, config *EncryptionConfig) error
+
+// walkArchive unlocks one archive of a backup chain and visits all of its entries.
+// backupData may be passed when the archive's manifest has already been read.
+func (bm *BackupManager) walkArchive(tarballPath, source string, backupData *output.BackupData, visit entryVisitor) error {
+	archivePath, err := sourcePath(tarballPath, source)
+	if err != nil {
+		return err
+	}
+
+	if backupData == nil {
+		backupData, err = bm.readManifest(archivePath)
+		if err != nil {
+			return err
+		}
+	}
+
+	//every archive of a chain has its own data key
+	config, err := bm.unlock(backupData)
+	if err != nil {
+		return err
+	}
+
+	return output.WalkBackupEntries(archivePath, func(name string, r io.Reader) error {
+		return visit(name, r, config)
+	})
+}
+
+// sortedSources orders chain archives with the current one ("") first
+func sortedSources(pending map[string]map[string]output.EncryptedKey) []string {
+	sources := make([]string, 0, len(pending))
+	for source := range pending {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+	return sources
+}
+
+// archiveLabel names the archive a source refers to in messages
+func archiveLabel(tarballPath, source string) string {
+	if source == "" {
+		return filepath.Base(tarballPath)
+	}
+	return source
+}
+
+// sortedKeyIDs gives a stable order so output and parent directory handling are predictable
+func sortedKeyIDs(backupData *output.BackupData) []string {
+	keyIDs := make([]string, 0, len(backupData.EncryptedKeys))
+	for keyID := range backupData.EncryptedKeys {
+		keyIDs = append(keyIDs, keyID)
+	}
+	sort.Strings(keyIDs)
+	return keyIDs
+}
+
+// unlock returns the decryption config for the backup's encryption mode,
+// prompting for the passphrase at most once per manager
+func (bm *BackupManager) unlock(backupData *output.BackupData) (*EncryptionConfig, error) {
+	//a backup this manager just created can be checked without prompting again
+	if bm.config != nil && backupData.Encryption.Mode != output.EncryptionModeLegacy &&
+		reflect.DeepEqual(bm.config.Info, backupData.Encryption) {
+		return bm.config, nil
+	}
+
+	switch backupData.Encryption.Mode {
+	case output.EncryptionModePassphrase:
+		if len(bm.passphrase) == 0 {
+			passphrase, err := ReadPassphrase("Backup passphrase: ")
+			if err != nil {
+				return nil, fmt.Errorf("failed to read passphrase: %w", err)
+			}
+			bm.passphrase = passphrase
+		}
+		return UnlockWithPassphrase(backupData.Encryption, bm.passphrase)
+	case output.EncryptionModeRecipients:
+		if bm.identity == nil {
+			identityPath, err := DefaultIdentityPath()
+			if err != nil {
+				return nil, err
+			}
+			identity, err := LoadIdentity(identityPath)
+			if err != nil {
+				return nil, fmt.Errorf("failed to load identity: %w", err)
+			}
+			bm.identity = identity
+		}
+		return UnlockWithIdentity(backupData.Encryption, bm.identity)
+	case output.EncryptionModeLegacy:
+		//older backups carried their key in backup.json
+		if len(backupData.EncryptionKey) == 0 {
+			return nil, fmt.Errorf("backup does not contain an encryption key")
+		}
+		return &EncryptionConfig{Key: backupData.EncryptionKey}, nil
+	default:
+		return nil, fmt.Errorf("unsupported encryption mode %q", backupData.Encryption.Mode)
+	}
+}
+
+// restoreInlineKey decrypts a version 1 key stored in backup.json
+func restoreInlineKey(key output.EncryptedKey, config *EncryptionConfig) error {
+	data, err := DecryptData(key.EncryptedData, config)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(key, bytes.NewReader(data))
+}
+
+// restoreStreamedKey decrypts a key from its tar entry
+func restoreStreamedKey(key output.EncryptedKey, r io.Reader, config *EncryptionConfig) error {
+	plaintext, err := NewDecryptReader(r, config)
+	if err != nil {
+		return err
+	}
+	return writeKeyFile(key, plaintext)
+}
+
+// writeKeyFile writes the plaintext next to the target and renames it into place,
+// so a failed authentication or hash mismatch never leaves a partial key behind
+func writeKeyFile(key output.EncryptedKey, plaintext io.Reader) error {
+	if !filepath.IsAbs(key.OriginalPath) {
+		return fmt.Errorf("refusing to restore relative path")
+	}
+
+	dir := filepath.Dir(key.OriginalPath)
+	if err := ensureParentDir(dir); err != nil {
+		return err
+	}
+
+	//CreateTemp uses 0600 so the plaintext is never readable by others
+	tmp, err := os.CreateTemp(dir, ".sysreplicate-restore-*")
+	if err != nil {
+		return fmt.Errorf("failed to create file: %w", err)
+	}
+	defer os.Remove(tmp.Name()) //no-op once renamed
+
+	//data of incremental backups may come from another archive, make sure it is the recorded one
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), plaintext); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write file: %w", err)
+	}
+	if key.SHA256 != "" && hex.EncodeToString(hash.Sum(nil)) != key.SHA256 {
+		return fmt.Errorf("sha256 does not match recorded hash")
+	}
+
+	if err := os.Chmod(tmp.Name(), os.FileMode(key.Permissions).Perm()); err != nil {
+		return fmt.Errorf("failed to set permissions: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), key.OriginalPath); err != nil {
+		return fmt.Errorf("failed to move file into place: %w", err)
+	}
+	return nil
+}
+
+// ensureParentDir creates the directory and tightens modes of key directories like ~/.ssh
+func ensureParentDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", dir, err)
+	}
+
+	//walk up so ~/.gnupg is fixed for ~/.gnupg/private-keys-v1.d as well
+	for current := dir; current != filepath.Dir(current); current = filepath.Dir(current) {
+		if privateDirs[filepath.Base(current)] {
+			if err := os.Chmod(current, 0700); err != nil {
+				return fmt.Errorf("failed to set permissions on %s: %w", current, err)
+			}
+		}
+	}
+	return nil
+}
+
+// FindBackups lists key backup tarballs in the given directory, newest first
+func FindBackups(dir string) ([]string, error) {
+	matches, err := filepath.Glob(filepath.Join(dir, "key-backup-*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	//names embed a sortable timestamp
+	sort.Sort(sort.Reverse(sort.StringSlice(matches)))
+	return matches, nil
+}
+
+// prompt the user for the backup tarball to restore
+func GetRestorePath(dir string) string {
+	backups, _ := FindBackups(dir)
+
+	fmt.Println("\nAvailable backups:")
+	if len(backups) == 0 {
+		fmt.Println("  (none found in " + dir + ")")
+	}
+	for _, backup := range backups {
+		fmt.Println("  " + backup)
+	}
+
+	defaultPath := ""
+	if len(backups) > 0 {
+		defaultPath = backups[0]
+		fmt.Printf("Backup to restore [%s]: ", defaultPath)
+	} else {
+		fmt.Print("Backup to restore: ")
+	}
+
+	path := readLine()
+	if path == "" {
+		return defaultPath
+	}
+	if strings.HasPrefix(path, "~/") {
+		homeDir, _ := os.UserHomeDir()
+		path = filepath.Join(homeDir, path[2:])
+	}
+	return path
+}
+
+// ask a yes/no question, defaulting to no
+func Confirm(question string) bool {
+	fmt.Printf("%s (y/N): ", question)
+	answer := strings.ToLower(readLine())
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,120 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+var testPassphrase = []byte("correct horse battery staple")
+
+// testHome points HOME, the config dir and GNUPGHOME at a temporary home and runs the test in
+// an empty directory, so backups go to its dist directory
+func testHome(t *testing.T) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("GNUPGHOME", filepath.Join(home, ".gnupg"))
+	t.Chdir(t.TempDir())
+	if err := os.Mkdir(backupDir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return home
+}
+
+// writeHomeFiles creates files below home readable by the owner only
+func writeHomeFiles(t *testing.T, home string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(home, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// createTestBackup backs up the catalog locations of the test home
+func createTestBackup(t *testing.T, bm *BackupManager) string {
+	t.Helper()
+	tarballPath, err := bm.CreateBackup(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tarballPath == "" {
+		t.Fatal("nothing was backed up")
+	}
+	return tarballPath
+}
+
+func passphraseManager(passphrase []byte) *BackupManager {
+	bm := NewBackupManager()
+	bm.UsePassphrase(passphrase)
+	return bm
+}
+
+func TestRestoreBackup(t *testing.T) {
+	home := testHome(t)
+	original := map[string]string{
+		".ssh/id_ed25519":     "private key",
+		".ssh/id_ed25519.pub": "ssh-ed25519 AAAA test",
+		".aws/credentials":    "[default]\naws_access_key_id = AKIA\n",
+	}
+	writeHomeFiles(t, home, original)
+	tarballPath := createTestBackup(t, passphraseManager(testPassphrase))
+
+	changed := filepath.Join(home, ".ssh/id_ed25519")
+	tests := []struct {
+		name       string
+		passphrase []byte
+		overwrite  bool
+		prepare    func()
+		fails      bool
+		want       string // content of .ssh/id_ed25519 afterwards
+	}{
+		{"missing files are restored", testPassphrase, false, func() { os.RemoveAll(filepath.Join(home, ".ssh")) }, false, "private key"},
+		{"existing files are kept", testPassphrase, false, func() { os.WriteFile(changed, []byte("changed"), 0600) }, false, "changed"},
+		{"overwrite replaces existing files", testPassphrase, true, func() { os.WriteFile(changed, []byte("changed"), 0600) }, false, "private key"},
+		{"wrong passphrase", []byte("wrong"), true, func() { os.WriteFile(changed, []byte("changed"), 0600) }, true, "changed"},
+	}
+	for _, test := range tests {
+		test.prepare()
+		err := passphraseManager(test.passphrase).RestoreBackup(tarballPath, test.overwrite)
+		if (err != nil) != test.fails {
+			t.Errorf("%s: restore returned %v", test.name, err)
+		}
+		data, err := os.ReadFile(changed)
+		if err != nil || string(data) != test.want {
+			t.Errorf("%s: id_ed25519 holds %q (%v), want %q", test.name, data, err, test.want)
+		}
+		if test.fails {
+			continue
+		}
+		for name, content := range original {
+			path := filepath.Join(home, name)
+			if path == changed {
+				continue
+			}
+			data, err := os.ReadFile(path)
+			if err != nil || string(data) != content {
+				t.Errorf("%s: %s holds %q (%v)", test.name, name, data, err)
+			}
+		}
+	}
+
+	//keys are restored readable by the owner only, inside a private .ssh
+	info, err := os.Stat(changed)
+	if err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("restored key mode %v (%v), want 0600", info.Mode().Perm(), err)
+	}
+	os.RemoveAll(filepath.Join(home, ".ssh"))
+	if err := passphraseManager(testPassphrase).RestoreBackup(tarballPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filepath.Join(home, ".ssh")); err != nil || info.Mode().Perm() != 0700 {
+		t.Errorf("restored .ssh mode %v (%v), want 0700", info.Mode().Perm(), err)
+	}
+}
@@ -6,72 +6,17 @@ import (
     "strings"
 )
 
-// common location of keys - more can be added here
-var StandardKeyLocations = []string{
-    "~/.ssh/",
-    "~/.gnupg/",
-}
-
 // any saved keylcoation
 type KeyLocation struct {
         Path        string
 
-        Type        string // "ssh", "gpg", "custom" strongs can be stored
+        Type        string // catalog entry type like "ssh", "gpg", "aws", or "custom"
         
         Files       []string
         
         IsDirectory bool
 }
 
-// searches for keys in standard locations
-func searchStandardLocations() ([]KeyLocation, error) {
-    var locations []KeyLocation
-    homeDir, err := os.UserHomeDir()
-    if err != nil {
-        return nil, err
-    }
-
-    for _, location := range StandardKeyLocations {
-        // reeplace ~operator with actual home directory
-        fullPath := strings.Replace(location, "~", homeDir, 1)
-        
-        if _, err := os.Stat(fullPath); os.IsNotExist(err) {
-            continue // skip on dir invalid
-        }
-
-        
-        keyType := determineKeyType(fullPath)
-        files, err := discoverKeyFiles(fullPath)
-        if err != nil {
-            continue
-
-        }
-
-        if len(files) > 0 {
-            locations = append(locations, KeyLocation{
-                
-                Path:        fullPath,
-                Type:        keyType,
-                Files:       files,
-                IsDirectory: true,
-            })
-        }
-    }
-
-    return locations, nil
-}
-
-// determineKeyType identifies the type of keys based on directory path
-func determineKeyType(path string) string {
-    if strings.Contains(path, ".ssh") {
-        return "ssh"
-    }
-    if strings.Contains(path, ".gnupg") {
-        return "gpg"
-    }
-    return "custom"
-}
-
 // discoverKeyFiles finds all key files in a directory
 func discoverKeyFiles(dirPath string) ([]string, error) {
     var keyFiles []string
@@ -0,0 +1,231 @@
+package backup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// text prefixes for signing keys, kept distinct from the X25519 encryption keys
+const (
+	signingPublicPrefix = "ed25519:"
+	signingSecretPrefix = "ED25519-SIGNING-KEY:"
+)
+
+// default file names inside the sysreplicate config dir
+const (
+	signingKeyFileName     = "signing.key"
+	trustedSignersFileName = "trusted_signers"
+)
+
+// ErrUnsigned is returned for archives without a manifest signature
+var ErrUnsigned = errors.New("backup manifest is not signed")
+
+// SignatureError explains why a manifest signature was not accepted
+type SignatureError struct {
+	PublicKey string
+	Reason    string
+	// Valid is set when the signature checks out but the signer is not trusted yet
+	Valid bool
+}
+
+func (e *SignatureError) Error() string {
+	return fmt.Sprintf("manifest signature by %s %s", e.PublicKey, e.Reason)
+}
+
+// SetForce allows restoring and verifying archives whose signature is missing or not accepted
+func (bm *BackupManager) SetForce(force bool) {
+	bm.force = force
+}
+
+// FormatSigningKey encodes a signing public key for trusted_signers and backup.json.sig
+func FormatSigningKey(key ed25519.PublicKey) string {
+	return signingPublicPrefix + base64.StdEncoding.EncodeToString(key)
+}
+
+// ParseSigningKey parses a key written by FormatSigningKey
+func ParseSigningKey(text string) (ed25519.PublicKey, error) {
+	encoded, ok := strings.CutPrefix(strings.TrimSpace(text), signingPublicPrefix)
+	if !ok {
+		return nil, fmt.Errorf("signing key must start with %q", signingPublicPrefix)
+	}
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid signing key encoding: %w", err)
+	}
+	if len(raw) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid signing key length")
+	}
+	return ed25519.PublicKey(raw), nil
+}
+
+// loadOrCreateSigningKey returns the user's signing key, creating it with 0600 on first use
+func loadOrCreateSigningKey() (ed25519.PrivateKey, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+	path := filepath.Join(dir, signingKeyFileName)
+
+	lines, err := readKeyLines(path)
+	if err == nil {
+		for _, line := range lines {
+			encoded, ok := strings.CutPrefix(line, signingSecretPrefix)
+			if !ok {
+				continue
+			}
+			seed, err := base64.StdEncoding.DecodeString(encoded)
+			if err != nil || len(seed) != ed25519.SeedSize {
+				return nil, fmt.Errorf("invalid signing key in %s", path)
+			}
+			return ed25519.NewKeyFromSeed(seed), nil
+		}
+		return nil, fmt.Errorf("no signing key found in %s", path)
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %w", err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	content := fmt.Sprintf("# created: %s\n# public key: %s\n%s%s\n",
+		time.Now().Format(time.RFC3339), FormatSigningKey(publicKey),
+		signingSecretPrefix, base64.StdEncoding.EncodeToString(privateKey.Seed()))
+
+	//O_EXCL so a concurrent run never replaces a key that already signed something
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create signing key: %w", err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(content); err != nil {
+		return nil, fmt.Errorf("failed to write signing key: %w", err)
+	}
+
+	fmt.Println("Created backup signing key:", path)
+	fmt.Println("Signer:", FormatSigningKey(publicKey))
+	return privateKey, nil
+}
+
+// manifestSigner signs backup.json with the given key
+func manifestSigner(key ed25519.PrivateKey) func([]byte) (*output.ManifestSignature, error) {
+	return func(manifest []byte) (*output.ManifestSignature, error) {
+		return &output.ManifestSignature{
+			Algorithm: "ed25519",
+			PublicKey: FormatSigningKey(key.Public().(ed25519.PublicKey)),
+			Signature: ed25519.Sign(key, manifest),
+		}, nil
+	}
+}
+
+// TrustedSigners returns the signer keys accepted on restore: the trusted_signers file plus
+// this user's own signing key
+func TrustedSigners() (map[string]bool, error) {
+	dir, err := configDir()
+	if err != nil {
+		return nil, err
+	}
+
+	trusted := make(map[string]bool)
+	lines, err := readKeyLines(filepath.Join(dir, trustedSignersFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range lines {
+		key, err := ParseSigningKey(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", trustedSignersFileName, err)
+		}
+		trusted[FormatSigningKey(key)] = true
+	}
+
+	//never create a key just to read it
+	if _, err := os.Stat(filepath.Join(dir, signingKeyFileName)); err == nil {
+		own, err := loadOrCreateSigningKey()
+		if err != nil {
+			return nil, err
+		}
+		trusted[FormatSigningKey(own.Public().(ed25519.PublicKey))] = true
+	}
+	return trusted, nil
+}
+
+// TrustSigner appends a signer key to trusted_signers
+func TrustSigner(publicKey string) error {
+	key, err := ParseSigningKey(publicKey)
+	if err != nil {
+		return err
+	}
+	dir, err := configDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, trustedSignersFileName), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "# added: %s\n%s\n", time.Now().Format(time.RFC3339), FormatSigningKey(key))
+	return err
+}
+
+// checkSignature verifies a manifest signature and that its signer is trusted
+func checkSignature(manifest []byte, signature *output.ManifestSignature) error {
+	if signature == nil {
+		return ErrUnsigned
+	}
+	if signature.Algorithm != "ed25519" {
+		return &SignatureError{PublicKey: signature.PublicKey, Reason: fmt.Sprintf("uses unsupported algorithm %q", signature.Algorithm)}
+	}
+
+	key, err := ParseSigningKey(signature.PublicKey)
+	if err != nil {
+		return &SignatureError{PublicKey: signature.PublicKey, Reason: err.Error()}
+	}
+	if !ed25519.Verify(key, manifest, signature.Signature) {
+		return &SignatureError{PublicKey: signature.PublicKey, Reason: "does not match the manifest"}
+	}
+
+	trusted, err := TrustedSigners()
+	if err != nil {
+		return err
+	}
+	if !trusted[FormatSigningKey(key)] {
+		return &SignatureError{PublicKey: signature.PublicKey, Reason: "is valid but the signer is not trusted", Valid: true}
+	}
+	return nil
+}
+
+// readManifest reads an archive's manifest and refuses it unless its signature is accepted or forced
+func (bm *BackupManager) readManifest(tarballPath string) (*output.BackupData, error) {
+	backupData, manifest, signature, err := output.ReadSignedBackup(tarballPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := checkSignature(manifest, signature); err != nil {
+		if !bm.force {
+			return nil, fmt.Errorf("%s: %w", filepath.Base(tarballPath), err)
+		}
+		fmt.Printf("Warning: %s: %v, continuing because of force\n", filepath.Base(tarballPath), err)
+	}
+	return backupData, nil
+}
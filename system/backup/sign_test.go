@@ -0,0 +1,134 @@
+package backup
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+func TestCheckSignature(t *testing.T) {
+	testHome(t)
+	own, err := loadOrCreateSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifest := []byte(`{"version": 3}`)
+	sign := func(key ed25519.PrivateKey, edit func(*output.ManifestSignature)) *output.ManifestSignature {
+		signature, _ := manifestSigner(key)(manifest)
+		if edit != nil {
+			edit(signature)
+		}
+		return signature
+	}
+
+	tests := []struct {
+		name      string
+		manifest  []byte
+		signature *output.ManifestSignature
+		want      string // "", "unsigned" or "rejected"
+		valid     bool   // a valid signature of an untrusted signer
+	}{
+		{"own key", manifest, sign(own, nil), "", false},
+		{"unsigned", manifest, nil, "unsigned", false},
+		{"tampered manifest", []byte(`{"version": 4}`), sign(own, nil), "rejected", false},
+		{"tampered signature", manifest, sign(own, func(s *output.ManifestSignature) { s.Signature[0] ^= 1 }), "rejected", false},
+		{"unknown algorithm", manifest, sign(own, func(s *output.ManifestSignature) { s.Algorithm = "rsa" }), "rejected", false},
+		{"broken public key", manifest, sign(own, func(s *output.ManifestSignature) { s.PublicKey = "ed25519:AAAA" }), "rejected", false},
+		{"swapped public key", manifest, sign(own, func(s *output.ManifestSignature) { s.PublicKey = FormatSigningKey(other.Public().(ed25519.PublicKey)) }), "rejected", false},
+		{"untrusted signer", manifest, sign(other, nil), "rejected", true},
+	}
+	for _, test := range tests {
+		err := checkSignature(test.manifest, test.signature)
+		var signatureErr *SignatureError
+		got := ""
+		if errors.Is(err, ErrUnsigned) {
+			got = "unsigned"
+		} else if errors.As(err, &signatureErr) {
+			got = "rejected"
+		} else if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if got != test.want || (signatureErr != nil && signatureErr.Valid != test.valid) {
+			t.Errorf("%s: got %v, want %s (valid %v)", test.name, err, test.want, test.valid)
+		}
+	}
+
+	//trusting the other signer accepts its backups from then on
+	if err := TrustSigner(FormatSigningKey(other.Public().(ed25519.PublicKey))); err != nil {
+		t.Fatal(err)
+	}
+	if err := checkSignature(manifest, sign(other, nil)); err != nil {
+		t.Errorf("trusted signer: %v", err)
+	}
+	if err := TrustSigner("ssh-ed25519 AAAA"); err == nil {
+		t.Error("trusted a key that is not a signing key")
+	}
+}
+
+func TestSignedBackup(t *testing.T) {
+	home := testHome(t)
+	writeHomeFiles(t, home, map[string]string{".ssh/id_ed25519": "private key"})
+	tarballPath := createTestBackup(t, passphraseManager(testPassphrase))
+
+	tamperSignature := func(name string, data []byte) []byte {
+		if name != output.SignatureName {
+			return data
+		}
+		var signature output.ManifestSignature
+		if err := json.Unmarshal(data, &signature); err != nil {
+			t.Fatal(err)
+		}
+		signature.Signature[len(signature.Signature)-1] ^= 1
+		data, err := json.Marshal(signature)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+	tests := []struct {
+		name  string
+		edit  func(name string, data []byte) []byte
+		force bool
+		fails bool
+	}{
+		{"signed by this user", func(name string, data []byte) []byte { return data }, false, false},
+		{"tampered signature", tamperSignature, false, true},
+		{"tampered signature with force", tamperSignature, true, false},
+		{"manifest changed by one byte", func(name string, data []byte) []byte {
+			if name == output.ManifestName {
+				return append(data, ' ')
+			}
+			return data
+		}, false, true},
+		{"signature dropped", func(name string, data []byte) []byte {
+			if name == output.SignatureName {
+				return nil
+			}
+			return data
+		}, false, true},
+	}
+	for _, test := range tests {
+		copyPath := tarballPath + "." + strings.ReplaceAll(test.name, " ", "-") + ".tar.gz"
+		rewriteArchive(t, tarballPath, copyPath, test.edit)
+		bm := passphraseManager(testPassphrase)
+		bm.SetForce(test.force)
+		if err := bm.RestoreBackup(copyPath, true); (err != nil) != test.fails {
+			t.Errorf("%s: restore returned %v", test.name, err)
+		}
+		report, err := bm.VerifyBackup(copyPath)
+		if err != nil || report.Failed() != test.fails {
+			t.Errorf("%s: verify failed %v, %v", test.name, report != nil && report.Failed(), err)
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package backup
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Streamed files are encrypted in fixed size chunks so memory use does not depend on file size.
+//
+// layout: magic | 16 byte salt | chunk... where every chunk is AES-GCM sealed with a per-file key
+// derived from the data key and salt. The nonce is a big-endian chunk counter followed by a flag
+// byte that is 1 only for the final chunk, so reordering, truncation and appending are detected.
+const (
+	streamMagic    = "SRS1"
+	streamSaltLen  = 16
+	streamChunkLen = 64 * 1024
+	streamTagLen   = 16
+	streamHeadLen  = len(streamMagic) + streamSaltLen
+
+	//info string for per-file keys, changing it breaks every existing backup
+	streamContext = "sysreplicate stream v1"
+)
+
+// EncryptedSize returns the exact ciphertext length for a plaintext of the given size,
+// tar headers need it before any data is written
+func EncryptedSize(plainSize int64) int64 {
+	chunks := (plainSize + streamChunkLen - 1) / streamChunkLen
+	if chunks == 0 {
+		chunks = 1 //empty files still carry one authenticated final chunk
+	}
+	return int64(streamHeadLen) + plainSize + chunks*streamTagLen
+}
+
+// streamWriter buffers one chunk and seals it once it knows whether more data follows
+type streamWriter struct {
+	dst     io.Writer
+	aead    cipher.AEAD
+	buf     []byte
+	counter uint64
+	closed  bool
+}
+
+// NewEncryptWriter returns a writer that encrypts everything written to it into dst.
+// Close must be called to write the final chunk.
+func NewEncryptWriter(dst io.Writer, config *EncryptionConfig) (io.WriteCloser, error) {
+	salt := make([]byte, streamSaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %w", err)
+	}
+
+	aead, err := newStreamAEAD(config.Key, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := io.WriteString(dst, streamMagic); err != nil {
+		return nil, err
+	}
+	if _, err := dst.Write(salt); err != nil {
+		return nil, err
+	}
+
+	return &streamWriter{
+		dst:  dst,
+		aead: aead,
+		buf:  make([]byte, 0, streamChunkLen),
+	}, nil
+}
+
+func (w *streamWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("write to closed stream")
+	}
+
+	written := 0
+	for len(p) > 0 {
+		//only flush a full chunk once more data arrives, it may be the final one
+		if len(w.buf) == streamChunkLen {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):streamChunkLen], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *streamWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	return w.flush(true)
+}
+
+func (w *streamWriter) flush(last bool) error {
+	sealed := w.aead.Seal(nil, streamNonce(w.counter, last), w.buf, nil)
+	if _, err := w.dst.Write(sealed); err != nil {
+		return err
+	}
+	w.counter++
+	w.buf = w.buf[:0]
+	return nil
+}
+
+// streamReader decrypts and authenticates one chunk at a time
+type streamReader struct {
+	src     *bufio.Reader
+	aead    cipher.AEAD
+	chunk   []byte
+	plain   []byte
+	counter uint64
+	done    bool
+}
+
+// NewDecryptReader returns a reader yielding the plaintext of a stream written by NewEncryptWriter.
+// Data is only returned after its chunk has been authenticated.
+func NewDecryptReader(src io.Reader, config *EncryptionConfig) (io.Reader, error) {
+	header := make([]byte, streamHeadLen)
+	if _, err := io.ReadFull(src, header); err != nil {
+		return nil, fmt.Errorf("failed to read stream header: %w", err)
+	}
+	if string(header[:len(streamMagic)]) != streamMagic {
+		return nil, errors.New("not an encrypted stream")
+	}
+
+	aead, err := newStreamAEAD(config.Key, header[len(streamMagic):])
+	if err != nil {
+		return nil, err
+	}
+
+	return &streamReader{
+		src:   bufio.NewReaderSize(src, streamChunkLen+streamTagLen),
+		aead:  aead,
+		chunk: make([]byte, streamChunkLen+streamTagLen),
+	}, nil
+}
+
+func (r *streamReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+func (r *streamReader) next() error {
+	n, err := io.ReadFull(r.src, r.chunk)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	//a short chunk, or a full one with nothing after it, has to be the final chunk
+	last := n < len(r.chunk)
+	if !last {
+		if _, err := r.src.Peek(1); err == io.EOF {
+			last = true
+		}
+	}
+
+	plain, err := r.aead.Open(r.chunk[:0], streamNonce(r.counter, last), r.chunk[:n], nil)
+	if err != nil {
+		return fmt.Errorf("chunk %d failed authentication", r.counter)
+	}
+	r.counter++
+	r.plain = plain
+	r.done = last
+	return nil
+}
+
+// newStreamAEAD derives the per-file key from the data key and salt
+func newStreamAEAD(key, salt []byte) (cipher.AEAD, error) {
+	fileKey, err := hkdf.Key(sha256.New, key, salt, streamContext, keyLen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive file key: %w", err)
+	}
+	block, err := aes.NewCipher(fileKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// streamNonce is 11 bytes of counter and one final-chunk flag byte
+func streamNonce(counter uint64, last bool) []byte {
+	nonce := make([]byte, 12)
+	binary.BigEndian.PutUint64(nonce[3:11], counter)
+	if last {
+		nonce[11] = 1
+	}
+	return nonce
+}
@@ -0,0 +1,155 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"testing"
+)
+
+func testConfig(t *testing.T) *EncryptionConfig {
+	t.Helper()
+	key := make([]byte, keyLen)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return &EncryptionConfig{Key: key}
+}
+
+func encryptStream(t *testing.T, config *EncryptionConfig, plain []byte) []byte {
+	t.Helper()
+	var out bytes.Buffer
+	w, err := NewEncryptWriter(&out, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(plain); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+func decryptStream(config *EncryptionConfig, sealed []byte) ([]byte, error) {
+	r, err := NewDecryptReader(bytes.NewReader(sealed), config)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(r)
+}
+
+func randomBytes(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	config := testConfig(t)
+	for _, size := range []int{0, 1, streamChunkLen - 1, streamChunkLen, streamChunkLen + 1, 3 * streamChunkLen} {
+		plain := randomBytes(t, size)
+		sealed := encryptStream(t, config, plain)
+		if int64(len(sealed)) != EncryptedSize(int64(size)) {
+			t.Errorf("%d bytes: sealed to %d bytes, EncryptedSize says %d", size, len(sealed), EncryptedSize(int64(size)))
+		}
+		got, err := decryptStream(config, sealed)
+		if err != nil {
+			t.Errorf("%d bytes: %v", size, err)
+			continue
+		}
+		if !bytes.Equal(got, plain) {
+			t.Errorf("%d bytes: decrypted data differs", size)
+		}
+	}
+}
+
+func TestStreamRoundTripSmallWrites(t *testing.T) {
+	config := testConfig(t)
+	plain := randomBytes(t, streamChunkLen+1)
+	var out bytes.Buffer
+	w, err := NewEncryptWriter(&out, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < len(plain); i += 1000 {
+		if _, err := w.Write(plain[i:min(i+1000, len(plain))]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out.Bytes()[:len(streamMagic)], []byte(streamMagic)) {
+		t.Errorf("stream does not start with %s", streamMagic)
+	}
+	got, err := decryptStream(config, out.Bytes())
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("decrypting chunked writes: %v", err)
+	}
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	config := testConfig(t)
+	// three chunks, the last one short
+	plain := randomBytes(t, 2*streamChunkLen+100)
+	sealed := encryptStream(t, config, plain)
+	sealedChunk := streamChunkLen + streamTagLen
+	chunk := func(i int) []byte {
+		start := streamHeadLen + i*sealedChunk
+		return sealed[start:min(start+sealedChunk, len(sealed))]
+	}
+	join := func(parts ...[]byte) []byte {
+		return bytes.Join(parts, nil)
+	}
+	header := sealed[:streamHeadLen]
+
+	flippedTag := bytes.Clone(sealed)
+	flippedTag[streamHeadLen+sealedChunk-1] ^= 1
+
+	tests := []struct {
+		name   string
+		sealed []byte
+		config *EncryptionConfig
+	}{
+		{"header only", header, config},
+		{"truncated after the first chunk", join(header, chunk(0)), config},
+		{"truncated after the second chunk", join(header, chunk(0), chunk(1)), config},
+		{"truncated inside the last chunk", sealed[:len(sealed)-1], config},
+		{"swapped chunks", join(header, chunk(1), chunk(0), chunk(2)), config},
+		{"last chunk dropped before a repeated one", join(header, chunk(0), chunk(1), chunk(1)), config},
+		{"appended data", join(sealed, []byte{0}), config},
+		{"flipped tag byte", flippedTag, config},
+		{"wrong key", sealed, testConfig(t)},
+	}
+	for _, test := range tests {
+		if _, err := decryptStream(test.config, test.sealed); err == nil {
+			t.Errorf("%s: decrypted without error", test.name)
+		}
+	}
+
+	if _, err := decryptStream(config, join([]byte("XXXX"), sealed[len(streamMagic):])); err == nil {
+		t.Error("a stream without the magic was accepted")
+	}
+}
+
+func TestStreamRejectsTruncatedFullChunk(t *testing.T) {
+	// a stream of exactly one full chunk must not be accepted without its final flag: cut
+	// the last chunk off a two chunk stream and the first one alone is not final
+	config := testConfig(t)
+	sealed := encryptStream(t, config, randomBytes(t, streamChunkLen+1))
+	if _, err := decryptStream(config, sealed[:streamHeadLen+streamChunkLen+streamTagLen]); err == nil {
+		t.Error("a stream truncated after a full chunk decrypted")
+	}
+
+	// the same length sealed as the final chunk is fine
+	plain := randomBytes(t, streamChunkLen)
+	got, err := decryptStream(config, encryptStream(t, config, plain))
+	if err != nil || !bytes.Equal(got, plain) {
+		t.Errorf("a single full final chunk failed: %v", err)
+	}
+}
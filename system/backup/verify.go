@@ -0,0 +1,308 @@
+package backup
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// outcome of checking a single key
+type VerifyResult struct {
+	KeyID   string
+	Path    string
+	Err     error
+	Skipped string // why the data could not be decrypted, e.g. no identity for an older archive
+}
+
+// per-file results plus anything wrong with the archive as a whole
+type VerifyReport struct {
+	TarballPath string
+	Problems    []string
+	Results     []VerifyResult
+}
+
+// Failed reports whether anything at all did not check out
+func (r *VerifyReport) Failed() bool {
+	if len(r.Problems) > 0 {
+		return true
+	}
+	for _, result := range r.Results {
+		if result.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Print writes the pass/fail report
+func (r *VerifyReport) Print(w io.Writer) {
+	fmt.Fprintf(w, "Verifying %s\n", r.TarballPath)
+	passed, skipped := 0, 0
+	for _, result := range r.Results {
+		if result.Err != nil {
+			fmt.Fprintf(w, "  FAIL  %s: %v\n", result.Path, result.Err)
+			continue
+		}
+		if result.Skipped != "" {
+			fmt.Fprintf(w, "  SKIP  %s: %s\n", result.Path, result.Skipped)
+			skipped++
+			continue
+		}
+		fmt.Fprintf(w, "  PASS  %s\n", result.Path)
+		passed++
+	}
+	for _, problem := range r.Problems {
+		fmt.Fprintf(w, "  FAIL  %s\n", problem)
+	}
+	fmt.Fprintf(w, "%d of %d key files passed, %d skipped, %d archive problems\n",
+		passed, len(r.Results), skipped, len(r.Problems))
+}
+
+// VerifyBackup checks archive structure, the manifest and every key's ciphertext and hash,
+// decrypting only in memory. An error means the backup could not be checked at all.
+func (bm *BackupManager) VerifyBackup(tarballPath string) (*VerifyReport, error) {
+	report := &VerifyReport{TarballPath: tarballPath}
+
+	backupData, manifest, signature, err := output.ReadSignedBackup(tarballPath)
+	if err != nil {
+		report.Problems = append(report.Problems, fmt.Sprintf("unreadable manifest: %v", err))
+		return report, nil
+	}
+	if err := checkSignature(manifest, signature); err != nil {
+		if !bm.force {
+			report.Problems = append(report.Problems, err.Error())
+		} else {
+			fmt.Printf("Warning: %v, continuing because of force\n", err)
+		}
+	}
+	report.Problems = append(report.Problems, validateManifest(backupData)...)
+
+	config, err := bm.unlock(backupData)
+	if err != nil {
+		return nil, err
+	}
+
+	//streamed keys grouped by the archive of the chain that holds their data
+	results := make(map[string]error)
+	bySource := make(map[string]map[string]string)
+	for keyID, key := range backupData.EncryptedKeys {
+		if key.Entry == "" {
+			results[keyID] = verifyInlineKey(key, config)
+			continue
+		}
+		if bySource[key.Source] == nil {
+			bySource[key.Source] = make(map[string]string)
+		}
+		bySource[key.Source][key.Entry] = keyID
+		results[keyID] = fmt.Errorf("data missing from %s", archiveLabel(tarballPath, key.Source))
+	}
+
+	//gpg exports always live in this archive
+	exports := gpgExports(backupData)
+	exportResults := make(map[string]error)
+	for name := range exports {
+		exportResults[name] = fmt.Errorf("data missing from %s", filepath.Base(tarballPath))
+	}
+
+	//one pass over this archive checks every entry and the gzip checksum
+	seen := make(map[string]bool)
+	err = bm.walkArchive(tarballPath, "", backupData, func(name string, r io.Reader, config *EncryptionConfig) error {
+		if seen[name] {
+			report.Problems = append(report.Problems, fmt.Sprintf("duplicate entry %s", name))
+			return nil
+		}
+		seen[name] = true
+
+		if export, ok := exports[name]; ok {
+			_, exportResults[name] = readExport(export, r, config)
+			return nil
+		}
+		keyID, ok := bySource[""][name]
+		if !ok {
+			report.Problems = append(report.Problems, fmt.Sprintf("unexpected entry %s", name))
+			return nil
+		}
+		results[keyID] = verifyStreamedKey(backupData.EncryptedKeys[keyID], r, config)
+		return nil
+	})
+	if err != nil {
+		report.Problems = append(report.Problems, err.Error())
+	}
+
+	//older archives of an incremental chain only need the referenced entries
+	skipped := make(map[string]string)
+	for source, keyIDs := range bySource {
+		if source == "" {
+			continue
+		}
+		if err := bm.verifySource(tarballPath, source, backupData, keyIDs, results, skipped); err != nil {
+			report.Problems = append(report.Problems, fmt.Sprintf("%s: %v", source, err))
+		}
+	}
+
+	for _, keyID := range sortedKeyIDs(backupData) {
+		report.Results = append(report.Results, VerifyResult{
+			KeyID:   keyID,
+			Path:    backupData.EncryptedKeys[keyID].OriginalPath,
+			Err:     results[keyID],
+			Skipped: skipped[keyID],
+		})
+	}
+	for _, name := range sortedExportNames(backupData) {
+		report.Results = append(report.Results, VerifyResult{
+			KeyID: name,
+			Path:  "gpg export " + name,
+			Err:   exportResults[name],
+		})
+	}
+	return report, nil
+}
+
+// verifySource checks the entries an incremental backup takes from an older archive.
+// An unattended host usually cannot unlock older archives, then only their presence is checked.
+func (bm *BackupManager) verifySource(tarballPath, source string, backupData *output.BackupData,
+	keyIDs map[string]string, results map[string]error, skipped map[string]string) error {
+	archivePath, err := sourcePath(tarballPath, source)
+	if err != nil {
+		return err
+	}
+	sourceData, err := bm.readManifest(archivePath)
+	if err != nil {
+		return err
+	}
+
+	config, unlockErr := bm.unlock(sourceData)
+	return output.WalkBackupEntries(archivePath, func(name string, r io.Reader) error {
+		keyID, ok := keyIDs[name]
+		if !ok {
+			return nil
+		}
+		if unlockErr != nil {
+			results[keyID] = nil
+			skipped[keyID] = fmt.Sprintf("present in %s but it cannot be unlocked here: %v", source, unlockErr)
+			return nil
+		}
+		results[keyID] = verifyStreamedKey(backupData.EncryptedKeys[keyID], r, config)
+		return nil
+	})
+}
+
+// validateManifest checks backup.json fields a restore depends on
+func validateManifest(backupData *output.BackupData) []string {
+	var problems []string
+
+	if backupData.Version != 0 && backupData.Version != output.BackupVersion {
+		problems = append(problems, fmt.Sprintf("unsupported backup version %d", backupData.Version))
+	}
+	if backupData.Timestamp.IsZero() {
+		problems = append(problems, "manifest has no timestamp")
+	}
+
+	switch backupData.Encryption.Mode {
+	case output.EncryptionModePassphrase:
+		if backupData.Encryption.KDF == nil || backupData.Encryption.Verifier == "" {
+			problems = append(problems, "passphrase backup without KDF parameters or verifier")
+		}
+	case output.EncryptionModeRecipients:
+		if len(backupData.Encryption.Recipients) == 0 {
+			problems = append(problems, "recipient backup without recipients")
+		}
+	case output.EncryptionModeLegacy:
+		if len(backupData.EncryptionKey) == 0 {
+			problems = append(problems, "legacy backup without encryption key")
+		}
+	default:
+		problems = append(problems, fmt.Sprintf("unknown encryption mode %q", backupData.Encryption.Mode))
+	}
+
+	entries := make(map[string]string)
+	for _, keyID := range sortedKeyIDs(backupData) {
+		key := backupData.EncryptedKeys[keyID]
+		prefix := fmt.Sprintf("key %s:", keyID)
+
+		if !filepath.IsAbs(key.OriginalPath) || filepath.Clean(key.OriginalPath) != key.OriginalPath {
+			problems = append(problems, fmt.Sprintf("%s original path %q is not a clean absolute path", prefix, key.OriginalPath))
+		}
+		if key.KeyType == "" {
+			problems = append(problems, prefix+" missing key type")
+		}
+		if (key.Entry == "") == (key.EncryptedData == "") {
+			problems = append(problems, prefix+" needs exactly one of entry or encrypted_data")
+		}
+		if key.Entry != "" {
+			location := key.Source + ":" + key.Entry
+			if other, ok := entries[location]; ok {
+				problems = append(problems, fmt.Sprintf("%s entry %s also used by %s", prefix, key.Entry, other))
+			}
+			entries[location] = keyID
+		}
+		if key.Source != "" {
+			if _, err := sourcePath("", key.Source); err != nil {
+				problems = append(problems, fmt.Sprintf("%s %v", prefix, err))
+			}
+		}
+		if key.Size < 0 {
+			problems = append(problems, prefix+" negative size")
+		}
+		if key.SHA256 != "" {
+			if digest, err := hex.DecodeString(key.SHA256); err != nil || len(digest) != sha256.Size {
+				problems = append(problems, prefix+" malformed sha256")
+			}
+		}
+		if os.FileMode(key.Permissions)&^(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky) != 0 {
+			problems = append(problems, fmt.Sprintf("%s permissions %o are not for a regular file", prefix, key.Permissions))
+		}
+	}
+	for name, export := range gpgExports(backupData) {
+		if !strings.HasPrefix(name, "gpg/") || export.SHA256 == "" {
+			problems = append(problems, fmt.Sprintf("gpg export %s needs an entry under gpg/ and a sha256", name))
+		}
+	}
+	if backupData.GPG != nil && !filepath.IsAbs(backupData.GPG.Home) {
+		problems = append(problems, fmt.Sprintf("gpg home %q is not an absolute path", backupData.GPG.Home))
+	}
+	return problems
+}
+
+// verifyStreamedKey authenticates every chunk and checks size and hash
+func verifyStreamedKey(key output.EncryptedKey, r io.Reader, config *EncryptionConfig) error {
+	plaintext, err := NewDecryptReader(r, config)
+	if err != nil {
+		return err
+	}
+	return checkPlaintext(key, plaintext)
+}
+
+// verifyInlineKey does the same for a version 1 key
+func verifyInlineKey(key output.EncryptedKey, config *EncryptionConfig) error {
+	data, err := DecryptData(key.EncryptedData, config)
+	if err != nil {
+		return err
+	}
+	return checkPlaintext(key, bytes.NewReader(data))
+}
+
+// checkPlaintext compares the decrypted data against the manifest
+func checkPlaintext(key output.EncryptedKey, plaintext io.Reader) error {
+	hash := sha256.New()
+	size, err := io.Copy(hash, plaintext)
+	if err != nil {
+		return err
+	}
+
+	//version 1 backups recorded neither size nor hash
+	if key.Entry != "" && size != key.Size {
+		return fmt.Errorf("size %d does not match recorded %d", size, key.Size)
+	}
+	if key.SHA256 != "" && hex.EncodeToString(hash.Sum(nil)) != key.SHA256 {
+		return fmt.Errorf("sha256 does not match recorded hash")
+	}
+	return nil
+}
@@ -0,0 +1,142 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+)
+
+// rewriteArchive copies a backup tarball entry by entry through edit, which returns the new
+// data of an entry or nil to drop it
+func rewriteArchive(t *testing.T, tarballPath, dst string, edit func(name string, data []byte) []byte) {
+	t.Helper()
+	src, err := os.Open(tarballPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer src.Close()
+	gzipReader, err := gzip.NewReader(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	var out bytes.Buffer
+	gzipWriter := gzip.NewWriter(&out)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if data = edit(header.Name, data); data == nil {
+			continue
+		}
+		header.Size = int64(len(data))
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(dst, out.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// isKeyEntry tells the encrypted key entries apart from the manifest and its signature
+func isKeyEntry(name string) bool {
+	return name != output.ManifestName && name != output.SignatureName
+}
+
+func TestVerifyBackup(t *testing.T) {
+	home := testHome(t)
+	writeHomeFiles(t, home, map[string]string{
+		".ssh/id_ed25519": "private key",
+		".netrc":          "machine example.com login me password secret\n",
+	})
+	tarballPath := createTestBackup(t, passphraseManager(testPassphrase))
+
+	flipped := false
+	tests := []struct {
+		name     string
+		edit     func(name string, data []byte) []byte
+		failures int // failing key files
+		problems bool
+	}{
+		{"intact", func(name string, data []byte) []byte { return data }, 0, false},
+		{"flipped ciphertext byte", func(name string, data []byte) []byte {
+			if isKeyEntry(name) && !flipped {
+				flipped = true
+				data[len(data)/2] ^= 1
+			}
+			return data
+		}, 1, false},
+		{"dropped key entry", func(name string, data []byte) []byte {
+			if isKeyEntry(name) && strings.Contains(name, "netrc") {
+				return nil
+			}
+			return data
+		}, 1, false},
+	}
+	for _, test := range tests {
+		copyPath := tarballPath + "." + strings.ReplaceAll(test.name, " ", "-") + ".tar.gz"
+		rewriteArchive(t, tarballPath, copyPath, test.edit)
+		report, err := passphraseManager(testPassphrase).VerifyBackup(copyPath)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		failures := 0
+		for _, result := range report.Results {
+			if result.Err != nil {
+				failures++
+			}
+		}
+		if failures != test.failures || (len(report.Problems) > 0) != test.problems {
+			t.Errorf("%s: %d failed files and problems %q, want %d failed files", test.name, failures, report.Problems, test.failures)
+		}
+		if report.Failed() != (test.failures > 0 || test.problems) {
+			t.Errorf("%s: Failed() is %v", test.name, report.Failed())
+		}
+	}
+
+	//a cut off archive is a problem of the archive, not an error of the check
+	data, err := os.ReadFile(tarballPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	truncated := tarballPath + ".truncated.tar.gz"
+	if err := os.WriteFile(truncated, data[:len(data)-40], 0600); err != nil {
+		t.Fatal(err)
+	}
+	report, err := passphraseManager(testPassphrase).VerifyBackup(truncated)
+	if err != nil || !report.Failed() {
+		t.Errorf("truncated archive: failed %v, %v", report != nil && report.Failed(), err)
+	}
+
+	//a wrong passphrase cannot check anything
+	if _, err := passphraseManager([]byte("wrong")).VerifyBackup(tarballPath); err == nil {
+		t.Error("verified with a wrong passphrase")
+	}
+}
@@ -1,8 +1,11 @@
 package system
 
 import (
+    "crypto/ecdh"
+    "errors"
     "fmt"
     "log"
+    "os"
     
     "github.com/mdgspace/sysreplicate/system/backup"
 )
@@ -16,13 +19,221 @@ func RunBackup() {
     
     //get custom paths from user
     customPaths := backup.GetCustomPaths()
+
+    //offer the catalog locations that are skipped unless asked for
+    catalog, err := backup.LoadCatalog()
+    if err != nil {
+        log.Printf("Backup failed: %v", err)
+        return
+    }
+    backupManager.EnableCatalogEntries(backup.GetCatalogOptIns(catalog))
+
+    //only offer incremental backups when there is something to build on
+    if backups, _ := backup.FindBackups(outputScriptsDir); len(backups) > 0 {
+        backupManager.SetIncremental(backup.Confirm("Only store files changed since the last backup?"))
+    }
+
+    //portable exports survive GnuPG version changes, but gpg may ask for key passphrases
+    backupManager.SetGPGExport(backup.Confirm("Also export GnuPG secret keys and ownertrust with gpg?"))
+
+    //prefer configured recipients, otherwise the passphrase derives the key
+    //either way nothing that decrypts the backup is stored in it
+    recipients, recipientsPath := loadDefaultRecipients()
+    if len(recipients) > 0 && backup.Confirm(fmt.Sprintf("Encrypt to the %d recipients in %s instead of a passphrase?", len(recipients), recipientsPath)) {
+        backupManager.UseRecipients(recipients)
+    } else {
+        passphrase, err := backup.ReadNewPassphrase()
+        if err != nil {
+            log.Printf("Backup failed: %v", err)
+            return
+        }
+        backupManager.UsePassphrase(passphrase)
+    }
     
     //create backup
-    err := backupManager.CreateBackup(customPaths)
+    tarballPath, err := backupManager.CreateBackup(customPaths)
     if err != nil {
         log.Printf("Backup failed: %v", err)
         return
     }
+    if tarballPath == "" {
+        return
+    }
+
+    //read the archive back before anyone relies on it
+    if err := verifyBackup(backupManager, tarballPath); err != nil {
+        log.Printf("Backup verification failed: %v", err)
+        return
+    }
     
     fmt.Println("Key backup completed successfully!")
 }
+
+// settings of a backup run without prompts
+type UnattendedBackupOptions struct {
+    Recipients     []string // public keys given on the command line
+    RecipientsFile string   // empty uses the default recipients file
+    Paths          []string // custom paths on top of the catalog
+    Enable         []string // opt-in catalog entries
+    Incremental    bool
+    GPGExport      bool
+}
+
+// backup without any prompts, keys are encrypted to the given recipients
+func RunUnattendedBackup(options UnattendedBackupOptions) error {
+    recipientsPath := options.RecipientsFile
+    var recipients []*ecdh.PublicKey
+    for _, text := range options.Recipients {
+        recipient, err := backup.ParsePublicKey(text)
+        if err != nil {
+            return err
+        }
+        recipients = append(recipients, recipient)
+    }
+
+    if recipientsPath != "" {
+        fromFile, err := backup.LoadRecipients(recipientsPath)
+        if err != nil {
+            return fmt.Errorf("failed to load recipients: %w", err)
+        }
+        recipients = append(recipients, fromFile...)
+    } else if len(recipients) == 0 {
+        recipients, recipientsPath = loadDefaultRecipients()
+    }
+
+    if len(recipients) == 0 {
+        return fmt.Errorf("no recipients configured, add public keys to %s or pass -recipient", recipientsPath)
+    }
+
+    backupManager := backup.NewBackupManager()
+    backupManager.UseRecipients(recipients)
+    backupManager.SetIncremental(options.Incremental)
+    backupManager.SetGPGExport(options.GPGExport)
+    backupManager.EnableCatalogEntries(options.Enable)
+    tarballPath, err := backupManager.CreateBackup(options.Paths)
+    if err != nil || tarballPath == "" {
+        return err
+    }
+    return verifyBackup(backupManager, tarballPath)
+}
+
+// handle verify integration
+func RunVerify() {
+    fmt.Println("=== Key Backup Verification ===")
+
+    tarballPath := backup.GetRestorePath(outputScriptsDir)
+    if tarballPath == "" {
+        fmt.Println("No backup selected.")
+        return
+    }
+
+    if err := verifyBackup(backup.NewBackupManager(), tarballPath); err != nil {
+        log.Printf("Verification failed: %v", err)
+        return
+    }
+    fmt.Println("Backup verified successfully!")
+}
+
+// verify a backup and print the per-file report, failing on any problem
+func verifyBackup(backupManager *backup.BackupManager, tarballPath string) error {
+    report, err := backupManager.VerifyBackup(tarballPath)
+    if err != nil {
+        return err
+    }
+    report.Print(os.Stdout)
+    if report.Failed() {
+        return fmt.Errorf("%s did not pass verification", tarballPath)
+    }
+    return nil
+}
+
+// create a restore identity and print the public key to share
+func RunKeygen(identityPath string) error {
+    if identityPath == "" {
+        var err error
+        identityPath, err = backup.DefaultIdentityPath()
+        if err != nil {
+            return err
+        }
+    }
+
+    publicKey, err := backup.GenerateIdentity(identityPath)
+    if err != nil {
+        return err
+    }
+
+    recipientsPath, _ := backup.DefaultRecipientsPath()
+    fmt.Println("Identity written to:", identityPath)
+    fmt.Println("Public key:", backup.FormatPublicKey(publicKey))
+    fmt.Println("Add the public key to", recipientsPath, "on machines that should back up to it.")
+    return nil
+}
+
+// recipients from the config dir, if any
+func loadDefaultRecipients() ([]*ecdh.PublicKey, string) {
+    recipientsPath, err := backup.DefaultRecipientsPath()
+    if err != nil {
+        return nil, ""
+    }
+    recipients, err := backup.LoadRecipients(recipientsPath)
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Printf("Ignoring recipients file: %v", err)
+        }
+        return nil, recipientsPath
+    }
+    return recipients, recipientsPath
+}
+
+// handle restore integration
+func RunRestore() {
+    fmt.Println("=== Key Restore Process ===")
+
+    //pick the tarball to restore
+    tarballPath := backup.GetRestorePath(outputScriptsDir)
+    if tarballPath == "" {
+        fmt.Println("No backup selected.")
+        return
+    }
+
+    overwrite := backup.Confirm("Overwrite existing key files?")
+
+    backupManager := backup.NewBackupManager()
+    err := backupManager.RestoreBackup(tarballPath, overwrite)
+    if err != nil && confirmSignatureOverride(backupManager, err) {
+        err = backupManager.RestoreBackup(tarballPath, overwrite)
+    }
+    if err != nil {
+        log.Printf("Restore failed: %v", err)
+        return
+    }
+
+    fmt.Println("Key restore completed successfully!")
+}
+
+// offer to trust a new signer, or to force past a missing or bad signature
+// returns true when the restore should be retried
+func confirmSignatureOverride(backupManager *backup.BackupManager, err error) bool {
+    var sigErr *backup.SignatureError
+    if errors.As(err, &sigErr) && sigErr.Valid {
+        //typical after hopping distros: the old machine's signing key is not known here yet
+        fmt.Println("The backup was signed by", sigErr.PublicKey)
+        if !backup.Confirm("Trust this signer from now on?") {
+            return false
+        }
+        if err := backup.TrustSigner(sigErr.PublicKey); err != nil {
+            log.Printf("Failed to trust signer: %v", err)
+            return false
+        }
+        return true
+    }
+
+    if errors.Is(err, backup.ErrUnsigned) || errors.As(err, &sigErr) {
+        fmt.Println("The backup manifest could not be authenticated, paths and permissions may have been altered.")
+        if backup.Confirm("Restore anyway?") {
+            backupManager.SetForce(true)
+            return true
+        }
+    }
+    return false
+}
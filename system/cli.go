@@ -0,0 +1,200 @@
+package system
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/backup"
+)
+
+// stringList collects a repeatable string flag
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// runCommand handles non-interactive use, e.g. from cron, and returns the exit code
+func runCommand(args []string) int {
+	switch args[0] {
+	case "backup":
+		return backupCommand(args[1:])
+	case "verify":
+		return verifyCommand(args[1:])
+	case "list":
+		return listCommand(args[1:])
+	case "keygen":
+		return keygenCommand(args[1:])
+	case "catalog":
+		return catalogCommand(args[1:])
+	case "compare":
+		return compareCommand(args[1:])
+	case "help", "-h", "--help":
+		printUsage()
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n", args[0])
+		printUsage()
+		return 2
+	}
+}
+
+func printUsage() {
+	fmt.Println("Usage: sysreplicate [command] [flags]")
+	fmt.Println()
+	fmt.Println("Without a command the interactive menu is shown.")
+	fmt.Println()
+	fmt.Println("Commands:")
+	fmt.Println("  backup   back up keys encrypted to recipient public keys, without prompting")
+	fmt.Println("  verify   check a key backup without writing anything, exits non-zero on failure")
+	fmt.Println("  list     show the files in a key backup and what kind of keys they are")
+	fmt.Println("  keygen   create an identity used to restore recipient-encrypted backups")
+	fmt.Println("  catalog  show the credential locations scouted by backup")
+	fmt.Println("  compare  report packages missing, extra or at another version than in a package.json")
+}
+
+func backupCommand(args []string) int {
+	fs := flag.NewFlagSet("backup", flag.ContinueOnError)
+	var recipients, paths stringList
+	fs.Var(&recipients, "recipient", "public key to encrypt to (repeatable)")
+	recipientsFile := fs.String("recipients-file", "", "file with one public key per line (default: config dir recipients)")
+	fs.Var(&paths, "path", "additional file or directory to back up (repeatable)")
+	var enable stringList
+	fs.Var(&enable, "enable", "opt-in catalog entry to back up, see the catalog command (repeatable)")
+	incremental := fs.Bool("incremental", false, "only store files changed since the latest backup")
+	gpgExport := fs.Bool("gpg-export", false, "also store gpg --export-secret-keys and --export-ownertrust output")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	err := RunUnattendedBackup(UnattendedBackupOptions{
+		Recipients:     recipients,
+		RecipientsFile: *recipientsFile,
+		Paths:          paths,
+		Enable:         enable,
+		Incremental:    *incremental,
+		GPGExport:      *gpgExport,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Backup failed:", err)
+		return 1
+	}
+	return 0
+}
+
+func verifyCommand(args []string) int {
+	fs := flag.NewFlagSet("verify", flag.ContinueOnError)
+	force := fs.Bool("force", false, "check the archive even if its manifest signature is missing or untrusted")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: sysreplicate verify [-force] <backup.tar.gz>")
+		return 2
+	}
+
+	backupManager := backup.NewBackupManager()
+	backupManager.SetForce(*force)
+	if err := verifyBackup(backupManager, fs.Arg(0)); err != nil {
+		fmt.Fprintln(os.Stderr, "Verification failed:", err)
+		return 1
+	}
+	return 0
+}
+
+func listCommand(args []string) int {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, "Usage: sysreplicate list <backup.tar.gz>")
+		return 2
+	}
+
+	if err := backup.ListBackup(args[0], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "Listing failed:", err)
+		return 1
+	}
+	return 0
+}
+
+func keygenCommand(args []string) int {
+	fs := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	out := fs.String("out", "", "identity file to create (default: config dir identity)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+
+	if err := RunKeygen(*out); err != nil {
+		fmt.Fprintln(os.Stderr, "Key generation failed:", err)
+		return 1
+	}
+	return 0
+}
+
+func catalogCommand(args []string) int {
+	if len(args) != 0 {
+		fmt.Fprintln(os.Stderr, "Usage: sysreplicate catalog")
+		return 2
+	}
+
+	catalog, err := backup.LoadCatalog()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Loading catalog failed:", err)
+		return 1
+	}
+	if catalogPath, err := backup.CatalogPath(); err == nil {
+		fmt.Println("Add or override entries in", catalogPath)
+	}
+	for _, entry := range catalog.Entries {
+		state := "enabled"
+		if !catalog.Enabled(entry.Name) {
+			state = "opt-in"
+		}
+		fmt.Printf("  %-20s %-16s %-8s %s\n", entry.Name, entry.Type, state, entry.Path)
+	}
+	return 0
+}
+
+// compareSnapshot collects this system and compares it with a package.json
+var compareSnapshot = compareWithSnapshot
+
+// compareCommand exits 1 when the system differs from the snapshot and 2 when it cannot
+// compare, like diff.
+func compareCommand(args []string) int {
+	fs := flag.NewFlagSet("compare", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "print the differences as JSON")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 1 {
+		fmt.Fprintln(os.Stderr, "Usage: sysreplicate compare [-json] [package.json]")
+		return 2
+	}
+	path := jsonOutputPath
+	if fs.NArg() == 1 {
+		path = fs.Arg(0)
+	}
+
+	diff, err := compareSnapshot(path)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Comparison failed:", err)
+		return 2
+	}
+	if *asJSON {
+		if err := diff.WriteJSON(os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, "Comparison failed:", err)
+			return 2
+		}
+	} else {
+		diff.WriteText(os.Stdout)
+	}
+	if !diff.Empty() {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,50 @@
+package system
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/output"
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+func TestCompareCommandExitCodes(t *testing.T) {
+	defer func(original func(string) (*output.SnapshotDiff, error)) { compareSnapshot = original }(compareSnapshot)
+
+	same := &output.SnapshotDiff{}
+	differs := &output.SnapshotDiff{Missing: []utils.Package{{Name: "vim"}}}
+	tests := []struct {
+		name string
+		args []string
+		diff *output.SnapshotDiff
+		err  error
+		want int
+	}{
+		{"matches", nil, same, nil, 0},
+		{"matches as JSON", []string{"-json", "saved.json"}, same, nil, 0},
+		{"differs", []string{"saved.json"}, differs, nil, 1},
+		{"differs as JSON", []string{"-json"}, differs, nil, 1},
+		{"cannot collect", nil, nil, errors.New("dpkg-query failed"), 2},
+		{"too many arguments", []string{"a.json", "b.json"}, same, nil, 2},
+		{"unknown flag", []string{"-yaml"}, same, nil, 2},
+	}
+	for _, test := range tests {
+		var path string
+		compareSnapshot = func(p string) (*output.SnapshotDiff, error) {
+			path = p
+			return test.diff, test.err
+		}
+		if got := compareCommand(test.args); got != test.want {
+			t.Errorf("%s: exit %d, want %d", test.name, got, test.want)
+		}
+		if test.want != 2 && path != jsonOutputPath && path != "saved.json" {
+			t.Errorf("%s: compared with %q", test.name, path)
+		}
+	}
+
+	// a snapshot that cannot be read is an error, not a difference
+	compareSnapshot = compareWithSnapshot
+	if got := compareCommand([]string{t.TempDir() + "/missing.json"}); got != 2 {
+		t.Errorf("missing snapshot: exit %d, want 2", got)
+	}
+}
@@ -0,0 +1,220 @@
+package mapping
+
+import (
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// Families that package names are translated between.
+var Families = []string{"debian", "arch", "fedora", "void"}
+
+// names in the dataset prefixed like this are AUR packages on Arch
+const aurPrefix = "aur:"
+
+// user overrides inside the sysreplicate config dir, same format as packages.json
+const overridesFileName = "package-map.json"
+
+//go:embed packages.json
+var embeddedDataset []byte
+
+// Row names one package in every family, an empty name means the family needs no package
+// for it and a missing family means there is no known equivalent. Rows with "only_from"
+// are used for that family's names only, e.g. libssl-dev becomes openssl on Arch but
+// openssl on Arch stays openssl.
+type Row map[string]string
+
+// Dataset is a set of rows indexed by family and name.
+type Dataset struct {
+	Version  int   `json:"version"`
+	Packages []Row `json:"packages"`
+	// Prefixes rename whole groups of packages not listed in Packages, e.g. python3-* on
+	// Debian is python-* on Arch.
+	Prefixes []Row `json:"prefixes,omitempty"`
+
+	index map[string]map[string]Row
+}
+
+// Result is a translated package list and what could not be translated.
+type Result struct {
+	Packages []utils.Package
+	// Unmapped packages are not in the dataset and kept under their source name.
+	Unmapped []utils.Package
+	// Missing packages have no equivalent in the target family and are left out.
+	Missing []utils.Package
+	// Dropped counts packages the target family does not need, e.g. python3-venv on Arch.
+	Dropped int
+}
+
+// Family returns the dataset family of a base distro, rhel uses the fedora names.
+func Family(baseDistro string) string {
+	if baseDistro == "rhel" {
+		return "fedora"
+	}
+	return baseDistro
+}
+
+// OverridesPath is where users add or correct mappings.
+func OverridesPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sysreplicate", overridesFileName), nil
+}
+
+// Load reads the embedded dataset and the user's overrides on top of it.
+func Load() (*Dataset, error) {
+	var dataset Dataset
+	if err := json.Unmarshal(embeddedDataset, &dataset); err != nil {
+		return nil, fmt.Errorf("embedded package map: %w", err)
+	}
+
+	overridesPath, err := OverridesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(overridesPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	dataset.index = make(map[string]map[string]Row)
+	for _, family := range Families {
+		dataset.index[family] = make(map[string]Row)
+	}
+	dataset.addRows(dataset.Packages, false)
+
+	if err == nil {
+		var overrides Dataset
+		if err := json.Unmarshal(data, &overrides); err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", overridesPath, err)
+		}
+		dataset.Packages = append(dataset.Packages, overrides.Packages...)
+		dataset.addRows(overrides.Packages, true)
+		dataset.Prefixes = append(overrides.Prefixes, dataset.Prefixes...)
+	}
+	return &dataset, nil
+}
+
+// addRows indexes rows by every family's name. Among the embedded rows the first one
+// for a name wins, so openssh maps back to openssh-client, overrides replace them.
+func (d *Dataset) addRows(rows []Row, override bool) {
+	for _, row := range rows {
+		for family, name := range row {
+			if d.index[family] == nil || name == "" {
+				continue
+			}
+			if row["only_from"] != "" && row["only_from"] != family {
+				continue
+			}
+			name = strings.TrimPrefix(name, aurPrefix)
+			if _, ok := d.index[family][name]; ok && !override {
+				continue
+			}
+			d.index[family][name] = row
+		}
+	}
+}
+
+// Translate renames packages from one family to another. Versions and architectures
+// are not comparable across families and are dropped.
+func (d *Dataset) Translate(packages []utils.Package, from, to string) (*Result, error) {
+	from, to = Family(from), Family(to)
+	if d.index[from] == nil || d.index[to] == nil {
+		return nil, fmt.Errorf("cannot translate from %q to %q, known families: %s", from, to, strings.Join(Families, ", "))
+	}
+
+	result := &Result{}
+	if from == to {
+		result.Packages = packages
+		return result, nil
+	}
+
+	seen := make(map[string]bool)
+	for _, pkg := range packages {
+		row, ok := d.index[from][pkg.Name]
+		if !ok {
+			row, ok = d.prefixRow(pkg.Name, from)
+		}
+		if !ok {
+			result.Unmapped = append(result.Unmapped, pkg)
+			translated := utils.Package{Name: pkg.Name, Reason: pkg.Reason}
+			if to == "arch" && pkg.Repository == utils.RepositoryAUR {
+				translated.Repository = utils.RepositoryAUR
+			}
+			result.add(translated, seen)
+			continue
+		}
+
+		name, ok := row[to]
+		switch {
+		case !ok:
+			result.Missing = append(result.Missing, pkg)
+		case name == "":
+			result.Dropped++
+		default:
+			translated := utils.Package{Name: name, Reason: pkg.Reason}
+			if strings.HasPrefix(name, aurPrefix) {
+				translated.Name = strings.TrimPrefix(name, aurPrefix)
+				translated.Repository = utils.RepositoryAUR
+			}
+			result.add(translated, seen)
+		}
+	}
+	return result, nil
+}
+
+// prefixRow builds a row for a name matching one of the prefix rules
+func (d *Dataset) prefixRow(name, from string) (Row, bool) {
+	for _, prefixes := range d.Prefixes {
+		prefix := prefixes[from]
+		if prefix == "" || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		row := make(Row)
+		for family, other := range prefixes {
+			row[family] = other + strings.TrimPrefix(name, prefix)
+		}
+		return row, true
+	}
+	return nil, false
+}
+
+// add appends a package once, several source packages can map to the same target
+func (r *Result) add(pkg utils.Package, seen map[string]bool) {
+	if seen[pkg.Name] {
+		return
+	}
+	seen[pkg.Name] = true
+	r.Packages = append(r.Packages, pkg)
+}
+
+// WriteReport writes the unmapped and missing packages as plain text.
+func (r *Result) WriteReport(path, from, to string) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# package names translated from %s to %s\n", from, to)
+	fmt.Fprintf(&b, "# %d packages without an equivalent in %s, left out:\n", len(r.Missing), to)
+	for _, name := range sortedNames(r.Missing) {
+		fmt.Fprintln(&b, name)
+	}
+	fmt.Fprintf(&b, "# %d packages not in the package map, kept under their %s name:\n", len(r.Unmapped), from)
+	for _, name := range sortedNames(r.Unmapped) {
+		fmt.Fprintln(&b, name)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0644)
+}
+
+func sortedNames(packages []utils.Package) []string {
+	names := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		names = append(names, pkg.Name)
+	}
+	sort.Strings(names)
+	return names
+}
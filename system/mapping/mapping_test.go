@@ -0,0 +1,141 @@
+package mapping
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// loadDataset loads the embedded dataset with the overrides written to a temporary config dir
+func loadDataset(t *testing.T, overrides string) *Dataset {
+	t.Helper()
+	config := t.TempDir()
+	t.Setenv("XDG_CONFIG_HOME", config)
+	t.Setenv("HOME", config)
+	if overrides != "" {
+		path, err := OverridesPath()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(overrides), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	dataset, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dataset
+}
+
+func names(packages []utils.Package) []string {
+	var names []string
+	for _, pkg := range packages {
+		names = append(names, pkg.Name)
+	}
+	return names
+}
+
+func TestTranslate(t *testing.T) {
+	dataset := loadDataset(t, "")
+	tests := []struct {
+		name     string
+		from, to string
+		packages []utils.Package
+		want     []string
+		aur      []string
+		unmapped []string
+		missing  []string
+		dropped  int
+	}{
+		{"renamed", "debian", "arch", []utils.Package{{Name: "openssh-client"}, {Name: "apache2"}}, []string{"openssh", "apache"}, nil, nil, nil, 0},
+		{"one package for several", "debian", "arch", []utils.Package{{Name: "openssh-client"}, {Name: "openssh-server"}}, []string{"openssh"}, nil, nil, nil, 0},
+		{"only_from applies one way", "debian", "arch", []utils.Package{{Name: "libssl-dev"}}, []string{"openssl"}, nil, nil, nil, 0},
+		{"only_from rows are not used back", "arch", "debian", []utils.Package{{Name: "gcc"}}, []string{"gcc"}, nil, []string{"gcc"}, nil, 0},
+		{"aur prefix", "debian", "arch", []utils.Package{{Name: "code"}}, []string{"visual-studio-code-bin"}, []string{"visual-studio-code-bin"}, nil, nil, 0},
+		{"aur prefix back", "arch", "debian", []utils.Package{{Name: "visual-studio-code-bin", Repository: utils.RepositoryAUR}}, []string{"code"}, nil, nil, nil, 0},
+		{"unmapped AUR packages stay in the AUR", "fedora", "arch", []utils.Package{{Name: "yay", Repository: utils.RepositoryAUR}}, []string{"yay"}, []string{"yay"}, []string{"yay"}, nil, 0},
+		{"python3 prefix", "debian", "arch", []utils.Package{{Name: "python3-requests"}}, []string{"python-requests"}, nil, nil, nil, 0},
+		{"python prefix back", "arch", "fedora", []utils.Package{{Name: "python-requests"}}, []string{"python3-requests"}, nil, nil, nil, 0},
+		{"rows win over prefixes", "debian", "arch", []utils.Package{{Name: "python3-pip"}}, []string{"python-pip"}, nil, nil, nil, 0},
+		{"dropped", "debian", "arch", []utils.Package{{Name: "python3-venv"}, {Name: "apt-transport-https"}}, nil, nil, nil, nil, 2},
+		{"missing", "debian", "void", []utils.Package{{Name: "snapd"}}, nil, nil, nil, []string{"snapd"}, 0},
+		{"unmapped", "debian", "fedora", []utils.Package{{Name: "htop"}}, []string{"htop"}, nil, []string{"htop"}, nil, 0},
+		{"same family", "rhel", "fedora", []utils.Package{{Name: "httpd"}}, []string{"httpd"}, nil, nil, nil, 0},
+	}
+	for _, test := range tests {
+		result, err := dataset.Translate(test.packages, test.from, test.to)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		var aur []string
+		for _, pkg := range result.Packages {
+			if pkg.Repository == utils.RepositoryAUR {
+				aur = append(aur, pkg.Name)
+			}
+		}
+		if !reflect.DeepEqual(names(result.Packages), test.want) || !reflect.DeepEqual(aur, test.aur) {
+			t.Errorf("%s: got %v (AUR %v), want %v (AUR %v)", test.name, names(result.Packages), aur, test.want, test.aur)
+		}
+		if !reflect.DeepEqual(names(result.Unmapped), test.unmapped) || !reflect.DeepEqual(names(result.Missing), test.missing) || result.Dropped != test.dropped {
+			t.Errorf("%s: unmapped %v, missing %v, dropped %d, want %v, %v, %d", test.name,
+				names(result.Unmapped), names(result.Missing), result.Dropped, test.unmapped, test.missing, test.dropped)
+		}
+	}
+
+	if _, err := dataset.Translate(nil, "debian", "beos"); err == nil {
+		t.Error("translated to an unknown family")
+	}
+}
+
+func TestOverridesReplaceEmbeddedRows(t *testing.T) {
+	dataset := loadDataset(t, `{"version": 1, "packages": [
+		{"debian": "apache2", "arch": "apache-custom", "fedora": "httpd"},
+		{"debian": "htop", "arch": "htop-vim"}
+	], "prefixes": [{"debian": "python3-", "arch": "pyx-"}]}`)
+	result, err := dataset.Translate([]utils.Package{{Name: "apache2"}, {Name: "htop"}, {Name: "python3-requests"}, {Name: "golang-go"}}, "debian", "arch")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"apache-custom", "htop-vim", "pyx-requests", "go"}
+	if !reflect.DeepEqual(names(result.Packages), want) {
+		t.Errorf("got %v, want %v", names(result.Packages), want)
+	}
+
+	// a broken overrides file is an error, not silently ignored
+	path, err := OverridesPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(); err == nil {
+		t.Error("a broken overrides file was accepted")
+	}
+}
+
+// TestTargetNamesAreValid checks every name of the dataset against the grammar of the
+// family's package manager, setup.sh rejects names that do not match
+func TestTargetNamesAreValid(t *testing.T) {
+	dataset := loadDataset(t, "")
+	for _, row := range dataset.Packages {
+		for family, name := range row {
+			manager := utils.ManagerFor(family)
+			if family == "only_from" || name == "" || manager == nil {
+				continue
+			}
+			if name = strings.TrimPrefix(name, aurPrefix); !manager.ValidName(name) {
+				t.Errorf("%s name %q is rejected by %s", family, name, manager.Name())
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// packages installed by one package manager transaction, a failing batch is retried one
+// package at a time
+const installBatchSize = 100
+
+// the script appends the packages it could not install here and exits non-zero at the end
+const failureLogSetup = `failure_log="$(pwd)/install-failures.txt"
+: > "$failure_log"
+install_failed() {
+  echo "$1" >> "$failure_log"
+}
+`
+
+const failureSummary = `if [ -s "$failure_log" ]; then
+  echo "$(wc -l < "$failure_log") installs failed, see $failure_log:"
+  cat "$failure_log"
+  exit 1
+fi
+echo 'All packages installed'
+`
+
+// writeInstallBatches installs the packages in batches after whatever the manager has to set
+// up first. Batches whose packages are all installed, or that finished in an earlier run, are
+// skipped. Only the packages of a failing batch are installed one by one, those failing
+// again go to the failure log and the batch is retried on the next run. Under the minimum
+// policy the packages left older than recorded are reported after each batch.
+func writeInstallBatches(b *strings.Builder, manager utils.PackageManager, packages []utils.Package, policy VersionPolicy) {
+	var named []utils.Package
+	for _, pkg := range packages {
+		if pkg.Name != "" {
+			named = append(named, pkg)
+		}
+	}
+	if len(named) == 0 {
+		return
+	}
+	b.WriteString(installedSetup(manager, policy))
+	_, compares := manager.(utils.VersionComparer)
+	if bootstrapper, ok := manager.(utils.Bootstrapper); ok {
+		b.WriteString(bootstrapper.Bootstrap(named))
+	}
+
+	batches := (len(named) + installBatchSize - 1) / installBatchSize
+	for i := 0; i < batches; i++ {
+		batch := named[i*installBatchSize : min((i+1)*installBatchSize, len(named))]
+		installed := installedArgs(manager, batch, policy)
+		command := batchCommand(manager, batch, policy)
+		step := shellQuote(batchStep(manager, command))
+
+		fmt.Fprintf(b, "if step_done %s || all_installed %s; then\n", step, installed)
+		fmt.Fprintf(b, "  echo 'Batch %d of %d is already installed'\n", i+1, batches)
+		b.WriteString("else\n")
+		fmt.Fprintf(b, "  echo 'Installing packages with %s (batch %d of %d)...'\n", manager.Name(), i+1, batches)
+		b.WriteString(indent(versionNotes(manager, batch, policy), "  "))
+		b.WriteString("  batch_ok=true\n")
+		fmt.Fprintf(b, "  if ! { %s; }; then\n", command)
+		b.WriteString("    echo 'The batch failed, installing its packages one at a time...'\n")
+		for _, pkg := range batch {
+			name := shellQuote(pkg.Name)
+			fmt.Fprintf(b, "    is_installed %s || %s || { install_failed %s; batch_ok=false; }\n", installedArgs(manager, []utils.Package{pkg}, policy), packageCommand(manager, pkg, policy), name)
+		}
+		b.WriteString("  fi\n")
+		fmt.Fprintf(b, "  if $batch_ok; then mark_done %s; fi\n", step)
+		b.WriteString("fi\n")
+		if compares && policy == PolicyMinimum {
+			fmt.Fprintf(b, "report_older %s\n", installed)
+		}
+	}
+}
+
+// indent prefixes every line of a script fragment
+func indent(lines, prefix string) string {
+	if lines == "" {
+		return ""
+	}
+	return prefix + strings.ReplaceAll(strings.TrimSuffix(lines, "\n"), "\n", "\n"+prefix) + "\n"
+}
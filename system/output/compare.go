@@ -0,0 +1,192 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// SnapshotDiff lists how the packages of a live system differ from a saved snapshot.
+type SnapshotDiff struct {
+	// Missing packages are in the snapshot but not installed.
+	Missing []utils.Package `json:"missing"`
+	// Extra packages are installed but not in the snapshot.
+	Extra []utils.Package `json:"extra"`
+	// Changed packages are installed at another version than recorded.
+	Changed []VersionChange `json:"changed"`
+}
+
+// VersionChange is a package installed at another version than the snapshot recorded.
+type VersionChange struct {
+	Name string `json:"name"`
+	// Arch is only set for names installed for several architectures.
+	Arch    string `json:"arch,omitempty"`
+	Saved   string `json:"saved"`
+	Current string `json:"current"`
+}
+
+// LoadSnapshot reads a package.json written by BuildSystemJSON.
+func LoadSnapshot(path string) (*SystemSnapshot, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var snapshot SystemSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return nil, fmt.Errorf("%s is not a package snapshot: %w", path, err)
+	}
+	return &snapshot, nil
+}
+
+// ExplicitOnly tells whether the snapshot was captured without dependencies.
+func (s *SystemSnapshot) ExplicitOnly() bool {
+	for _, pkg := range s.Packages {
+		if pkg.Reason == utils.ReasonDependency {
+			return false
+		}
+	}
+	return true
+}
+
+// CompareSnapshots compares the packages of a saved snapshot with those installed now.
+// live should hold every installed package: when the saved snapshot left out dependencies,
+// a package it lists that is now only a dependency still counts as installed, and only the
+// explicit live packages can be extra. Versions are compared when both sides have one.
+func CompareSnapshots(saved, live *SystemSnapshot) *SnapshotDiff {
+	diff := &SnapshotDiff{Missing: []utils.Package{}, Extra: []utils.Package{}, Changed: []VersionChange{}}
+	multiarch := multiarchNames(saved.Packages)
+	for name := range multiarchNames(live.Packages) {
+		multiarch[name] = true
+	}
+	savedKeys := packageKeys(saved.Packages, multiarch)
+	liveKeys := packageKeys(live.Packages, multiarch)
+
+	for key, pkg := range savedKeys {
+		current, ok := liveKeys[key]
+		switch {
+		case !ok:
+			diff.Missing = append(diff.Missing, pkg)
+		case pkg.Version != "" && current.Version != "" && pkg.Version != current.Version:
+			change := VersionChange{Name: pkg.Name, Saved: pkg.Version, Current: current.Version}
+			if multiarch[pkg.Name] {
+				change.Arch = pkg.Arch
+			}
+			diff.Changed = append(diff.Changed, change)
+		}
+	}
+	explicitOnly := saved.ExplicitOnly()
+	for key, pkg := range liveKeys {
+		if explicitOnly && pkg.Reason == utils.ReasonDependency {
+			continue
+		}
+		if _, ok := savedKeys[key]; !ok {
+			diff.Extra = append(diff.Extra, pkg)
+		}
+	}
+
+	sortPackages(diff.Missing)
+	sortPackages(diff.Extra)
+	sort.Slice(diff.Changed, func(i, j int) bool {
+		if diff.Changed[i].Name != diff.Changed[j].Name {
+			return diff.Changed[i].Name < diff.Changed[j].Name
+		}
+		return diff.Changed[i].Arch < diff.Changed[j].Arch
+	})
+	return diff
+}
+
+// Empty tells whether the live system matches the snapshot.
+func (d *SnapshotDiff) Empty() bool {
+	return len(d.Missing) == 0 && len(d.Extra) == 0 && len(d.Changed) == 0
+}
+
+// WriteText prints the differences one package per line, grouped by kind.
+func (d *SnapshotDiff) WriteText(w io.Writer) {
+	if d.Empty() {
+		fmt.Fprintln(w, "The system matches the snapshot")
+		return
+	}
+	writePackageGroup(w, "Missing", d.Missing)
+	writePackageGroup(w, "Extra", d.Extra)
+	if len(d.Changed) > 0 {
+		fmt.Fprintf(w, "Version changed (%d):\n", len(d.Changed))
+		for _, change := range d.Changed {
+			fmt.Fprintf(w, "  %s %s -> %s\n", displayName(change.Name, change.Arch), change.Saved, change.Current)
+		}
+	}
+}
+
+// WriteJSON prints the differences as a JSON object.
+func (d *SnapshotDiff) WriteJSON(w io.Writer) error {
+	data, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(data))
+	return err
+}
+
+func writePackageGroup(w io.Writer, title string, packages []utils.Package) {
+	if len(packages) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "%s (%d):\n", title, len(packages))
+	for _, pkg := range packages {
+		line := "  " + pkg.Name
+		if pkg.Version != "" {
+			line += " " + pkg.Version
+		}
+		if pkg.Arch != "" {
+			line += " (" + pkg.Arch + ")"
+		}
+		fmt.Fprintln(w, line)
+	}
+}
+
+// displayName shows the architecture of multiarch packages, e.g. libc6:i386
+func displayName(name, arch string) string {
+	if arch == "" {
+		return name
+	}
+	return name + ":" + arch
+}
+
+// multiarchNames returns the names installed for several architectures, like Debian's
+// multiarch libraries
+func multiarchNames(packages []utils.Package) map[string]bool {
+	arches := make(map[string]string)
+	names := make(map[string]bool)
+	for _, pkg := range packages {
+		if arch, ok := arches[pkg.Name]; ok && arch != pkg.Arch {
+			names[pkg.Name] = true
+		}
+		arches[pkg.Name] = pkg.Arch
+	}
+	return names
+}
+
+// packageKeys indexes packages by name, multiarch names also by architecture
+func packageKeys(packages []utils.Package, multiarch map[string]bool) map[string]utils.Package {
+	keys := make(map[string]utils.Package, len(packages))
+	for _, pkg := range packages {
+		key := pkg.Name
+		if multiarch[pkg.Name] {
+			key = displayName(pkg.Name, pkg.Arch)
+		}
+		keys[key] = pkg
+	}
+	return keys
+}
+
+func sortPackages(packages []utils.Package) {
+	sort.Slice(packages, func(i, j int) bool {
+		if packages[i].Name != packages[j].Name {
+			return packages[i].Name < packages[j].Name
+		}
+		return packages[i].Arch < packages[j].Arch
+	})
+}
@@ -0,0 +1,149 @@
+package output
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+func TestCompareSnapshots(t *testing.T) {
+	explicit, dependency := utils.ReasonExplicit, utils.ReasonDependency
+	tests := []struct {
+		name    string
+		saved   []utils.Package
+		live    []utils.Package
+		missing []string
+		extra   []string
+		changed []VersionChange
+	}{
+		{
+			name:  "identical",
+			saved: []utils.Package{{Name: "git", Version: "2.43", Reason: explicit}},
+			live:  []utils.Package{{Name: "git", Version: "2.43", Reason: explicit}},
+		},
+		{
+			name:    "version changed",
+			saved:   []utils.Package{{Name: "git", Version: "2.43", Reason: explicit}},
+			live:    []utils.Package{{Name: "git", Version: "2.45", Reason: explicit}},
+			changed: []VersionChange{{Name: "git", Saved: "2.43", Current: "2.45"}},
+		},
+		{
+			name:  "version unknown on one side",
+			saved: []utils.Package{{Name: "git", Reason: explicit}},
+			live:  []utils.Package{{Name: "git", Version: "2.45", Reason: explicit}},
+		},
+		{
+			name:    "only in the snapshot",
+			saved:   []utils.Package{{Name: "git", Reason: explicit}, {Name: "vim", Version: "9.1", Reason: explicit}},
+			live:    []utils.Package{{Name: "git", Reason: explicit}},
+			missing: []string{"vim"},
+		},
+		{
+			name:  "only installed",
+			saved: []utils.Package{{Name: "git", Reason: explicit}},
+			live:  []utils.Package{{Name: "git", Reason: explicit}, {Name: "htop", Reason: explicit}},
+			extra: []string{"htop"},
+		},
+		{
+			name:  "explicit snapshot ignores installed dependencies",
+			saved: []utils.Package{{Name: "git", Reason: explicit}, {Name: "curl", Reason: explicit}},
+			live: []utils.Package{{Name: "git", Reason: explicit}, {Name: "curl", Reason: dependency},
+				{Name: "libcurl", Reason: dependency}, {Name: "htop", Reason: explicit}},
+			extra: []string{"htop"},
+		},
+		{
+			name:  "full snapshot counts installed dependencies",
+			saved: []utils.Package{{Name: "git", Reason: explicit}, {Name: "zlib", Reason: dependency}},
+			live: []utils.Package{{Name: "git", Reason: explicit}, {Name: "zlib", Reason: dependency},
+				{Name: "libcurl", Reason: dependency}},
+			extra: []string{"libcurl"},
+		},
+		{
+			name: "matched by architecture",
+			saved: []utils.Package{{Name: "libc6", Version: "2.36", Arch: "amd64"},
+				{Name: "libc6", Version: "2.36", Arch: "i386"}},
+			live: []utils.Package{{Name: "libc6", Version: "2.37", Arch: "amd64"},
+				{Name: "libc6", Version: "2.36", Arch: "arm64"}},
+			missing: []string{"libc6:i386"},
+			extra:   []string{"libc6:arm64"},
+			changed: []VersionChange{{Name: "libc6", Arch: "amd64", Saved: "2.36", Current: "2.37"}},
+		},
+		{
+			name:  "single architecture is matched by name",
+			saved: []utils.Package{{Name: "git", Version: "2.43", Arch: "amd64"}},
+			live:  []utils.Package{{Name: "git", Version: "2.43", Arch: "arm64"}},
+		},
+	}
+
+	for _, test := range tests {
+		diff := CompareSnapshots(&SystemSnapshot{Packages: test.saved}, &SystemSnapshot{Packages: test.live})
+		if got := diffNames(diff.Missing); !reflect.DeepEqual(got, test.missing) {
+			t.Errorf("%s: missing %v, want %v", test.name, got, test.missing)
+		}
+		if got := diffNames(diff.Extra); !reflect.DeepEqual(got, test.extra) {
+			t.Errorf("%s: extra %v, want %v", test.name, got, test.extra)
+		}
+		if len(diff.Changed) != len(test.changed) || (len(test.changed) > 0 && !reflect.DeepEqual(diff.Changed, test.changed)) {
+			t.Errorf("%s: changed %+v, want %+v", test.name, diff.Changed, test.changed)
+		}
+		// compare exits non-zero exactly when the diff is not empty
+		wantEmpty := len(test.missing) == 0 && len(test.extra) == 0 && len(test.changed) == 0
+		if diff.Empty() != wantEmpty {
+			t.Errorf("%s: Empty() = %v, want %v", test.name, diff.Empty(), wantEmpty)
+		}
+	}
+}
+
+func diffNames(packages []utils.Package) []string {
+	var names []string
+	for _, pkg := range packages {
+		name := pkg.Name
+		if pkg.Name == "libc6" {
+			name = displayName(pkg.Name, pkg.Arch)
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func TestSnapshotDiffOutput(t *testing.T) {
+	diff := CompareSnapshots(
+		&SystemSnapshot{Packages: []utils.Package{{Name: "git", Version: "2.43"}, {Name: "vim", Version: "9.1"}}},
+		&SystemSnapshot{Packages: []utils.Package{{Name: "git", Version: "2.45"}, {Name: "htop", Version: "3.3"}}},
+	)
+
+	var text bytes.Buffer
+	diff.WriteText(&text)
+	for _, want := range []string{"Missing (1):\n  vim 9.1\n", "Extra (1):\n  htop 3.3\n", "Version changed (1):\n  git 2.43 -> 2.45\n"} {
+		if !strings.Contains(text.String(), want) {
+			t.Errorf("text output lacks %q:\n%s", want, text.String())
+		}
+	}
+
+	var out bytes.Buffer
+	if err := diff.WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	var decoded SnapshotDiff
+	if err := json.Unmarshal(out.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(&decoded, diff) {
+		t.Errorf("JSON round trip gave %+v, want %+v", decoded, *diff)
+	}
+
+	// an empty diff still has its three lists
+	out.Reset()
+	if err := CompareSnapshots(&SystemSnapshot{}, &SystemSnapshot{}).WriteJSON(&out); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{`"missing": []`, `"extra": []`, `"changed": []`} {
+		if !strings.Contains(out.String(), key) {
+			t.Errorf("empty diff lacks %s:\n%s", key, out.String())
+		}
+	}
+}
@@ -2,36 +2,32 @@ package output
 
 import (
 	"encoding/json"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
 )
 
+// SystemSnapshot is the layout of package.json.
+type SystemSnapshot struct {
+	OS         string          `json:"os"`
+	Distro     string          `json:"distro"`
+	BaseDistro string          `json:"base_distro"`
+	Packages   []utils.Package `json:"packages"`
+	// AURHelper is the helper AUR packages were installed with on Arch.
+	AURHelper string `json:"aur_helper,omitempty"`
+	// Sources are the third-party repositories packages were installed from.
+	Sources *utils.Sources `json:"sources,omitempty"`
+	// Portage holds the USE flags of Gentoo systems.
+	Portage *utils.PortageConfig `json:"portage,omitempty"`
+
+	Flatpaks       []utils.FlatpakApp    `json:"flatpaks,omitempty"`
+	FlatpakRemotes []utils.FlatpakRemote `json:"flatpak_remotes,omitempty"`
+	Snaps          []utils.Snap          `json:"snaps,omitempty"`
+
+	Tools *utils.Tools `json:"tools,omitempty"`
+}
+
 // BuildSystemJSON creates a well-structured JSON object for the system info and packages.
-func BuildSystemJSON(osType, distro, baseDistro string, packages []string) ([]byte, error) {
-	type ArchPackages struct {
-		Official []string `json:"official_packages"`
-		AUR      []string `json:"aur_packages"`
-	}
-	type SystemInfo struct {
-		OS         string      `json:"os"`
-		Distro     string      `json:"distro"`
-		BaseDistro string      `json:"base_distro"`
-		Packages   interface{} `json:"packages"`
-	}
-	if baseDistro == "arch" {
-		official, aur := SplitArchPackages(packages)
-		archPkgs := ArchPackages{Official: official, AUR: aur}
-		info := SystemInfo{
-			OS:         osType,
-			Distro:     distro,
-			BaseDistro: baseDistro,
-			Packages:   archPkgs,
-		}
-		return json.MarshalIndent(info, "", "  ")
-	}
-	info := SystemInfo{
-		OS:         osType,
-		Distro:     distro,
-		BaseDistro: baseDistro,
-		Packages:   packages,
-	}
-	return json.MarshalIndent(info, "", "  ")
-}
\ No newline at end of file
+// AUR packages on Arch are told apart by their repository.
+func BuildSystemJSON(snapshot *SystemSnapshot) ([]byte, error) {
+	return json.MarshalIndent(snapshot, "", "  ")
+}
@@ -0,0 +1,86 @@
+package output
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// where the NixOS module listing the system packages is written
+const nixModulePath = "/etc/nixos/sysreplicate-packages.nix"
+
+// nixPackagesSection installs profile packages with nix-env. System packages are declared,
+// so they go into a NixOS module the user imports into configuration.nix.
+func nixPackagesSection(manager utils.PackageManager, packages []utils.Package, policy VersionPolicy) string {
+	var system, profile []utils.Package
+	for _, pkg := range packages {
+		if pkg.Repository == utils.RepositoryNixSystem {
+			system = append(system, pkg)
+		} else {
+			profile = append(profile, pkg)
+		}
+	}
+
+	var b strings.Builder
+	if len(system) > 0 {
+		b.WriteString("echo 'Writing the system packages as a NixOS module...'\n")
+		writeRootFile(&b, nixModulePath, []byte(nixModule(system)), false)
+		b.WriteString(nixMissingReport(system))
+		fmt.Fprintf(&b, "echo %s\n", shellQuote("Add ./"+filepath.Base(nixModulePath)+" to the imports of configuration.nix and run nixos-rebuild switch"))
+	}
+	if len(profile) > 0 {
+		writeInstallBatches(&b, manager, profile, policy)
+	}
+	return b.String()
+}
+
+// nixModule lists the packages by attribute path, paths nixpkgs does not have are left out
+// with a warning instead of failing the whole rebuild.
+func nixModule(packages []utils.Package) string {
+	var b strings.Builder
+	b.WriteString("# generated by sysreplicate\n{ pkgs, lib, ... }:\nlet\n  names = [\n")
+	for _, pkg := range packages {
+		fmt.Fprintf(&b, "    %s\n", nixString(pkg.Name))
+	}
+	b.WriteString("  ];\n")
+	b.WriteString("  path = name: lib.splitString \".\" name;\n")
+	b.WriteString("  missing = builtins.filter (name: !(lib.hasAttrByPath (path name) pkgs)) names;\n")
+	b.WriteString("in\n{\n")
+	b.WriteString("  environment.systemPackages = map (name: lib.getAttrFromPath (path name) pkgs)\n")
+	b.WriteString("    (builtins.filter (name: !(builtins.elem name missing)) names);\n")
+	b.WriteString("  warnings = map (name: \"sysreplicate: nixpkgs has no ${name}, it is not installed\") missing;\n")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// nixMissingReport logs the packages nixpkgs has no attribute for as failed. Names are
+// derivation names, which often differ from the attribute path.
+func nixMissingReport(packages []utils.Package) string {
+	names := make([]string, len(packages))
+	for i, pkg := range packages {
+		names[i] = pkg.Name
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "if missing=\"$(nix-instantiate --eval --argstr names %s -E %s)\"; then\n",
+		shellQuote(strings.Join(names, " ")), shellQuote(nixMissingExpr))
+	b.WriteString("  for name in ${missing//\\\"/}; do\n")
+	b.WriteString("    echo \"nixpkgs has no $name, find its attribute with nix search and add it to configuration.nix\"\n")
+	b.WriteString("    install_failed \"nix system package $name, not in nixpkgs\"\n")
+	b.WriteString("  done\nelse\n")
+	b.WriteString("  echo 'Could not check the system packages against nixpkgs'\n")
+	b.WriteString("fi\n")
+	return b.String()
+}
+
+// nixMissingExpr evaluates to the space separated names that are no attribute path of nixpkgs
+const nixMissingExpr = `{ names }: let pkgs = import <nixpkgs> {}; lib = pkgs.lib; in toString (builtins.filter (name: !(lib.hasAttrByPath (lib.splitString "." name) pkgs)) (lib.splitString " " names))`
+
+// nixString quotes a value as a nix string literal
+func nixString(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `"`, `\"`)
+	value = strings.ReplaceAll(value, "${", `\${`)
+	return `"` + value + `"`
+}
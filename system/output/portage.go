@@ -0,0 +1,47 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// portageSection restores the USE flags before anything is emerged, so packages are built
+// the way they were on the old system.
+func portageSection(config *utils.PortageConfig) string {
+	if config == nil || (config.Use == "" && len(config.PackageUse) == 0) {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("echo 'Restoring Portage USE flags...'\n")
+	flags, invalid := validUseFlags(config.Use)
+	for _, flag := range invalid {
+		b.WriteString(rejected("USE flag %q", flag))
+	}
+	if len(flags) > 0 {
+		//make.conf is sourced by portage, the flags are added to whatever USE it sets
+		b.WriteString("if ! grep -qF '# sysreplicate' /etc/portage/make.conf; then\n  ")
+		writeRootFile(&b, "/etc/portage/make.conf", []byte(fmt.Sprintf("# sysreplicate\nUSE=\"${USE} %s\"\n", strings.Join(flags, " "))), true)
+		b.WriteString("fi\n")
+	}
+	var packageUse []string
+	for _, line := range config.PackageUse {
+		if validPackageUse(line) {
+			packageUse = append(packageUse, line)
+		} else {
+			b.WriteString(rejected("package.use line %q", line))
+		}
+	}
+	if len(packageUse) > 0 {
+		data := []byte(strings.Join(packageUse, "\n") + "\n")
+		//package.use may be a single file or a directory
+		b.WriteString("if [ -f /etc/portage/package.use ]; then\n  ")
+		writeRootFile(&b, "/etc/portage/package.use", data, true)
+		b.WriteString("else\n  ")
+		writeRootFile(&b, "/etc/portage/package.use/sysreplicate", data, false)
+		b.WriteString("fi\n")
+	}
+	return b.String()
+}
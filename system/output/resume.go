@@ -0,0 +1,75 @@
+package output
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// the script records each finished step here, a rerun skips them and resumes where the
+// last run stopped
+const stateSetup = `state_file="$(pwd)/setup-progress.txt"
+touch "$state_file"
+if [ -s "$state_file" ]; then
+  echo "Resuming, $(wc -l < "$state_file") steps are already done. Delete $state_file to start over."
+fi
+step_done() {
+  grep -qxF "$1" "$state_file"
+}
+mark_done() {
+  echo "$1" >> "$state_file"
+}
+`
+
+// installedSetup defines is_installed from the manager's check, and all_installed which
+// tells whether every package named in its arguments is installed. When the policy pins
+// versions they take a name and a version each, and a package only counts as installed at
+// the recorded version, or at a newer one under the minimum policy.
+func installedSetup(manager utils.PackageManager, policy VersionPolicy) string {
+	comparer, ok := manager.(utils.VersionComparer)
+	if !ok || !checksVersions(policy) {
+		return fmt.Sprintf("is_installed() {\n  %s\n}\nall_installed() {\n  for pkg in \"$@\"; do\n    is_installed \"$pkg\" || return 1\n  done\n}\n",
+			manager.InstalledCheck())
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "installed_version() {\n  %s\n}\nversion_older() {\n  %s\n}\n", comparer.InstalledVersion(), comparer.OlderVersion())
+	fmt.Fprintf(&b, "is_installed() {\n  %s || return 1\n", manager.InstalledCheck())
+	if policy == PolicyExact {
+		b.WriteString("  [ -z \"$2\" ] || [ \"$(installed_version \"$1\")\" = \"$2\" ]\n}\n")
+	} else {
+		b.WriteString("  [ -z \"$2\" ] || ! version_older \"$(installed_version \"$1\")\" \"$2\"\n}\n")
+	}
+	b.WriteString("all_installed() {\n  while [ $# -gt 0 ]; do\n    is_installed \"$1\" \"$2\" || return 1\n    shift 2\n  done\n}\n")
+	if policy == PolicyMinimum {
+		b.WriteString(reportOlderSetup)
+	}
+	return b.String()
+}
+
+// report_older takes names and versions like all_installed and reports the packages
+// installed at an older version than recorded
+const reportOlderSetup = `report_older() {
+  while [ $# -gt 0 ]; do
+    if version="$(installed_version "$1")" && [ -n "$version" ] && [ -n "$2" ] && version_older "$version" "$2"; then
+      echo "$1: installed $version, older than the recorded $2" >> "$version_report"
+    fi
+    shift 2
+  done
+}
+`
+
+// installStep renders a command that is skipped once it succeeded, a failure is logged
+func installStep(label, command string) string {
+	return fmt.Sprintf("step_done %[1]s || { %[2]s && mark_done %[1]s; } || install_failed %[1]s\n", shellQuote(label), command)
+}
+
+// batchStep names a batch by its install command, a rerun with another snapshot or version
+// policy does not skip it
+func batchStep(manager utils.PackageManager, command string) string {
+	sum := sha256.Sum256([]byte(command))
+	return manager.Name() + " batch " + hex.EncodeToString(sum[:6])
+}
@@ -1,107 +1,220 @@
 package output
 
 import (
+	"encoding/base64"
 	"fmt"
 	"os"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
 )
 
-// splitArchPackages splits the combined package list into official and AUR packages for Arch-based distros.
-func SplitArchPackages(packages []string) (official, aur []string) {
-	isAUR := false
+// leafPackages drops packages recorded as dependencies, the new system pulls in its own.
+func leafPackages(packages []utils.Package) (leaves []utils.Package, dependencies int) {
 	for _, pkg := range packages {
-		if pkg == "YayPackages" {
-			isAUR = true
+		if pkg.Reason == utils.ReasonDependency {
+			dependencies++
 			continue
 		}
-		if isAUR {
-			if pkg != "" {
-				aur = append(aur, pkg)
-			}
-		} else {
-			if pkg != "" {
-				official = append(official, pkg)
-			}
-		}
+		leaves = append(leaves, pkg)
 	}
 	return
 }
 
-// generateInstallScript creates a shell script to install all packages for the given distro.
+// generateInstallScript creates a shell script to install all packages for the given distro,
+// followed by the global language tools, Flatpak and Snap applications of the snapshot.
+// The script tries every step, and exits non-zero at the end when any of them failed.
 // Returns an error if the script cannot be created or written.
-func GenerateInstallScript(baseDistro string, packages []string, scriptPath string) error {
+func GenerateInstallScript(baseDistro string, snapshot *SystemSnapshot, options ScriptOptions, scriptPath string) error {
 	f, err := os.Create(scriptPath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
-	_, err = f.WriteString("#!/bin/bash\nset -e\necho 'Starting package installation...'\n")
+	_, err = f.WriteString("#!/bin/bash\nset -e\necho 'Starting package installation...'\n" + failureLogSetup + stateSetup)
 	if err != nil {
 		return err
 	}
 
-	var installCmd string
-	switch baseDistro {
-	case "debian":
-		installCmd = "sudo apt-get install -y"
-	case "arch":
-		installCmd = "sudo pacman -S --noconfirm"
-	case "rhel", "fedora":
-		installCmd = "sudo dnf install -y"
-	case "void":
-		installCmd = "sudo xbps-install -y"
-	default:
-		_, _ = f.WriteString("echo 'Unsupported distro for script generation.'\n")
-		return nil
-	}
-
-	if baseDistro == "arch" {
-		official, aur := SplitArchPackages(packages)
-		_, err = f.WriteString("echo 'Installing official packages with pacman...'\n")
+	packages, dependencies := leafPackages(snapshot.Packages)
+	if dependencies > 0 {
+		_, err = f.WriteString(fmt.Sprintf("echo 'Leaving %d dependency packages to the package manager'\n", dependencies))
 		if err != nil {
 			return err
 		}
-		for _, pkg := range official {
-			if pkg == "" {
-				continue
-			}
-			_, err = f.WriteString(fmt.Sprintf("%s %s || true\n", installCmd, pkg))
-			if err != nil {
-				return err
-			}
+	}
+
+	manager := utils.ManagerFor(baseDistro)
+	if aur, ok := manager.(utils.AURManager); ok && snapshot.AURHelper != "" {
+		manager = aur.WithAURHelper(snapshot.AURHelper)
+	}
+	if manager == nil {
+		if _, err := f.WriteString("echo 'Unsupported distro for script generation.'\n"); err != nil {
+			return err
 		}
-		_, err = f.WriteString("if ! command -v yay >/dev/null; then\n  echo 'yay not found, installing yay...'\n  sudo pacman -S --noconfirm yay\nfi\n")
-		if err != nil {
+	}
+
+	//tools, flatpak and snap apps are distro independent and installed even without native packages
+	if manager != nil {
+		if _, err := f.WriteString(sourcesSection(manager, snapshot.Sources)); err != nil {
 			return err
 		}
-		_, err = f.WriteString("echo 'Installing AUR packages with yay...'\n")
-		if err != nil {
+		if _, err := f.WriteString(portageSection(snapshot.Portage)); err != nil {
 			return err
 		}
-		for _, pkg := range aur {
-			if pkg == "" {
-				continue
-			}
-			_, err = f.WriteString(fmt.Sprintf("yay -S --noconfirm %s || true\n", pkg))
-			if err != nil {
-				return err
-			}
+		if _, err := f.WriteString(nativePackagesSection(manager, packages, options.Policy)); err != nil {
+			return err
 		}
-		return nil
 	}
-
-	_, err = f.WriteString(fmt.Sprintf("echo 'Installing packages with %s...'\n", installCmd))
-	if err != nil {
+	if _, err := f.WriteString(toolsSection(manager, snapshot.Tools)); err != nil {
 		return err
 	}
-	for _, pkg := range packages {
-		if pkg == "" {
+	if _, err := f.WriteString(flatpakSection(manager, snapshot)); err != nil {
+		return err
+	}
+	if _, err := f.WriteString(snapSection(manager, snapshot)); err != nil {
+		return err
+	}
+	_, err = f.WriteString(failureSummary)
+	return err
+}
+
+// nativePackagesSection installs the packages at the versions the policy asks for and reports
+// those not at their recorded version. Packages recorded as dependencies were left out by the
+// caller, the new system pulls in its own.
+func nativePackagesSection(manager utils.PackageManager, packages []utils.Package, policy VersionPolicy) string {
+	var b strings.Builder
+	packages, rejects := validPackages(manager, packages)
+	b.WriteString(rejects)
+	report := checksVersions(policy)
+	if report {
+		b.WriteString(versionReportSetup)
+	}
+	if manager.Name() == "nix" {
+		b.WriteString(nixPackagesSection(manager, packages, policy))
+	} else {
+		writeInstallBatches(&b, manager, packages, policy)
+	}
+	if report {
+		b.WriteString(versionReportSummary)
+	}
+	return b.String()
+}
+
+// flatpakSection installs flatpak if needed, adds the remotes the apps come from and
+// installs every app in its original scope.
+func flatpakSection(manager utils.PackageManager, snapshot *SystemSnapshot) string {
+	if len(snapshot.Flatpaks) == 0 {
+		return ""
+	}
+
+	var b, s strings.Builder
+	b.WriteString("echo 'Installing Flatpak applications...'\n")
+
+	used := make(map[string]bool)
+	for _, app := range snapshot.Flatpaks {
+		used[app.Scope+"/"+app.Remote] = true
+	}
+	for _, remote := range snapshot.FlatpakRemotes {
+		if !used[remote.Scope+"/"+remote.Name] {
 			continue
 		}
-		_, err = f.WriteString(fmt.Sprintf("%s %s || true\n", installCmd, pkg))
-		if err != nil {
-			return err
+		if !validFlatpakScope(remote.Scope) || !utils.SafeArgument(remote.Name) || !utils.SafeArgument(remote.URL) {
+			b.WriteString(rejected("flatpak remote %q", remote.Name))
+			continue
+		}
+		prefix := flatpakPrefix(remote.Scope)
+		switch {
+		case len(remote.GPGKey) > 0:
+			//the remote's own keyring goes through a temp file, it is binary
+			fmt.Fprintf(&s, "keyring=$(mktemp)\necho %s | base64 -d > \"$keyring\"\n",
+				shellQuote(base64.StdEncoding.EncodeToString(remote.GPGKey)))
+			fmt.Fprintf(&s, "%s remote-add --%s --if-not-exists --gpg-import=\"$keyring\" %s %s || true\nrm -f \"$keyring\"\n",
+				prefix, remote.Scope, shellQuote(remote.Name), shellQuote(remote.URL))
+		case remote.Name == "flathub":
+			fmt.Fprintf(&s, "%s remote-add --%s --if-not-exists flathub %s || true\n", prefix, remote.Scope, utils.FlathubURL)
+		default:
+			fmt.Fprintf(&s, "echo %s\n", shellQuote(fmt.Sprintf("Add the flatpak remote %s (%s) with its signing key manually", remote.Name, remote.URL)))
+		}
+	}
+
+	for _, app := range snapshot.Flatpaks {
+		if !validFlatpakScope(app.Scope) || !utils.SafeArgument(app.Remote) || !utils.SafeArgument(app.ID) || !utils.SafeArgument(app.Branch) {
+			b.WriteString(rejected("flatpak %q", app.ID))
+			continue
 		}
+		s.WriteString(installStep("flatpak "+app.ID, fmt.Sprintf("%s install --%s -y --noninteractive %s %s",
+			flatpakPrefix(app.Scope), app.Scope, shellQuote(app.Remote), shellQuote(app.ID+"//"+app.Branch))))
 	}
-	return nil
+	writeRequiring(&b, "flatpak", manager, "flatpak", s.String())
+	return b.String()
 }
+
+// flatpakPrefix runs system wide flatpak commands as root
+func flatpakPrefix(scope string) string {
+	if scope == "user" {
+		return "flatpak"
+	}
+	return "sudo flatpak"
+}
+
+// snapSection installs snapd if needed and every snap from the channel it tracked.
+func snapSection(manager utils.PackageManager, snapshot *SystemSnapshot) string {
+	if len(snapshot.Snaps) == 0 {
+		return ""
+	}
+
+	var b, s strings.Builder
+	b.WriteString("echo 'Installing snaps...'\n")
+	for _, snap := range snapshot.Snaps {
+		if !utils.SafeArgument(snap.Name) {
+			b.WriteString(rejected("snap %q", snap.Name))
+			continue
+		}
+		//snaps installed from a local file track no channel and cannot be fetched again
+		if snap.Channel == "" || snap.Channel == "-" {
+			fmt.Fprintf(&s, "echo %s\n", shellQuote("Skipping locally installed snap "+snap.Name))
+			continue
+		}
+		if !utils.SafeArgument(snap.Channel) {
+			b.WriteString(rejected("snap %q channel %q", snap.Name, snap.Channel))
+			continue
+		}
+		flag := ""
+		if snap.Confinement == "classic" || snap.Confinement == "devmode" {
+			flag = " --" + snap.Confinement
+		}
+		s.WriteString(installStep("snap "+snap.Name, fmt.Sprintf("sudo snap install %s --channel=%s%s", shellQuote(snap.Name), shellQuote(snap.Channel), flag)))
+	}
+	writeRequiring(&b, "snap", manager, "snapd", s.String())
+	return b.String()
+}
+
+// writeRequiring writes installs that need a command, after installing the package
+// providing it when it is missing. When it cannot be installed the installs are skipped and
+// the package is logged as failed, the rest of the script goes on.
+func writeRequiring(b *strings.Builder, command string, manager utils.PackageManager, pkg, installs string) {
+	if installs == "" {
+		return
+	}
+	check, ok := toolchainChecks[command]
+	if !ok {
+		check = fmt.Sprintf("command -v %s >/dev/null", command)
+	}
+	message := fmt.Sprintf("Could not install %s, skipping what needs %s", pkg, command)
+	if manager == nil {
+		fmt.Fprintf(b, "if %s; then\n", check)
+		message = fmt.Sprintf("Install %s first, skipping what needs it", pkg)
+	} else {
+		fmt.Fprintf(b, "if %s || { %s && %s; }; then\n", check, manager.InstallCommand(utils.Package{Name: pkg}), check)
+	}
+	b.WriteString(indent(installs, "  "))
+	b.WriteString("else\n")
+	fmt.Fprintf(b, "  echo %s\n", shellQuote(message))
+	fmt.Fprintf(b, "  install_failed %s\n", shellQuote(pkg+", skipped what needs "+command))
+	b.WriteString("fi\n")
+}
+
+// shellQuote wraps a value in single quotes for bash
+var shellQuote = utils.ShellQuote
@@ -0,0 +1,467 @@
+package output
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// families and a valid package name of each
+var testFamilies = map[string]string{
+	"debian": "git",
+	"arch":   "git",
+	"fedora": "git",
+	"suse":   "git",
+	"void":   "git",
+	"alpine": "git",
+	"gentoo": "dev-vcs/git",
+	"nixos":  "git",
+}
+
+// hostileNames are package names a tampered package.json could carry
+func hostileNames(marker string) []string {
+	return []string{
+		"vim; touch " + marker,
+		"$(touch " + marker + ")",
+		"`touch " + marker + "`",
+		"vim' ; touch " + marker + " ; '",
+		"vim\ntouch " + marker,
+		"vim\tinstall",
+		"curl install",
+		"-oAPT::Update::Pre-Invoke::=touch " + marker,
+		"--overwrite=*",
+		"",
+	}
+}
+
+func TestValidPackagesRejectsHostileNames(t *testing.T) {
+	for family, name := range testFamilies {
+		manager := utils.ManagerFor(family)
+		packages := []utils.Package{{Name: name, Version: "1.0-1"}}
+		for _, hostile := range hostileNames("/tmp/pwned") {
+			packages = append(packages, utils.Package{Name: hostile})
+		}
+		packages = append(packages, utils.Package{Name: name, Version: "1.0; touch /tmp/pwned"})
+
+		valid, rejects := validPackages(manager, packages)
+		if len(valid) != 1 || valid[0].Version != "1.0-1" {
+			t.Errorf("%s: kept %v, want only %s 1.0-1", family, valid, name)
+		}
+		if got := strings.Count(rejects, "install_failed "); got != len(packages)-1 {
+			t.Errorf("%s: reported %d rejected packages, want %d", family, got, len(packages)-1)
+		}
+	}
+}
+
+func TestSourcesStayInManagerDirectories(t *testing.T) {
+	sources := &utils.Sources{
+		Manager: "apt",
+		Repositories: []utils.Repository{
+			{Name: "vendor", Path: "/etc/apt/sources.list.d/vendor.list", Definition: "deb https://example.com stable main\n"},
+			{Name: "sudoers", Path: "/etc/sudoers.d/evil", Definition: "ALL ALL=(ALL) NOPASSWD: ALL\n"},
+			{Name: "escape", Path: "/etc/apt/sources.list.d/../../sudoers", Definition: "x\n"},
+		},
+		Keys: []utils.SigningKey{
+			{Path: "/etc/apt/keyrings/vendor.gpg", Data: []byte("key")},
+			{Path: "/root/.bashrc", Data: []byte("touch /tmp/pwned\n")},
+		},
+	}
+	section := sourcesSection(utils.ManagerFor("debian"), sources)
+	for _, want := range []string{"/etc/apt/sources.list.d/vendor.list", "/etc/apt/keyrings/vendor.gpg"} {
+		if !strings.Contains(section, "sudo tee '"+want+"'") {
+			t.Errorf("%s is not written:\n%s", want, section)
+		}
+	}
+	for _, path := range []string{"/etc/sudoers.d/evil", "/etc/apt/sources.list.d/../../sudoers", "/root/.bashrc"} {
+		if strings.Contains(section, "tee '"+path+"'") {
+			t.Errorf("%s is written:\n%s", path, section)
+		}
+	}
+
+	// repositories of another manager are never written
+	section = sourcesSection(utils.ManagerFor("arch"), sources)
+	if strings.Contains(section, "tee") {
+		t.Errorf("apt repositories are written on arch:\n%s", section)
+	}
+}
+
+func TestAptKeyrings(t *testing.T) {
+	keys := []utils.SigningKey{
+		{Path: "/usr/share/keyrings/vendor.gpg", Data: []byte("vendor")},
+		{Path: "/etc/apt/trusted.gpg.d/legacy.asc", Data: []byte("legacy")},
+	}
+	tests := []struct {
+		name       string
+		path       string
+		definition string
+		want       string
+	}{
+		{"signed-by is moved", "/etc/apt/sources.list.d/vendor.list",
+			"deb [arch=amd64 signed-by=/usr/share/keyrings/vendor.gpg] https://vendor.example stable main\n",
+			"deb [arch=amd64 signed-by=/etc/apt/keyrings/vendor.gpg] https://vendor.example stable main\n"},
+		{"no options", "/etc/apt/sources.list.d/old.list",
+			"deb https://old.example stable main\n",
+			"deb [signed-by=/etc/apt/keyrings/legacy.asc] https://old.example stable main\n"},
+		{"options without signed-by", "/etc/apt/sources.list.d/old.list",
+			"deb [arch=amd64] https://old.example stable main\n",
+			"deb [arch=amd64 signed-by=/etc/apt/keyrings/legacy.asc] https://old.example stable main\n"},
+		{"deb822 Signed-By is moved", "/etc/apt/sources.list.d/vendor.sources",
+			"Types: deb\nURIs: https://vendor.example\nSigned-By: /usr/share/keyrings/vendor.gpg\n",
+			"Types: deb\nURIs: https://vendor.example\nSigned-By: /etc/apt/keyrings/vendor.gpg\n"},
+		{"deb822 without Signed-By", "/etc/apt/sources.list.d/old.sources",
+			"Types: deb\nURIs: https://old.example\n\nTypes: deb-src\nURIs: https://old.example\n",
+			"Types: deb\nURIs: https://old.example\nSigned-By: /etc/apt/keyrings/legacy.asc\n\nTypes: deb-src\nURIs: https://old.example\nSigned-By: /etc/apt/keyrings/legacy.asc\n"},
+	}
+	for _, test := range tests {
+		moved := aptKeyrings(&utils.Sources{Manager: "apt", Keys: keys, Repositories: []utils.Repository{{Path: test.path, Definition: test.definition}}})
+		if got := moved.Repositories[0].Definition; got != test.want {
+			t.Errorf("%s: got\n%s\nwant\n%s", test.name, got, test.want)
+		}
+		for _, key := range moved.Keys {
+			if filepath.Dir(key.Path)+"/" != aptKeyringDir {
+				t.Errorf("%s: key written to %s", test.name, key.Path)
+			}
+		}
+	}
+}
+
+// TestSourcesNeedTrust runs the sources section without a terminal, where only
+// --trust-sources adds the repositories
+func TestSourcesNeedTrust(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	sources := &utils.Sources{
+		Manager:      "apt",
+		Repositories: []utils.Repository{{Name: "vendor", Path: "/etc/apt/sources.list.d/vendor.list", URIs: []string{"https://vendor.example"}, Definition: "deb https://vendor.example stable main\n"}},
+		Keys:         []utils.SigningKey{{Path: "/etc/apt/keyrings/vendor.gpg", Data: []byte("key")}},
+	}
+	section := sourcesSection(utils.ManagerFor("debian"), sources)
+	tests := []struct {
+		args     []string
+		written  bool
+		failures string
+	}{
+		{nil, false, "package repositories, not trusted\n"},
+		{[]string{"--trust-sources"}, true, ""},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		log := filepath.Join(dir, "sudo.log")
+		if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte("#!/bin/sh\necho \"$*\" >> '"+log+"'\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(bash, append([]string{"-c", failureLogSetup + section, "setup.sh"}, test.args...)...)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "PATH="+dir+":/usr/bin:/bin")
+		out, err := cmd.CombinedOutput()
+		if err != nil {
+			t.Fatalf("%v: %v\n%s", test.args, err, out)
+		}
+		if !strings.Contains(string(out), "repository vendor: https://vendor.example") || !strings.Contains(string(out), "key /etc/apt/keyrings/vendor.gpg") {
+			t.Errorf("%v: the sources are not listed:\n%s", test.args, out)
+		}
+		logged, _ := os.ReadFile(log)
+		if written := strings.Contains(string(logged), "tee /etc/apt/sources.list.d/vendor.list"); written != test.written {
+			t.Errorf("%v: repository written %v, want %v:\n%s", test.args, written, test.written, logged)
+		}
+		failures, _ := os.ReadFile(filepath.Join(dir, "install-failures.txt"))
+		if string(failures) != test.failures {
+			t.Errorf("%v: failure log %q, want %q", test.args, failures, test.failures)
+		}
+	}
+}
+
+// commands setup.sh may run, stubbed to log their arguments
+var stubbedCommands = []string{
+	"sudo", "apt-get", "dpkg-query", "pacman", "pacman-key", "yay", "rpm", "dnf", "zypper",
+	"xbps-install", "xbps-query", "apk", "emerge", "portageq", "nix-env", "flatpak", "snap",
+	"pipx", "python3", "npm", "cargo", "go", "gem",
+}
+
+// writeStubs creates commands that log their arguments one per line. Unless sudoFails, sudo
+// succeeds so the script goes on, everything else fails so install fallbacks run too. The
+// missing commands are left out.
+func writeStubs(t *testing.T, dir, log string, sudoFails bool, missing ...string) {
+	t.Helper()
+	for _, name := range stubbedCommands {
+		if slices.Contains(missing, name) {
+			continue
+		}
+		status := "1"
+		if name == "sudo" && !sudoFails {
+			status = "0"
+		}
+		script := "#!/bin/sh\nprintf '%s\\n' \"$(basename \"$0\")\" \"$@\" >> '" + log + "'\n" +
+			"[ -t 0 ] || cat > /dev/null\nexit " + status + "\n"
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestGeneratedScriptRunsNoInjectedCode runs setup.sh generated from a crafted snapshot
+// against stubbed package managers and checks that no payload ran and no value was taken
+// for an option.
+func TestGeneratedScriptRunsNoInjectedCode(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+
+	for family, name := range testFamilies {
+		t.Run(family, func(t *testing.T) {
+			dir := t.TempDir()
+			marker := filepath.Join(dir, "pwned")
+			stubs := filepath.Join(dir, "bin")
+			log := filepath.Join(dir, "commands.log")
+			if err := os.Mkdir(stubs, 0755); err != nil {
+				t.Fatal(err)
+			}
+			writeStubs(t, stubs, log, false)
+
+			payload := "$(touch " + marker + ")"
+			snapshot := &SystemSnapshot{
+				Packages: []utils.Package{{Name: name, Version: "1.0-1"}, {Name: name + "x", Version: "2.0" + payload}},
+				Tools: &utils.Tools{
+					Pip:   []utils.Tool{{Name: "--index-url=http://evil.example", Version: "1"}},
+					Npm:   []utils.Tool{{Name: payload, Version: "1.0.0"}},
+					Cargo: []utils.Tool{{Name: "ripgrep", Source: "git+-oProxyCommand=touch " + marker}},
+					Go:    []utils.Tool{{Name: "x", Source: "example.com/x;touch " + marker, Version: "v1.0.0"}},
+				},
+				Flatpaks: []utils.FlatpakApp{
+					{ID: "org.example.App", Branch: "stable", Remote: "flathub", Scope: "user --installation=evil"},
+					{ID: "org.example.Other", Branch: "stable`touch " + marker + "`", Remote: "flathub", Scope: "user"},
+				},
+				Snaps: []utils.Snap{{Name: "--dangerous", Channel: "stable"}, {Name: "hello", Channel: "stable;touch " + marker}},
+				Portage: &utils.PortageConfig{
+					Use:        "X wayland " + payload,
+					PackageUse: []string{"dev-vcs/git curl", "dev-vcs/git $(touch " + marker + ")"},
+				},
+			}
+			for _, hostile := range hostileNames(marker) {
+				snapshot.Packages = append(snapshot.Packages, utils.Package{Name: hostile})
+			}
+
+			script := filepath.Join(dir, "setup.sh")
+			for _, policy := range VersionPolicies {
+				if err := GenerateInstallScript(family, snapshot, ScriptOptions{Policy: policy}, script); err != nil {
+					t.Fatal(err)
+				}
+				cmd := exec.Command(bash, script)
+				cmd.Dir = dir
+				cmd.Env = append(os.Environ(), "PATH="+stubs+":/usr/bin:/bin")
+				out, _ := cmd.CombinedOutput()
+				if !strings.Contains(string(out), "installs failed") {
+					t.Fatalf("%s: the script stopped early:\n%s", policy, out)
+				}
+				if _, err := os.Stat(marker); err == nil {
+					t.Fatalf("%s: an injected command ran:\n%s", policy, out)
+				}
+			}
+
+			logged, err := os.ReadFile(log)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, arg := range strings.Split(string(logged), "\n") {
+				for _, option := range []string{"-oAPT", "--overwrite", "--index-url", "--installation", "--dangerous", "-oProxyCommand"} {
+					if strings.HasPrefix(arg, option) {
+						t.Errorf("%q reached a command as an option", arg)
+					}
+				}
+			}
+
+			failures, err := os.ReadFile(filepath.Join(dir, "install-failures.txt"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Count(string(failures), "rejected package:"); got != len(hostileNames(marker))+1 {
+				t.Errorf("%d packages reported as rejected, want %d:\n%s", got, len(hostileNames(marker))+1, failures)
+			}
+		})
+	}
+}
+
+// TestFailedInstallsDoNotStopScript runs setup.sh with a failing sudo and neither pipx nor
+// flatpak installed, and checks that every section is still tried and that the failures are
+// summed up at the end.
+func TestFailedInstallsDoNotStopScript(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir := t.TempDir()
+	stubs := filepath.Join(dir, "bin")
+	log := filepath.Join(dir, "commands.log")
+	if err := os.Mkdir(stubs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeStubs(t, stubs, log, true, "pipx", "flatpak")
+	//only the stubs and the utilities setup.sh needs are on PATH, so a pipx or flatpak
+	//installed on this machine is not found
+	for _, name := range []string{"basename", "cat", "grep", "touch", "wc"} {
+		path, err := exec.LookPath(name)
+		if err != nil {
+			t.Skipf("%s is not installed", name)
+		}
+		if err := os.Symlink(path, filepath.Join(stubs, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshot := &SystemSnapshot{
+		Packages: []utils.Package{{Name: "git"}},
+		Tools: &utils.Tools{
+			Pipx: []utils.Tool{{Name: "black"}},
+			Go:   []utils.Tool{{Name: "gopls", Source: "golang.org/x/tools/gopls"}},
+		},
+		Flatpaks: []utils.FlatpakApp{{ID: "org.example.App", Branch: "stable", Remote: "flathub", Scope: "user"}},
+		Snaps:    []utils.Snap{{Name: "hello", Channel: "stable"}},
+	}
+	script := filepath.Join(dir, "setup.sh")
+	if err := GenerateInstallScript("debian", snapshot, ScriptOptions{}, script); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(bash, script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PATH="+stubs)
+	out, err := cmd.CombinedOutput()
+	if exit, ok := err.(*exec.ExitError); !ok || exit.ExitCode() != 1 {
+		t.Fatalf("setup.sh exited with %v, want 1:\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "5 installs failed") {
+		t.Errorf("no failure summary:\n%s", out)
+	}
+
+	logged, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"go\ninstall\ngolang.org/x/tools/gopls@latest\n", "sudo\nsnap\ninstall\nhello\n"} {
+		if !strings.Contains(string(logged), want) {
+			t.Errorf("%q was not run:\n%s", want, logged)
+		}
+	}
+
+	failures, err := os.ReadFile(filepath.Join(dir, "install-failures.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "git\npipx, skipped what needs pipx\ngo gopls\nflatpak, skipped what needs flatpak\nsnap hello\n"
+	if string(failures) != want {
+		t.Errorf("failure log:\n%s\nwant:\n%s", failures, want)
+	}
+}
+
+// TestPipToolsNeedAManagedPython runs the pip tools of setup.sh against a python3 stub whose
+// stdlib is or is not marked externally managed, and a pip module that is or is not there
+func TestPipToolsNeedAManagedPython(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	tests := []struct {
+		name     string
+		pip      bool
+		managed  bool
+		installs bool
+		failures string
+	}{
+		{"pip installs", true, false, true, ""},
+		{"externally managed", true, true, false, "pip httpie, python is externally managed\n"},
+		{"no pip module", false, false, false, "python3-pip, skipped what needs pip\n"},
+	}
+	for _, test := range tests {
+		dir := t.TempDir()
+		stubs := filepath.Join(dir, "bin")
+		stdlib := filepath.Join(dir, "stdlib")
+		log := filepath.Join(dir, "commands.log")
+		for _, path := range []string{stubs, stdlib} {
+			if err := os.Mkdir(path, 0755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if test.managed {
+			if err := os.WriteFile(filepath.Join(stdlib, "EXTERNALLY-MANAGED"), nil, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		pipStatus := "1"
+		if test.pip {
+			pipStatus = "0"
+		}
+		python := "#!/bin/sh\ncase \"$1 $2\" in\n" +
+			"  '-m pip') [ \"$3\" = --version ] && exit " + pipStatus + "; echo \"$@\" >> '" + log + "' ;;\n" +
+			"  -c*) echo '" + stdlib + "' ;;\nesac\n"
+		if err := os.WriteFile(filepath.Join(stubs, "python3"), []byte(python), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(stubs, "sudo"), []byte("#!/bin/sh\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		script := filepath.Join(dir, "setup.sh")
+		snapshot := &SystemSnapshot{Tools: &utils.Tools{Pip: []utils.Tool{{Name: "httpie", Version: "3.2.2"}}}}
+		if err := GenerateInstallScript("debian", snapshot, ScriptOptions{}, script); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(bash, script)
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "PATH="+stubs+":/usr/bin:/bin")
+		out, _ := cmd.CombinedOutput()
+
+		logged, _ := os.ReadFile(log)
+		if installed := strings.Contains(string(logged), "install --user httpie==3.2.2"); installed != test.installs {
+			t.Errorf("%s: pip install ran %v, want %v:\n%s", test.name, installed, test.installs, out)
+		}
+		failures, _ := os.ReadFile(filepath.Join(dir, "install-failures.txt"))
+		if string(failures) != test.failures {
+			t.Errorf("%s: failure log %q, want %q", test.name, failures, test.failures)
+		}
+	}
+}
+
+// TestNixMissingReport runs the nixpkgs check of the system packages against a stubbed
+// nix-instantiate
+func TestNixMissingReport(t *testing.T) {
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	tests := []struct {
+		name     string
+		output   string
+		status   string
+		failures string
+	}{
+		{"all found", `""`, "0", ""},
+		{"derivation names", `"python3.12-requests gnome-shell-extension"`, "0",
+			"nix system package python3.12-requests, not in nixpkgs\nnix system package gnome-shell-extension, not in nixpkgs\n"},
+		{"no channel", "", "1", ""},
+	}
+	packages := []utils.Package{{Name: "git"}, {Name: "python3.12-requests"}, {Name: "gnome-shell-extension"}}
+	for _, test := range tests {
+		dir := t.TempDir()
+		stub := "#!/bin/sh\nprintf '%s\\n' " + shellQuote(test.output) + "\nexit " + test.status + "\n"
+		if err := os.WriteFile(filepath.Join(dir, "nix-instantiate"), []byte(stub), 0755); err != nil {
+			t.Fatal(err)
+		}
+		cmd := exec.Command(bash, "-c", failureLogSetup+nixMissingReport(packages))
+		cmd.Dir = dir
+		cmd.Env = append(os.Environ(), "PATH="+dir+":/usr/bin:/bin")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("%s: %v\n%s", test.name, err, out)
+		}
+		failures, _ := os.ReadFile(filepath.Join(dir, "install-failures.txt"))
+		if string(failures) != test.failures {
+			t.Errorf("%s: failure log %q, want %q", test.name, failures, test.failures)
+		}
+	}
+}
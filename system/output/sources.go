@@ -0,0 +1,227 @@
+package output
+
+import (
+	"encoding/base64"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// sourcesSection lists the repositories and signing keys of the snapshot, and writes them
+// and refreshes the package lists once the user trusts them, either by answering the prompt
+// or by running setup.sh --trust-sources. Otherwise they are skipped and logged as failed.
+func sourcesSection(manager utils.PackageManager, sources *utils.Sources) string {
+	if sources.Count() == 0 {
+		return ""
+	}
+
+	var b, w strings.Builder
+	if sources.Manager != manager.Name() {
+		return rejected("%d repositories of %s", sources.Count(), sources.Manager)
+	}
+	if sources.Manager == "apt" {
+		sources = aptKeyrings(sources)
+	}
+	b.WriteString("echo 'The snapshot adds these package repositories and signing keys:'\n")
+	for _, key := range sources.Keys {
+		switch {
+		case key.Fingerprint != "" && !keyFingerprint.MatchString(key.Fingerprint):
+			b.WriteString(rejected("signing key %q", key.Fingerprint))
+		case key.Fingerprint == "" && key.Path != "" && !validSourcePath(sources.Manager, key.Path):
+			b.WriteString(rejected("signing key %s", key.Path))
+		case sources.Manager == "pacman" && key.Fingerprint != "":
+			fmt.Fprintf(&b, "echo %s\n", shellQuote("  key "+key.Fingerprint))
+			fmt.Fprintf(&w, "keyring=$(mktemp)\necho %s | base64 -d > \"$keyring\"\n", shellQuote(base64.StdEncoding.EncodeToString(key.Data)))
+			fmt.Fprintf(&w, "sudo pacman-key --add \"$keyring\" && sudo pacman-key --lsign-key %s || true\nrm -f \"$keyring\"\n", shellQuote(key.Fingerprint))
+		case key.Path != "":
+			//the fingerprints shown are those of the key data, not what the snapshot claims
+			fmt.Fprintf(&b, "echo %s\n", shellQuote("  key "+key.Path))
+			fmt.Fprintf(&b, "echo %s | base64 -d | gpg --show-keys --with-colons 2>/dev/null | awk -F: '$1 == \"fpr\" { print \"    \" $10 }' || true\n",
+				shellQuote(base64.StdEncoding.EncodeToString(key.Data)))
+			writeRootFile(&w, key.Path, key.Data, false)
+		}
+	}
+
+	for _, repo := range sources.Repositories {
+		if !validSourcePath(sources.Manager, repo.Path) {
+			b.WriteString(rejected("repository %q at %s", repo.Name, repo.Path))
+			continue
+		}
+		fmt.Fprintf(&b, "echo %s\n", shellQuote("  repository "+repo.Name+": "+strings.Join(repo.URIs, " ")))
+		fmt.Fprintf(&w, "echo %s\n", shellQuote("Adding repository "+repo.Name))
+		if sources.Manager == "pacman" {
+			//custom repos are sections of pacman.conf, added once
+			fmt.Fprintf(&w, "if ! grep -qxF -e %s %s; then\n", shellQuote("["+repo.Name+"]"), shellQuote(repo.Path))
+			w.WriteString("  ")
+			writeRootFile(&w, repo.Path, []byte("\n"+repo.Definition), true)
+			w.WriteString("fi\n")
+			continue
+		}
+		if sources.Manager == "apk" {
+			//apk keeps one repository per line of a single file
+			for _, line := range strings.Split(strings.TrimSpace(repo.Definition), "\n") {
+				fmt.Fprintf(&w, "grep -qxF -e %s %s || ", shellQuote(line), shellQuote(repo.Path))
+				writeRootFile(&w, repo.Path, []byte(line+"\n"), true)
+			}
+			continue
+		}
+		writeRootFile(&w, repo.Path, []byte(repo.Definition), false)
+	}
+	if w.Len() == 0 {
+		return b.String()
+	}
+
+	if sourceManager, ok := manager.(utils.SourceManager); ok {
+		fmt.Fprintf(&w, "%s || true\n", sourceManager.RefreshCommand())
+	}
+	b.WriteString(trustSourcesSetup)
+	b.WriteString("if [ -n \"$trust_sources\" ]; then\n")
+	b.WriteString("  echo 'Adding package repositories...'\n")
+	b.WriteString(indent(w.String(), "  "))
+	b.WriteString("else\n")
+	b.WriteString("  echo 'Not adding the repositories, run setup.sh --trust-sources to add them'\n")
+	b.WriteString("  install_failed 'package repositories, not trusted'\n")
+	b.WriteString("fi\n")
+	return b.String()
+}
+
+// trust_sources is set by --trust-sources or by answering the prompt on a terminal, a
+// script run without one does not add repositories
+const trustSourcesSetup = `trust_sources=''
+for arg in "$@"; do
+  [ "$arg" != --trust-sources ] || trust_sources=1
+done
+if [ -z "$trust_sources" ] && [ -t 0 ]; then
+  read -r -p 'Add these repositories and keys? [y/N] ' answer || answer=''
+  case "$answer" in
+    [yY]*) trust_sources=1 ;;
+  esac
+fi
+`
+
+// where apt keys are written, each one is only trusted for the sources naming it in signed-by
+const aptKeyringDir = "/etc/apt/keyrings/"
+
+// aptKeyrings moves the keys into aptKeyringDir and points the sources at their new paths.
+// Keys that were in trusted.gpg.d signed any source, they go into the signed-by of the
+// sources that name no key.
+func aptKeyrings(sources *utils.Sources) *utils.Sources {
+	moved := &utils.Sources{Manager: sources.Manager}
+	paths := make(map[string]string)
+	var legacy []string
+	for _, key := range sources.Keys {
+		if aptKeyPath(key.Path) {
+			path := aptKeyringDir + filepath.Base(key.Path)
+			paths[key.Path] = path
+			if filepath.Dir(key.Path) == "/etc/apt/trusted.gpg.d" {
+				legacy = append(legacy, path)
+			}
+			key.Path = path
+		}
+		moved.Keys = append(moved.Keys, key)
+	}
+	for _, repo := range sources.Repositories {
+		if strings.HasSuffix(repo.Path, ".sources") {
+			repo.Definition = deb822SignedBy(repo.Definition, paths, legacy)
+		} else {
+			repo.Definition = aptListSignedBy(repo.Definition, paths, legacy)
+		}
+		moved.Repositories = append(moved.Repositories, repo)
+	}
+	return moved
+}
+
+// aptKeyPath tells whether a key file is in one of the directories apt keys are kept in,
+// others are left where they are and rejected
+func aptKeyPath(path string) bool {
+	switch filepath.Dir(path) + "/" {
+	case aptKeyringDir, "/etc/apt/trusted.gpg.d/", "/usr/share/keyrings/":
+		return path == filepath.Clean(path)
+	}
+	return false
+}
+
+// aptListSignedBy renames the keys in the signed-by options of one-line sources, and adds
+// the legacy keys to lines without one
+func aptListSignedBy(definition string, paths map[string]string, legacy []string) string {
+	lines := strings.Split(definition, "\n")
+	for i, line := range lines {
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		if !strings.HasPrefix(fields[1], "[") {
+			if len(legacy) > 0 {
+				lines[i] = fields[0] + " [signed-by=" + strings.Join(legacy, ",") + "] " + strings.Join(fields[1:], " ")
+			}
+			continue
+		}
+		signed := false
+		for j := 1; j < len(fields); j++ {
+			option := strings.Trim(fields[j], "[]")
+			if keys, ok := strings.CutPrefix(option, "signed-by="); ok {
+				signed = true
+				renamed := strings.Split(keys, ",")
+				for k, key := range renamed {
+					if path, ok := paths[key]; ok {
+						renamed[k] = path
+					}
+				}
+				fields[j] = strings.Replace(fields[j], option, "signed-by="+strings.Join(renamed, ","), 1)
+			}
+			if strings.HasSuffix(fields[j], "]") {
+				if !signed && len(legacy) > 0 {
+					fields[j] = strings.TrimSuffix(fields[j], "]") + " signed-by=" + strings.Join(legacy, ",") + "]"
+				}
+				break
+			}
+		}
+		lines[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// deb822SignedBy renames the key files of Signed-By fields, and adds the legacy keys to
+// stanzas without one
+func deb822SignedBy(definition string, paths map[string]string, legacy []string) string {
+	stanzas := strings.Split(definition, "\n\n")
+	for i, stanza := range stanzas {
+		lines := strings.Split(strings.TrimRight(stanza, "\n"), "\n")
+		signed := false
+		for j, line := range lines {
+			value, ok := strings.CutPrefix(line, "Signed-By:")
+			if !ok {
+				continue
+			}
+			signed = true
+			keys := strings.Fields(value)
+			for k, key := range keys {
+				if path, ok := paths[key]; ok {
+					keys[k] = path
+				}
+			}
+			if len(keys) > 0 {
+				lines[j] = "Signed-By: " + strings.Join(keys, " ")
+			}
+		}
+		if !signed && len(legacy) > 0 && strings.TrimSpace(stanza) != "" {
+			lines = append(lines, "Signed-By: "+strings.Join(legacy, " "))
+		}
+		stanzas[i] = strings.Join(lines, "\n")
+	}
+	return strings.Join(stanzas, "\n\n") + "\n"
+}
+
+// writeRootFile writes or appends data to a root owned file, the data goes through
+// base64 so no content can end up interpreted by the shell.
+func writeRootFile(b *strings.Builder, path string, data []byte, appendData bool) {
+	tee := "sudo tee -a"
+	if !appendData {
+		fmt.Fprintf(b, "sudo mkdir -p %s && ", shellQuote(filepath.Dir(path)))
+		tee = "sudo tee"
+	}
+	fmt.Fprintf(b, "echo %s | base64 -d | %s %s >/dev/null\n",
+		shellQuote(base64.StdEncoding.EncodeToString(data)), tee, shellQuote(path))
+}
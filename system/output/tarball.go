@@ -4,16 +4,80 @@ import (
 	"archive/tar"
 	"compress/gzip"
 	"encoding/json"
+	"fmt"
+	"io"
 	"os"
 	"time"
 )
 
+//current backup format, manifest in backup.json and one tar entry per encrypted file
+const BackupVersion = 2
+
+//name of the manifest inside the tarball and of its detached signature
+const (
+	ManifestName  = "backup.json"
+	SignatureName = "backup.json.sig"
+)
+
+//detached signature over the exact bytes of backup.json, encoding/json output is
+//deterministic (struct field order, sorted map keys) so those bytes are the canonical form
+type ManifestSignature struct {
+	Algorithm string `json:"algorithm"`
+	PublicKey string `json:"public_key"`
+	Signature []byte `json:"signature"`
+}
+
 //backupData structure for tarball creation
 type BackupData struct {
-	Timestamp     time.Time                `json:"timestamp"`
+	Version       int                     `json:"version"`
+	Timestamp     time.Time               `json:"timestamp"`
 	SystemInfo    SystemInfo              `json:"system_info"`
+	Encryption    EncryptionInfo          `json:"encryption"`
 	EncryptedKeys map[string]EncryptedKey `json:"encrypted_keys"`
-	EncryptionKey []byte                  `json:"encryption_key"`
+	//incremental backups name the archive they were compared against and what disappeared since
+	Parent  string   `json:"parent,omitempty"`
+	Deleted []string `json:"deleted,omitempty"`
+	//portable gpg exports, imported on restore instead of being copied to a path
+	GPG *GPGExport `json:"gpg,omitempty"`
+	//only present in backups made before passphrase encryption, never written anymore
+	EncryptionKey []byte `json:"encryption_key,omitempty"`
+}
+
+//secret keys and ownertrust exported with gpg, stored as their own entries
+type GPGExport struct {
+	Home       string        `json:"home"`
+	SecretKeys *EncryptedKey `json:"secret_keys,omitempty"`
+	Ownertrust *EncryptedKey `json:"ownertrust,omitempty"`
+}
+
+//encryption modes recorded in EncryptionInfo
+const (
+	EncryptionModeLegacy     = ""
+	EncryptionModePassphrase = "passphrase"
+	EncryptionModeRecipients = "recipients"
+)
+
+//everything needed to re-derive or unwrap the data key, but never the key itself
+type EncryptionInfo struct {
+	Mode       string            `json:"mode"`
+	KDF        *KDFParams        `json:"kdf,omitempty"`
+	Verifier   string            `json:"verifier,omitempty"`
+	Recipients []RecipientStanza `json:"recipients,omitempty"`
+}
+
+type KDFParams struct {
+	Algorithm string `json:"algorithm"`
+	Salt      []byte `json:"salt"`
+	Time      uint32 `json:"time"`
+	Memory    uint32 `json:"memory_kib"`
+	Threads   uint8  `json:"threads"`
+}
+
+//the data key wrapped to a single X25519 recipient
+type RecipientStanza struct {
+	PublicKey    string `json:"public_key"`
+	EphemeralKey string `json:"ephemeral_key"`
+	WrappedKey   string `json:"wrapped_key"`
 }
 
 type SystemInfo struct {
@@ -23,16 +87,55 @@ type SystemInfo struct {
 }
 
 type EncryptedKey struct {
-	OriginalPath  string `json:"original_path"`
-	KeyType       string `json:"key_type"`
-	EncryptedData string `json:"encrypted_data"`
-	Permissions   uint32 `json:"permissions"`
+	OriginalPath string    `json:"original_path"`
+	KeyType      string    `json:"key_type"`
+	Entry        string    `json:"entry,omitempty"`  // tar entry holding the encrypted stream
+	Source       string    `json:"source,omitempty"` // archive holding Entry when it is not this one
+	Size         int64     `json:"size"`             // plaintext size
+	SHA256       string    `json:"sha256,omitempty"` // hex digest of the plaintext
+	ModTime      time.Time `json:"mod_time"`
+	Permissions  uint32    `json:"permissions"`
+	Info         *KeyInfo  `json:"info,omitempty"` // what the file turned out to contain
+	//inline base64 ciphertext of version 1 backups
+	EncryptedData string `json:"encrypted_data,omitempty"`
+}
+
+//kinds of files recorded in KeyInfo
+const (
+	KindPrivateKey     = "private-key"
+	KindPublicKey      = "public-key"
+	KindCertificate    = "certificate"
+	KindKnownHosts     = "known-hosts"
+	KindAuthorizedKeys = "authorized-keys"
+	KindSSHConfig      = "ssh-config"
+	KindRevocation     = "revocation"
+	KindUnknown        = "unknown"
+)
+
+//plaintext description of a backed up file, readable without decrypting anything
+type KeyInfo struct {
+	Kind        string `json:"kind"`
+	Format      string `json:"format,omitempty"` // openssh, pem, pkcs8, x509, openpgp
+	Algorithm   string `json:"algorithm,omitempty"`
+	Bits        int    `json:"bits,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"` // SHA256:base64 like ssh-keygen -l
+	Encrypted   bool   `json:"passphrase_protected,omitempty"`
+	Entries     int    `json:"entries,omitempty"` // keys in known_hosts or authorized_keys
 }
 
-//create a compressed tarball with the backup data
-func CreateBackupTarball(backupData *BackupData, tarballPath string) error {
-	//create tarball file
-	file, err := os.Create(tarballPath)
+//a file stored next to backup.json, streamed straight into the tarball
+type TarballEntry struct {
+	Name  string
+	Size  int64                  // exact number of bytes Write produces
+	Write func(io.Writer) error
+}
+
+//create a compressed tarball with every entry followed by the backup data manifest and its signature
+//entries are written first so their Write funcs can still fill in backupData
+func CreateBackupTarball(backupData *BackupData, entries []TarballEntry, tarballPath string,
+	sign func(manifest []byte) (*ManifestSignature, error)) error {
+	//create tarball file, only ciphertext goes in but there is no reason to share it
+	file, err := os.OpenFile(tarballPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
 	if err != nil {
 		return err
 	}
@@ -40,11 +143,24 @@ func CreateBackupTarball(backupData *BackupData, tarballPath string) error {
 
 	//gzip writer
 	gzipWriter := gzip.NewWriter(file)
-	defer gzipWriter.Close()
 
 	//tar writer
 	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
+
+	for _, entry := range entries {
+		header := &tar.Header{
+			Name:    entry.Name,
+			Mode:    0600,
+			Size:    entry.Size,
+			ModTime: backupData.Timestamp,
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			return err
+		}
+		if err := entry.Write(tarWriter); err != nil {
+			return fmt.Errorf("failed to write %s: %w", entry.Name, err)
+		}
+	}
 
 	//convertto JSON
 	jsonData, err := json.MarshalIndent(backupData, "", "  ")
@@ -54,9 +170,10 @@ func CreateBackupTarball(backupData *BackupData, tarballPath string) error {
 
 	//add JSON file to tarball
 	header := &tar.Header{
-		Name: "backup.json",
-		Mode: 0644,
-		Size: int64(len(jsonData)),
+		Name:    ManifestName,
+		Mode:    0644,
+		Size:    int64(len(jsonData)),
+		ModTime: backupData.Timestamp,
 	}
 
 	if err := tarWriter.WriteHeader(header); err != nil {
@@ -67,5 +184,131 @@ func CreateBackupTarball(backupData *BackupData, tarballPath string) error {
 		return err
 	}
 
-	return nil
+	signature, err := sign(jsonData)
+	if err != nil {
+		return fmt.Errorf("failed to sign manifest: %w", err)
+	}
+	signatureData, err := json.MarshalIndent(signature, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	header = &tar.Header{
+		Name:    SignatureName,
+		Mode:    0644,
+		Size:    int64(len(signatureData)),
+		ModTime: backupData.Timestamp,
+	}
+	if err := tarWriter.WriteHeader(header); err != nil {
+		return err
+	}
+	if _, err := tarWriter.Write(signatureData); err != nil {
+		return err
+	}
+
+	//close explicitly, a failed flush means a truncated backup
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+//read the backup data back from a tarball created by CreateBackupTarball
+func ReadBackupTarball(tarballPath string) (*BackupData, error) {
+	backupData, _, _, err := ReadSignedBackup(tarballPath)
+	return backupData, err
+}
+
+//read the backup data together with the raw manifest bytes and its signature,
+//the signature is nil for unsigned archives
+func ReadSignedBackup(tarballPath string) (*BackupData, []byte, *ManifestSignature, error) {
+	file, err := os.Open(tarballPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	defer gzipReader.Close()
+
+	var manifest []byte
+	var signature *ManifestSignature
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to read tarball: %w", err)
+		}
+
+		switch header.Name {
+		case ManifestName:
+			if manifest != nil {
+				return nil, nil, nil, fmt.Errorf("more than one %s in %s", ManifestName, tarballPath)
+			}
+			manifest, err = io.ReadAll(tarReader)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to read %s: %w", ManifestName, err)
+			}
+		case SignatureName:
+			signature = &ManifestSignature{}
+			if err := json.NewDecoder(tarReader).Decode(signature); err != nil {
+				return nil, nil, nil, fmt.Errorf("failed to parse %s: %w", SignatureName, err)
+			}
+		}
+	}
+
+	if manifest == nil {
+		return nil, nil, nil, fmt.Errorf("%s not found in %s", ManifestName, tarballPath)
+	}
+
+	var backupData BackupData
+	if err := json.Unmarshal(manifest, &backupData); err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to parse %s: %w", ManifestName, err)
+	}
+	return &backupData, manifest, signature, nil
+}
+
+//call fn with the contents of every entry except the manifest, in archive order
+func WalkBackupEntries(tarballPath string, fn func(name string, r io.Reader) error) error {
+	file, err := os.Open(tarballPath)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	gzipReader, err := gzip.NewReader(file)
+	if err != nil {
+		return fmt.Errorf("failed to open gzip stream: %w", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			//tar stops at its end marker, read the rest so gzip checks its CRC
+			if _, err := io.Copy(io.Discard, gzipReader); err != nil {
+				return fmt.Errorf("corrupt gzip stream: %w", err)
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tarball: %w", err)
+		}
+		if header.Name == ManifestName || header.Name == SignatureName || header.Typeflag != tar.TypeReg {
+			continue
+		}
+		if err := fn(header.Name, tarReader); err != nil {
+			return err
+		}
+	}
 }
@@ -0,0 +1,199 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// toolchainPackages names the OS package providing each ecosystem's command per family.
+var toolchainPackages = map[string]map[string]string{
+	"pipx": {"debian": "pipx", "arch": "python-pipx", "fedora": "pipx", "void": "python3-pipx",
+		"suse": "python3-pipx", "alpine": "pipx", "gentoo": "dev-python/pipx", "nixos": "pipx"},
+	"pip": {"debian": "python3-pip", "arch": "python-pip", "fedora": "python3-pip", "void": "python3-pip",
+		"suse": "python3-pip", "alpine": "py3-pip", "gentoo": "dev-python/pip", "nixos": "python3Packages.pip"},
+	"npm": {"debian": "npm", "arch": "npm", "fedora": "npm", "void": "nodejs",
+		"suse": "npm-default", "alpine": "npm", "gentoo": "net-libs/nodejs", "nixos": "nodejs"},
+	"cargo": {"debian": "cargo", "arch": "rust", "fedora": "cargo", "void": "cargo",
+		"suse": "cargo", "alpine": "cargo", "gentoo": "virtual/rust", "nixos": "cargo"},
+	"go": {"debian": "golang-go", "arch": "go", "fedora": "golang", "void": "go",
+		"suse": "go", "alpine": "go", "gentoo": "dev-lang/go", "nixos": "go"},
+	"gem": {"debian": "ruby", "arch": "ruby", "fedora": "ruby", "void": "ruby",
+		"suse": "ruby", "alpine": "ruby", "gentoo": "dev-lang/ruby", "nixos": "ruby"},
+}
+
+// pipExternallyManaged succeeds when the distro marks its python as externally managed
+const pipExternallyManaged = `[ -e "$(python3 -c 'import sysconfig; print(sysconfig.get_path("stdlib"))')/EXTERNALLY-MANAGED" ]`
+
+// toolchainChecks tell whether a toolchain works when its command alone does not, the pip
+// module is packaged apart from python3
+var toolchainChecks = map[string]string{
+	"pip": "python3 -m pip --version >/dev/null 2>&1",
+}
+
+// toolsSection installs the global tools of every ecosystem, after the OS packages that
+// provide their toolchains. Tools are installed at the recorded version, those without one
+// at the latest. The tools of an ecosystem whose toolchain cannot be installed are skipped,
+// and pip tools are logged as failed when the distro manages its python.
+func toolsSection(manager utils.PackageManager, tools *utils.Tools) string {
+	if tools == nil || tools.Count() == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("echo 'Installing global language tools...'\n")
+	var s strings.Builder
+
+	if len(tools.Pipx) > 0 {
+		s.Reset()
+		for _, tool := range tools.Pipx {
+			if !validTool(tool) {
+				b.WriteString(rejected("pipx tool %q", tool.Name))
+				continue
+			}
+			spec := versioned(tool.Name, "==", tool.Version)
+			if tool.Source != "" {
+				spec = tool.Source
+			}
+			s.WriteString(installStep("pipx "+tool.Name, "pipx install "+shellQuote(spec)))
+		}
+		writeToolchainSection(&b, manager, "pipx", s.String())
+	}
+
+	if len(tools.Pip) > 0 {
+		var installs, refused strings.Builder
+		for _, tool := range tools.Pip {
+			if !validTool(tool) {
+				b.WriteString(rejected("pip tool %q", tool.Name))
+				continue
+			}
+			spec := shellQuote(versioned(tool.Name, "==", tool.Version))
+			installs.WriteString(installStep("pip "+tool.Name, "python3 -m pip install --user "+spec))
+			fmt.Fprintf(&refused, "echo %s\ninstall_failed %s\n", spec, shellQuote("pip "+tool.Name+", python is externally managed"))
+		}
+		s.Reset()
+		if installs.Len() > 0 {
+			//pip refuses --user installs into a python the distro manages (PEP 668)
+			fmt.Fprintf(&s, "if %s; then\n", pipExternallyManaged)
+			s.WriteString("  echo 'Python is externally managed, pip will not install into it. Install these with pipx or as distro packages:'\n")
+			s.WriteString(indent(refused.String(), "  "))
+			s.WriteString("else\n")
+			s.WriteString(indent(installs.String(), "  "))
+			s.WriteString("fi\n")
+		}
+		writeToolchainSection(&b, manager, "pip", s.String())
+	}
+
+	if len(tools.Npm) > 0 {
+		s.Reset()
+		//distro node keeps global packages in a root owned prefix
+		s.WriteString("npm_sudo=''\n[ -w \"$(npm root -g)\" ] || npm_sudo=sudo\n")
+		for _, tool := range tools.Npm {
+			if !validTool(tool) {
+				b.WriteString(rejected("npm tool %q", tool.Name))
+				continue
+			}
+			switch {
+			case strings.HasPrefix(tool.Source, "file:"):
+				fmt.Fprintf(&s, "echo %s\n", shellQuote("Skipping npm package "+tool.Name+" installed from "+tool.Source))
+			case tool.Source != "":
+				s.WriteString(installStep("npm "+tool.Name, "$npm_sudo npm install -g "+shellQuote(tool.Source)))
+			default:
+				s.WriteString(installStep("npm "+tool.Name, "$npm_sudo npm install -g "+shellQuote(versioned(tool.Name, "@", tool.Version))))
+			}
+		}
+		writeToolchainSection(&b, manager, "npm", s.String())
+	}
+
+	if len(tools.Cargo) > 0 {
+		s.Reset()
+		for _, tool := range tools.Cargo {
+			if !validTool(tool) {
+				b.WriteString(rejected("cargo tool %q", tool.Name))
+				continue
+			}
+			s.WriteString(cargoInstall(tool))
+		}
+		writeToolchainSection(&b, manager, "cargo", s.String())
+	}
+
+	if len(tools.Go) > 0 {
+		s.Reset()
+		for _, tool := range tools.Go {
+			if !validTool(tool) {
+				b.WriteString(rejected("go tool %q", tool.Name))
+				continue
+			}
+			//go install needs a version, modules without one install the latest
+			version := tool.Version
+			if version == "" {
+				version = "latest"
+			}
+			s.WriteString(installStep("go "+tool.Name, "go install "+shellQuote(tool.Source+"@"+version)))
+		}
+		writeToolchainSection(&b, manager, "go", s.String())
+	}
+
+	if len(tools.Gem) > 0 {
+		s.Reset()
+		s.WriteString("gem_sudo=''\n[ -w \"$(gem environment gemdir)\" ] || gem_sudo=sudo\n")
+		for _, tool := range tools.Gem {
+			if !validTool(tool) {
+				b.WriteString(rejected("gem tool %q", tool.Name))
+				continue
+			}
+			command := "$gem_sudo gem install " + shellQuote(tool.Name)
+			if tool.Version != "" {
+				command += " -v " + shellQuote(tool.Version)
+			}
+			s.WriteString(installStep("gem "+tool.Name, command))
+		}
+		writeToolchainSection(&b, manager, "gem", s.String())
+	}
+	return b.String()
+}
+
+// cargoInstall installs a crate from crates.io or the git revision it was built from,
+// crates built from a local path are only reported.
+func cargoInstall(tool utils.Tool) string {
+	switch {
+	case tool.Source == "" && tool.Version == "":
+		return installStep("cargo "+tool.Name, "cargo install "+shellQuote(tool.Name))
+	case tool.Source == "":
+		return installStep("cargo "+tool.Name, fmt.Sprintf("cargo install %s --version %s", shellQuote(tool.Name), shellQuote(tool.Version)))
+	case strings.HasPrefix(tool.Source, "/"):
+		return fmt.Sprintf("echo %s\n", shellQuote("Skipping crate "+tool.Name+" built from "+tool.Source))
+	}
+	url, rev, _ := strings.Cut(strings.TrimPrefix(tool.Source, "git+"), "#")
+	if !utils.SafeArgument(url) || (rev != "" && !utils.SafeArgument(rev)) {
+		return rejected("cargo tool %q source %q", tool.Name, tool.Source)
+	}
+	if rev == "" {
+		return installStep("cargo "+tool.Name, fmt.Sprintf("cargo install %s --git %s", shellQuote(tool.Name), shellQuote(url)))
+	}
+	return installStep("cargo "+tool.Name, fmt.Sprintf("cargo install %s --git %s --rev %s", shellQuote(tool.Name), shellQuote(url), shellQuote(rev)))
+}
+
+// versioned appends the version to a name when there is one
+func versioned(name, separator, version string) string {
+	if version == "" {
+		return name
+	}
+	return name + separator + version
+}
+
+// writeToolchainSection installs an ecosystem's tools once its command is there, installing
+// the package providing it when it is missing
+func writeToolchainSection(b *strings.Builder, manager utils.PackageManager, command, installs string) {
+	if manager == nil {
+		writeRequiring(b, command, nil, command, installs)
+		return
+	}
+	pkg, ok := toolchainPackages[command][manager.Families()[0]]
+	if !ok {
+		writeRequiring(b, command, nil, command, installs)
+		return
+	}
+	writeRequiring(b, command, manager, pkg, installs)
+}
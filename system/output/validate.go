@@ -0,0 +1,107 @@
+package output
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// A snapshot may come from another machine or user, so nothing in it is trusted. Every
+// value is quoted where it reaches the script, and values that would still change what a
+// command does, like names starting with "-", are left out and reported as failures.
+
+// rejected reports a snapshot entry that was left out of the script
+func rejected(format string, args ...any) string {
+	return fmt.Sprintf("install_failed %s\n", shellQuote("rejected "+fmt.Sprintf(format, args...)))
+}
+
+// validPackages splits off the packages whose name or version the manager would not accept
+// as a single package argument.
+func validPackages(manager utils.PackageManager, packages []utils.Package) ([]utils.Package, string) {
+	var valid []utils.Package
+	var b strings.Builder
+	for _, pkg := range packages {
+		if err := utils.ValidatePackage(manager, pkg); err != nil {
+			b.WriteString(rejected("package: %v", err))
+			continue
+		}
+		valid = append(valid, pkg)
+	}
+	return valid, b.String()
+}
+
+// validTool tells whether a tool's name, version and source are each one operand
+func validTool(tool utils.Tool) bool {
+	return utils.SafeArgument(tool.Name) &&
+		(tool.Version == "" || utils.SafeArgument(tool.Version)) &&
+		(tool.Source == "" || utils.SafeArgument(tool.Source))
+}
+
+// validFlatpakScope tells whether a scope names a flatpak installation
+func validFlatpakScope(scope string) bool {
+	return scope == "user" || scope == "system"
+}
+
+// where each manager's repository definitions and signing keys may be written, directories
+// end in a slash. A snapshot cannot make setup.sh write anywhere else.
+var sourcePaths = map[string][]string{
+	"apt":    {"/etc/apt/sources.list.d/", aptKeyringDir},
+	"pacman": {"/etc/pacman.conf", "/etc/pacman.d/"},
+	"dnf":    {"/etc/yum.repos.d/", "/etc/pki/rpm-gpg/"},
+	"zypper": {"/etc/zypp/repos.d/", "/etc/pki/rpm-gpg/"},
+	"xbps":   {"/etc/xbps.d/", "/var/db/xbps/keys/"},
+	"apk":    {"/etc/apk/repositories", "/etc/apk/keys/"},
+}
+
+// validSourcePath tells whether a repository or key file belongs to the manager's
+// configuration
+func validSourcePath(manager, path string) bool {
+	if path != filepath.Clean(path) || !filepath.IsAbs(path) {
+		return false
+	}
+	for _, allowed := range sourcePaths[manager] {
+		if path == allowed || filepath.Dir(path)+"/" == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+var keyFingerprint = regexp.MustCompile(`^[0-9A-Fa-f]{16,40}$`)
+
+// a USE flag, "-flag" disables it and "-*" all of them
+var useFlag = regexp.MustCompile(`^(-?[A-Za-z0-9][A-Za-z0-9+_@.-]*|-\*)$`)
+
+// a package.use token, either an atom like >=dev-lang/python-3.12:3.12 or a flag, or a
+// USE_EXPAND group like PYTHON_TARGETS:
+var packageUseToken = regexp.MustCompile(`^[A-Za-z0-9<>=~*/._+:@-]+$`)
+
+// validUseFlags keeps the flags of a USE value that are safe to write into make.conf,
+// which shell scripts source.
+func validUseFlags(use string) (valid []string, invalid []string) {
+	for _, flag := range strings.Fields(use) {
+		if useFlag.MatchString(flag) {
+			valid = append(valid, flag)
+		} else {
+			invalid = append(invalid, flag)
+		}
+	}
+	return
+}
+
+// validPackageUse tells whether a package.use line holds an atom and flags only
+func validPackageUse(line string) bool {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.ContainsAny(line, "\r\n") {
+		return false
+	}
+	for _, field := range fields {
+		if !packageUseToken.MatchString(field) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,110 @@
+package output
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// VersionPolicy decides which versions setup.sh installs.
+type VersionPolicy string
+
+const (
+	// PolicyLatest installs whatever version the repositories offer.
+	PolicyLatest VersionPolicy = "latest"
+	// PolicyExact installs the recorded versions, packages whose version is gone are reported.
+	PolicyExact VersionPolicy = "exact"
+	// PolicyMinimum installs the latest versions, packages left older than recorded are
+	// reported.
+	PolicyMinimum VersionPolicy = "minimum"
+)
+
+// VersionPolicies lists the policies in the order they are offered.
+var VersionPolicies = []VersionPolicy{PolicyLatest, PolicyExact, PolicyMinimum}
+
+// ScriptOptions change how setup.sh is generated.
+type ScriptOptions struct {
+	Policy VersionPolicy
+}
+
+// ParseVersionPolicy returns the policy of that name.
+func ParseVersionPolicy(name string) (VersionPolicy, error) {
+	for _, policy := range VersionPolicies {
+		if string(policy) == strings.ToLower(strings.TrimSpace(name)) {
+			return policy, nil
+		}
+	}
+	return "", fmt.Errorf("unknown version policy %q", name)
+}
+
+// the script appends packages it could not install at the recorded version here
+const versionReportSetup = "version_report=\"$(pwd)/version-report.txt\"\n: > \"$version_report\"\n"
+
+const versionReportSummary = "if [ -s \"$version_report\" ]; then\n  echo \"Some packages are not at their recorded version, see $version_report\"\nfi\n"
+
+// checksVersions tells whether the policy compares installed versions with recorded ones
+func checksVersions(policy VersionPolicy) bool {
+	return policy == PolicyExact || policy == PolicyMinimum
+}
+
+// batchCommand renders the command installing a batch of packages under the version policy,
+// only the exact policy pins versions.
+func batchCommand(manager utils.PackageManager, batch []utils.Package, policy VersionPolicy) string {
+	if pinner, ok := manager.(utils.VersionPinner); ok && policy == PolicyExact {
+		return pinner.PinnedInstallCommand(batch...)
+	}
+	return manager.InstallCommand(batch...)
+}
+
+// versionNotes reports the packages of a batch whose exact version the manager cannot pin,
+// they are installed at the latest version.
+func versionNotes(manager utils.PackageManager, batch []utils.Package, policy VersionPolicy) string {
+	if _, ok := manager.(utils.VersionPinner); ok || policy != PolicyExact {
+		return ""
+	}
+	var b strings.Builder
+	for _, pkg := range batch {
+		// pacman and nix only offer the current version of each package
+		if pkg.Version != "" {
+			b.WriteString(reportLine(fmt.Sprintf("%s: %s cannot install version %s, installing the latest", pkg.Name, manager.Name(), pkg.Version)))
+		}
+	}
+	return b.String()
+}
+
+// packageCommand renders the command installing one package under the version policy, it
+// fails when the package is not installed. Packages without a recorded version, e.g.
+// translated from another family, install the latest.
+func packageCommand(manager utils.PackageManager, pkg utils.Package, policy VersionPolicy) string {
+	latest := manager.InstallCommand(pkg)
+	pinner, ok := manager.(utils.VersionPinner)
+	if !ok || policy != PolicyExact || pkg.Version == "" {
+		return latest
+	}
+	return fmt.Sprintf("%s || { %s; false; }", pinner.PinnedInstallCommand(pkg),
+		reportCommand(fmt.Sprintf("%s: version %s unavailable, not installed", pkg.Name, pkg.Version)))
+}
+
+// installedArgs renders the arguments all_installed and report_older take for a batch, the
+// names alone unless the policy checks versions
+func installedArgs(manager utils.PackageManager, batch []utils.Package, policy VersionPolicy) string {
+	_, compares := manager.(utils.VersionComparer)
+	args := make([]string, 0, 2*len(batch))
+	for _, pkg := range batch {
+		args = append(args, shellQuote(pkg.Name))
+		if compares && checksVersions(policy) {
+			args = append(args, shellQuote(pkg.Version))
+		}
+	}
+	return strings.Join(args, " ")
+}
+
+// reportLine appends a message to the version report
+func reportLine(message string) string {
+	return reportCommand(message) + "\n"
+}
+
+func reportCommand(message string) string {
+	return fmt.Sprintf("echo %s >> \"$version_report\"", shellQuote(message))
+}
@@ -0,0 +1,121 @@
+package output
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// dpkg stubs reporting every package installed at version 2.0, sudo logs what it would run
+var versionStubs = map[string]string{
+	"sudo": sudoStub,
+	"dpkg-query": `case "$2" in
+  *Status*) printf 'install ok installed' ;;
+  *) printf '2.0\n' ;;
+esac`,
+	"dpkg": `[ "$2" != "$4" ] && [ "$(printf '%s\n%s\n' "$2" "$4" | sort -V | head -n 1)" = "$2" ]`,
+}
+
+const sudoStub = `printf '%s\n' "$*" >> "$SUDO_LOG"`
+
+// rpm stubs reporting every package installed at version 2.0, --eval runs the vercmp
+// macro on the versions it is given
+var rpmStubs = map[string]string{
+	"sudo": sudoStub,
+	"rpm": `case "$1" in
+  -q) [ "$2" = --queryformat ] && printf '0:2.0\n'; true ;;
+  --eval)
+    case "$2" in *'rpm.vercmp(os.getenv("older"), os.getenv("newer"))'*) ;; *) exit 1 ;; esac
+    if [ "$older" = "$newer" ]; then echo 0
+    elif [ "$(printf '%s\n%s\n' "$older" "$newer" | sort -V | head -n 1)" = "$older" ]; then echo -1
+    else echo 1; fi ;;
+esac`,
+}
+
+// runVersionScript runs setup.sh generated for the packages against the stubs and returns
+// what sudo ran and the version report
+func runVersionScript(t *testing.T, family string, stubbed map[string]string, packages []utils.Package, policy VersionPolicy) (string, string) {
+	t.Helper()
+	bash, err := exec.LookPath("bash")
+	if err != nil {
+		t.Skip("bash is not installed")
+	}
+	dir := t.TempDir()
+	stubs := filepath.Join(dir, "bin")
+	if err := os.Mkdir(stubs, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, body := range stubbed {
+		if err := os.WriteFile(filepath.Join(stubs, name), []byte("#!/bin/sh\n"+body+"\n"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	script := filepath.Join(dir, "setup.sh")
+	if err := GenerateInstallScript(family, &SystemSnapshot{Packages: packages}, ScriptOptions{Policy: policy}, script); err != nil {
+		t.Fatal(err)
+	}
+	sudoLog := filepath.Join(dir, "sudo.log")
+	cmd := exec.Command(bash, script)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "PATH="+stubs+":/usr/bin:/bin", "SUDO_LOG="+sudoLog)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("%s %s: %v\n%s", family, policy, err, out)
+	}
+	ran, _ := os.ReadFile(sudoLog)
+	report, _ := os.ReadFile(filepath.Join(dir, "version-report.txt"))
+	return string(ran), string(report)
+}
+
+func TestMinimumPolicyInstallsLatest(t *testing.T) {
+	packages := []utils.Package{{Name: "older", Version: "1.0"}, {Name: "same", Version: "2.0"}, {Name: "newer", Version: "3.0"}}
+	ran, report := runVersionScript(t, "debian", versionStubs, packages, PolicyMinimum)
+	if ran != "apt-get install -y older same newer\n" {
+		t.Errorf("ran %q, want the latest of every package", ran)
+	}
+	// only the package still below its recorded version is reported
+	if report != "newer: installed 2.0, older than the recorded 3.0\n" {
+		t.Errorf("version report %q", report)
+	}
+
+	// installed versions at or above the recorded ones satisfy the policy
+	ran, report = runVersionScript(t, "debian", versionStubs, packages[:2], PolicyMinimum)
+	if ran != "" || report != "" {
+		t.Errorf("installed packages were installed again: ran %q, reported %q", ran, report)
+	}
+}
+
+func TestExactPolicyComparesInstalledVersions(t *testing.T) {
+	ran, _ := runVersionScript(t, "debian", versionStubs, []utils.Package{{Name: "same", Version: "2.0"}}, PolicyExact)
+	if ran != "" {
+		t.Errorf("ran %q for a package installed at its version", ran)
+	}
+
+	// a package installed at another version is not skipped, newer ones neither
+	for _, version := range []string{"1.0", "3.0"} {
+		ran, _ = runVersionScript(t, "debian", versionStubs, []utils.Package{{Name: "same", Version: "2.0"}, {Name: "other", Version: version}}, PolicyExact)
+		if want := "apt-get install -y --allow-downgrades same=2.0 other=" + version + "\n"; ran != want {
+			t.Errorf("ran %q, want %q", ran, want)
+		}
+	}
+}
+
+func TestUnpinnedManagersUnderMinimum(t *testing.T) {
+	packages := []utils.Package{{Name: "git", Version: "2.44.0-1"}}
+	for _, family := range []string{"arch", "nixos"} {
+		section := nativePackagesSection(utils.ManagerFor(family), packages, PolicyMinimum)
+		if strings.Contains(section, "cannot install version") {
+			t.Errorf("%s: the latest is reported as a fallback under minimum:\n%s", family, section)
+		}
+		if !strings.Contains(section, "report_older 'git' '2.44.0-1'") {
+			t.Errorf("%s: older versions are not reported:\n%s", family, section)
+		}
+		if !strings.Contains(nativePackagesSection(utils.ManagerFor(family), packages, PolicyExact), "cannot install version") {
+			t.Errorf("%s: the exact policy does not report the unpinned version", family)
+		}
+	}
+}
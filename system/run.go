@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"runtime"
+	"github.com/mdgspace/sysreplicate/system/mapping"
 	"github.com/mdgspace/sysreplicate/system/output"
 	"github.com/mdgspace/sysreplicate/system/utils"
 )
@@ -14,7 +15,6 @@ import (
 // Run is the entry point for the system orchestrator.
 func Run() {
 	osType := runtime.GOOS
-	fmt.Println("Detected OS Type:", osType)
 
     switch osType {
     case "darwin":
@@ -24,6 +24,10 @@ func Run() {
         fmt.Println("Windows is not supported")
         return
     case "linux":
+        if len(os.Args) > 1 {
+            os.Exit(runCommand(os.Args[1:])) //non-interactive commands, stdout is left to their output
+        }
+        fmt.Println("Detected OS Type:", osType)
         showMenu() ////main menu component
     default:
         fmt.Println("OS not supported")
@@ -39,8 +43,11 @@ func showMenu() {
         fmt.Println("\n=== SysReplicate - Distro Hopping Tool ===")
         fmt.Println("1. Generate package replication files")
         fmt.Println("2. Backup SSH/GPG keys")
-        fmt.Println("3. Exit")
-        fmt.Print("Choose an option (1-3): ")
+        fmt.Println("3. Restore SSH/GPG keys")
+        fmt.Println("4. Verify a key backup")
+        fmt.Println("5. Compare the system with a package snapshot")
+        fmt.Println("6. Exit")
+        fmt.Print("Choose an option (1-6): ")
         
         if !scanner.Scan() {
             break
@@ -54,10 +61,16 @@ func showMenu() {
         case "2":
             RunBackup()
         case "3":
+            RunRestore()
+        case "4":
+            RunVerify()
+        case "5":
+            runCompare()
+        case "6":
             fmt.Println() //exit
             return
         default:
-            fmt.Println("Invalid choice. Please select 1, 2, or 3.")
+            fmt.Println("Invalid choice. Please select 1-6.")
         }
     }
 }
@@ -73,8 +86,10 @@ func runPackageReplication() {
     fmt.Println("Distribution:", distro)
     fmt.Println("Built On:", baseDistro)
     
-    packages := utils.FetchPackages(baseDistro)
-    jsonObj, err := output.BuildSystemJSON("linux", distro, baseDistro, packages)
+    //dependencies are resolved again on the new system, pinning them only gets in the way
+    explicitOnly := confirm("Capture only explicitly installed packages?")
+    snapshot := collectSnapshot(distro, baseDistro, explicitOnly)
+    jsonObj, err := output.BuildSystemJSON(snapshot)
     if err != nil {
         log.Println("Error marshalling JSON:", err)
         return
@@ -95,9 +110,228 @@ func runPackageReplication() {
         return
     }
 
-    if err := output.GenerateInstallScript(baseDistro, packages, scriptOutputPath); err != nil {
+    //the script may target another distro family than the one just scanned
+    target := readTargetFamily(mapping.Family(baseDistro))
+    scriptSnapshot := *snapshot
+    if target != mapping.Family(baseDistro) {
+        translated, err := translatePackages(snapshot.Packages, baseDistro, target)
+        if err != nil {
+            log.Println("Error translating package names:", err)
+            return
+        }
+        scriptSnapshot.Packages = translated
+        //repository definitions only work on the family they were written for
+        if snapshot.Sources.Count() > 0 {
+            fmt.Printf("Leaving out %d %s repositories, add the %s equivalents yourself\n", snapshot.Sources.Count(), snapshot.Sources.Manager, target)
+            scriptSnapshot.Sources = nil
+        }
+    }
+
+    //names only this system's repositories can vouch for, another family is not queried
+    if manager := utils.ManagerFor(target); manager != nil && manager.Detect() {
+        scriptSnapshot.Packages = availablePackages(manager, scriptSnapshot.Packages)
+    }
+
+    options := output.ScriptOptions{Policy: readVersionPolicy()}
+    if err := output.GenerateInstallScript(target, &scriptSnapshot, options, scriptOutputPath); err != nil {
         log.Println("Error generating install script:", err)
     } else {
         fmt.Println("Script generated successfully at:", scriptOutputPath)
+        if scriptSnapshot.Sources.Count() > 0 {
+            fmt.Printf("It lists %d repositories and their keys and asks before adding them, run it with --trust-sources to add them without asking\n", scriptSnapshot.Sources.Count())
+        }
+    }
+}
+
+//runCompare reports how this system differs from a package.json, by default the one generated last
+func runCompare() {
+    fmt.Printf("Snapshot to compare with [%s]: ", jsonOutputPath)
+    scanner := bufio.NewScanner(os.Stdin)
+    path := jsonOutputPath
+    if scanner.Scan() && strings.TrimSpace(scanner.Text()) != "" {
+        path = strings.TrimSpace(scanner.Text())
+    }
+
+    diff, err := compareWithSnapshot(path)
+    if err != nil {
+        log.Println("Error comparing with the snapshot:", err)
+        return
+    }
+    diff.WriteText(os.Stdout)
+}
+
+//compareWithSnapshot collects the installed packages and compares them with a saved snapshot
+//a snapshot of another distro family is translated first, versions are then not compared
+func compareWithSnapshot(path string) (*output.SnapshotDiff, error) {
+    saved, err := output.LoadSnapshot(path)
+    if err != nil {
+        return nil, err
+    }
+    _, baseDistro := utils.DetectDistro()
+    manager := utils.ManagerFor(baseDistro)
+    if manager == nil {
+        return nil, fmt.Errorf("cannot identify the package manager of this system")
+    }
+
+    if mapping.Family(saved.BaseDistro) != mapping.Family(baseDistro) {
+        dataset, err := mapping.Load()
+        if err != nil {
+            return nil, err
+        }
+        result, err := dataset.Translate(saved.Packages, saved.BaseDistro, baseDistro)
+        if err != nil {
+            return nil, err
+        }
+        fmt.Fprintf(os.Stderr, "Translated the %s snapshot to %s names, %d packages have no equivalent here\n",
+            saved.BaseDistro, baseDistro, len(result.Missing))
+        saved.Packages = result.Packages
+    }
+
+    //every installed package, a package saved as explicit may now be a dependency
+    //a failed query would report every saved package as missing, so it is an error here
+    installed, err := manager.ListInstalled()
+    if err != nil {
+        return nil, fmt.Errorf("could not collect the installed packages: %w", err)
+    }
+    live := &output.SystemSnapshot{Packages: installed}
+    return output.CompareSnapshots(saved, live), nil
+}
+
+//collectSnapshot gathers native packages and their repositories, global language tools and the Flatpak and Snap applications
+func collectSnapshot(distro, baseDistro string, explicitOnly bool) *output.SystemSnapshot {
+    snapshot := &output.SystemSnapshot{
+        OS:         "linux",
+        Distro:     distro,
+        BaseDistro: baseDistro,
+        Packages:   utils.FetchPackages(baseDistro, explicitOnly),
+    }
+    snapshot.Sources = utils.FetchSources(baseDistro, snapshot.Packages)
+    if baseDistro == "arch" {
+        snapshot.AURHelper = utils.DetectAURHelper()
+        if snapshot.AURHelper != "" {
+            fmt.Println("AUR helper:", snapshot.AURHelper)
+        }
+    }
+    if baseDistro == "gentoo" {
+        portage, err := utils.FetchPortageConfig()
+        if err != nil {
+            log.Println("Error in retrieving USE flags:", err)
+        }
+        snapshot.Portage = portage
     }
-}
\ No newline at end of file
+
+    flatpaks, remotes, err := utils.FetchFlatpaks()
+    if err != nil {
+        log.Println("Error in retrieving Flatpak applications:", err)
+    }
+    snapshot.Flatpaks, snapshot.FlatpakRemotes = flatpaks, remotes
+
+    snapshot.Snaps, err = utils.FetchSnaps()
+    if err != nil {
+        log.Println("Error in retrieving snaps:", err)
+    }
+
+    tools := utils.FetchTools()
+    if tools.Count() > 0 {
+        snapshot.Tools = tools
+    }
+
+    fmt.Printf("Found %d packages from %d third-party repositories, %d language tools, %d Flatpak applications and %d snaps\n",
+        len(snapshot.Packages), snapshot.Sources.Count(), tools.Count(), len(snapshot.Flatpaks), len(snapshot.Snaps))
+    return snapshot
+}
+
+//confirm asks a yes/no question, defaulting to no
+func confirm(question string) bool {
+    fmt.Printf("%s (y/N): ", question)
+    scanner := bufio.NewScanner(os.Stdin)
+    if !scanner.Scan() {
+        return false
+    }
+    answer := strings.ToLower(strings.TrimSpace(scanner.Text()))
+    return answer == "y" || answer == "yes"
+}
+
+//readTargetFamily asks which distro family setup.sh is for, defaulting to the source
+func readTargetFamily(source string) string {
+    fmt.Printf("Generate setup.sh for (%s) [%s]: ", strings.Join(mapping.Families, ", "), source)
+    scanner := bufio.NewScanner(os.Stdin)
+    if !scanner.Scan() {
+        return source
+    }
+    target := strings.TrimSpace(scanner.Text())
+    if target == "" {
+        return source
+    }
+    return mapping.Family(target)
+}
+
+//readVersionPolicy asks which versions setup.sh installs, defaulting to the latest
+func readVersionPolicy() output.VersionPolicy {
+    names := make([]string, len(output.VersionPolicies))
+    for i, policy := range output.VersionPolicies {
+        names[i] = string(policy)
+    }
+    scanner := bufio.NewScanner(os.Stdin)
+    for {
+        fmt.Printf("Install which versions (%s) [%s]: ", strings.Join(names, ", "), output.PolicyLatest)
+        if !scanner.Scan() {
+            return output.PolicyLatest
+        }
+        answer := strings.TrimSpace(scanner.Text())
+        if answer == "" {
+            return output.PolicyLatest
+        }
+        policy, err := output.ParseVersionPolicy(answer)
+        if err == nil {
+            return policy
+        }
+        fmt.Println(err)
+    }
+}
+
+//translatePackages renames packages for the target family and writes a report of the rest
+func translatePackages(packages []utils.Package, source, target string) ([]utils.Package, error) {
+    dataset, err := mapping.Load()
+    if err != nil {
+        return nil, err
+    }
+    result, err := dataset.Translate(packages, source, target)
+    if err != nil {
+        return nil, err
+    }
+
+    fmt.Printf("Translated %d packages to %s, %d not needed there, %d without equivalent, %d not in the package map\n",
+        len(result.Packages), target, result.Dropped, len(result.Missing), len(result.Unmapped))
+    if err := result.WriteReport(mappingReportPath, mapping.Family(source), target); err != nil {
+        return nil, err
+    }
+    fmt.Println("Unmapped packages are listed in:", mappingReportPath)
+    return result.Packages, nil
+}
+//availablePackages leaves out packages the configured repositories do not have, AUR
+//packages are not in them and kept. When the manager cannot be queried nothing is left out.
+func availablePackages(manager utils.PackageManager, packages []utils.Package) []utils.Package {
+    var available []utils.Package
+    var unavailable []string
+    for _, pkg := range packages {
+        if pkg.Repository == utils.RepositoryAUR {
+            available = append(available, pkg)
+            continue
+        }
+        ok, err := manager.Available(pkg.Name)
+        if err != nil {
+            log.Printf("Warning: could not check the %s repositories, writing every package: %v\n", manager.Name(), err)
+            return packages
+        }
+        if !ok {
+            unavailable = append(unavailable, pkg.Name)
+            continue
+        }
+        available = append(available, pkg)
+    }
+    if len(unavailable) > 0 {
+        fmt.Printf("Leaving out %d packages not found in the %s repositories: %s\n", len(unavailable), manager.Name(), strings.Join(unavailable, " "))
+    }
+    return available
+}
@@ -0,0 +1,49 @@
+package system
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/mdgspace/sysreplicate/system/utils"
+)
+
+// repoManager answers Available from a fixed set of repository packages
+type repoManager struct {
+	utils.PackageManager
+	repository map[string]bool
+	err        error
+}
+
+func (m repoManager) Name() string { return "apt" }
+
+func (m repoManager) Available(name string) (bool, error) {
+	return m.repository[name], m.err
+}
+
+func TestAvailablePackages(t *testing.T) {
+	packages := []utils.Package{
+		{Name: "vim"},
+		{Name: "local-build"},
+		{Name: "yay", Repository: utils.RepositoryAUR},
+		{Name: "git"},
+	}
+	repository := map[string]bool{"vim": true, "git": true}
+	tests := []struct {
+		name string
+		err  error
+		want []string
+	}{
+		{"unknown names are left out", nil, []string{"vim", "yay", "git"}},
+		{"a failing query keeps everything", errors.New("apt-cache not found"), []string{"vim", "local-build", "yay", "git"}},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, pkg := range availablePackages(repoManager{repository: repository, err: test.err}, packages) {
+			got = append(got, pkg.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -1,9 +1,9 @@
 package system
 
 const (
-	outputSysDir     = "dist/sys-info"
-	outputScriptsDir = "dist"
-    outputBackupDir   = "dist/backups"
-	jsonOutputPath   = outputSysDir + "/package.json"
-	scriptOutputPath = outputScriptsDir + "/setup.sh"
-)
\ No newline at end of file
+	outputSysDir      = "dist/sys-info"
+	outputScriptsDir  = "dist"
+	jsonOutputPath    = outputSysDir + "/package.json"
+	mappingReportPath = outputSysDir + "/unmapped.txt"
+	scriptOutputPath  = outputScriptsDir + "/setup.sh"
+)
@@ -0,0 +1,163 @@
+package utils
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() { RegisterManager(apkManager{}) }
+
+// apkManager handles Alpine Linux.
+type apkManager struct{}
+
+func (apkManager) Name() string       { return "apk" }
+func (apkManager) Families() []string { return []string{"alpine"} }
+func (apkManager) Detect() bool       { return commandExists("apk") }
+
+func (apkManager) ListInstalled() ([]Package, error) { return fetchAPK() }
+
+func (m apkManager) ListExplicit() ([]Package, error) { return listExplicit(m) }
+
+func (apkManager) InstallCommand(packages ...Package) string {
+	return installCommand("sudo apk add", packageNames(packages))
+}
+
+// Available searches the package index for the exact name
+func (apkManager) Available(name string) (bool, error) {
+	out, err := runQuery("apk", "search", "--exact", name)
+	return strings.TrimSpace(out) != "", err
+}
+
+// InstalledCheck asks the installed database
+func (apkManager) InstalledCheck() string {
+	return `apk info -e "$1" >/dev/null 2>&1`
+}
+
+// InstalledVersion reads the V: line of the package in the installed database
+func (apkManager) InstalledVersion() string {
+	return `awk -v pkg="$1" '/^P:/ { name = substr($0, 3) } /^V:/ && name == pkg { print substr($0, 3); exit }' /lib/apk/db/installed`
+}
+
+func (apkManager) OlderVersion() string {
+	return `[ "$(apk version -t "$1" "$2")" = '<' ]`
+}
+
+func (apkManager) ValidName(name string) bool { return plainName.MatchString(name) }
+
+// PinnedInstallCommand installs name=version, which the world file then keeps pinned.
+func (apkManager) PinnedInstallCommand(packages ...Package) string {
+	return installCommand("sudo apk add", pinnedNames(packages, "="))
+}
+
+func (apkManager) FetchSources(packages []Package) (*Sources, error) {
+	return fetchAPKSources()
+}
+
+func (apkManager) RefreshCommand() string { return "sudo apk update" }
+
+// fetchAPK reads apk's installed database, the world file holds what the user asked for.
+func fetchAPK() ([]Package, error) {
+	data, err := os.ReadFile("/lib/apk/db/installed")
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	// "P:name", "V:version" and "A:arch" lines in blocks separated by blank lines
+	for _, block := range strings.Split(string(data), "\n\n") {
+		var pkg Package
+		for _, line := range strings.Split(block, "\n") {
+			key, value, ok := strings.Cut(line, ":")
+			if !ok {
+				continue
+			}
+			switch key {
+			case "P":
+				pkg.Name = value
+			case "V":
+				pkg.Version = value
+			case "A":
+				pkg.Arch = value
+			}
+		}
+		if pkg.Name != "" {
+			packages = append(packages, pkg)
+		}
+	}
+
+	world, err := os.ReadFile("/etc/apk/world")
+	if err != nil {
+		log.Println("Cannot tell explicitly installed packages apart:", err)
+		return packages, nil
+	}
+	markReasons(packages, apkWorldNames(string(world)))
+	return packages, nil
+}
+
+// apkWorldNames returns the names the world file asks for. Entries may carry a version
+// constraint or a repository tag, "name>=1.2@testing", and "!name" keeps a package from
+// being installed, so it is no install.
+func apkWorldNames(world string) []string {
+	var names []string
+	for _, atom := range strings.Fields(world) {
+		if strings.HasPrefix(atom, "!") {
+			continue
+		}
+		names = append(names, apkAtomName(atom))
+	}
+	return names
+}
+
+// apkAtomName strips the constraint and repository tag of a world entry
+func apkAtomName(atom string) string {
+	if i := strings.IndexAny(atom, "=<>~@"); i >= 0 {
+		return atom[:i]
+	}
+	return atom
+}
+
+// fetchAPKSources reads the repositories outside Alpine's mirrors and the keys added for them.
+// Packages are not annotated, apk does not record where a package came from.
+func fetchAPKSources() (*Sources, error) {
+	data, err := os.ReadFile("/etc/apk/repositories")
+	if err != nil {
+		return nil, err
+	}
+	sources := &Sources{Manager: "apk"}
+	var kept, uris []string
+	for _, line := range splitLines(string(data)) {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		// tagged repositories look like "@testing https://..."
+		fields := strings.Fields(line)
+		uri := fields[len(fields)-1]
+		parsed, err := url.Parse(uri)
+		if err != nil || parsed.Host == "" || strings.HasSuffix(parsed.Hostname(), "alpinelinux.org") {
+			continue // local media or an official mirror
+		}
+		kept = append(kept, line)
+		uris = append(uris, uri)
+	}
+	if len(kept) > 0 {
+		sources.Repositories = append(sources.Repositories, Repository{
+			Name:       "repositories",
+			Path:       "/etc/apk/repositories",
+			URIs:       uris,
+			Definition: strings.Join(kept, "\n") + "\n",
+		})
+	}
+
+	keyFiles, _ := filepath.Glob("/etc/apk/keys/*.pub")
+	keyPaths := make(map[string]bool)
+	for _, path := range keyFiles {
+		if !strings.HasPrefix(filepath.Base(path), "alpine-devel@lists.alpinelinux.org-") {
+			keyPaths[path] = true
+		}
+	}
+	sources.Keys = readKeyFiles(keyPaths)
+	return sources, nil
+}
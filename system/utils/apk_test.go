@@ -0,0 +1,24 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestAPKWorldNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		world string
+		want  []string
+	}{
+		{"plain names", "alpine-base\nopenssh\n", []string{"alpine-base", "openssh"}},
+		{"constraints and tags", "busybox>=1.36 neovim@community curl~8.5 git=2.43.0-r0", []string{"busybox", "neovim", "curl", "git"}},
+		{"negated atoms", "alpine-base !mdev-conf !busybox-openrc@edge vim", []string{"alpine-base", "vim"}},
+		{"empty", "\n", nil},
+	}
+	for _, test := range tests {
+		if got := apkWorldNames(test.world); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, want %q", test.name, got, test.want)
+		}
+	}
+}
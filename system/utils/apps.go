@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// FlatpakApp is an installed Flatpak application, runtimes come along on install.
+type FlatpakApp struct {
+	ID     string `json:"id"`
+	Branch string `json:"branch"`
+	Arch   string `json:"arch,omitempty"`
+	Remote string `json:"remote"`
+	Scope  string `json:"scope"` // "system" or "user"
+}
+
+// FlatpakRemote is a configured Flatpak remote with the keys it is verified with.
+type FlatpakRemote struct {
+	Name   string `json:"name"`
+	URL    string `json:"url"`
+	Scope  string `json:"scope"`
+	GPGKey []byte `json:"gpg_key,omitempty"`
+}
+
+// Snap is an installed snap with the channel it tracks.
+type Snap struct {
+	Name        string `json:"name"`
+	Version     string `json:"version,omitempty"`
+	Channel     string `json:"channel"`
+	Confinement string `json:"confinement"` // "strict", "classic" or "devmode"
+}
+
+// FlathubURL is where flathub's remote definition, including its key, is published.
+const FlathubURL = "https://dl.flathub.org/repo/flathub.flatpakrepo"
+
+// FetchFlatpaks lists installed Flatpak applications and the remotes they come from.
+// Nothing is returned when flatpak is not installed.
+func FetchFlatpaks() ([]FlatpakApp, []FlatpakRemote, error) {
+	if _, err := exec.LookPath("flatpak"); err != nil {
+		return nil, nil, nil
+	}
+
+	out, err := runQuery("flatpak", "list", "--app", "--columns=application,branch,arch,origin,installation")
+	if err != nil {
+		return nil, nil, err
+	}
+	apps := parseFlatpakList(out)
+
+	out, err = runQuery("flatpak", "remotes", "--columns=name,url,options")
+	if err != nil {
+		return apps, nil, err
+	}
+	remotes := parseFlatpakRemotes(out)
+	for i := range remotes {
+		remotes[i].GPGKey = flatpakTrustedKeys(remotes[i])
+	}
+	return apps, remotes, nil
+}
+
+// parseFlatpakList parses the tab separated columns of flatpak list.
+func parseFlatpakList(out string) []FlatpakApp {
+	var apps []FlatpakApp
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 5 {
+			continue
+		}
+		apps = append(apps, FlatpakApp{
+			ID:     fields[0],
+			Branch: fields[1],
+			Arch:   fields[2],
+			Remote: fields[3],
+			Scope:  fields[4],
+		})
+	}
+	return apps
+}
+
+// parseFlatpakRemotes parses flatpak remotes, the options column holds the scope and
+// flags like "disabled".
+func parseFlatpakRemotes(out string) []FlatpakRemote {
+	var remotes []FlatpakRemote
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, "\t")
+		if len(fields) != 3 {
+			continue
+		}
+		options := strings.Split(fields[2], ",")
+		remote := FlatpakRemote{Name: fields[0], URL: fields[1], Scope: "system"}
+		disabled := false
+		for _, option := range options {
+			switch strings.TrimSpace(option) {
+			case "user":
+				remote.Scope = "user"
+			case "disabled":
+				disabled = true
+			}
+		}
+		if !disabled {
+			remotes = append(remotes, remote)
+		}
+	}
+	return remotes
+}
+
+// flatpakTrustedKeys reads the keyring flatpak verifies a remote with, when it is readable.
+func flatpakTrustedKeys(remote FlatpakRemote) []byte {
+	repo := "/var/lib/flatpak/repo"
+	if remote.Scope == "user" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil
+		}
+		repo = filepath.Join(home, ".local/share/flatpak/repo")
+	}
+	keys, err := os.ReadFile(filepath.Join(repo, remote.Name+".trustedkeys.gpg"))
+	if err != nil {
+		return nil
+	}
+	return keys
+}
+
+// FetchSnaps lists installed snaps, leaving out the bases and snapd itself.
+// Nothing is returned when snap is not installed.
+func FetchSnaps() ([]Snap, error) {
+	if _, err := exec.LookPath("snap"); err != nil {
+		return nil, nil
+	}
+	out, err := runQuery("snap", "list", "--unicode=never", "--color=never")
+	if err != nil {
+		return nil, err
+	}
+	return parseSnapList(out), nil
+}
+
+// parseSnapList parses the Name Version Rev Tracking Publisher Notes table of snap list.
+func parseSnapList(out string) []Snap {
+	var snaps []Snap
+	for i, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if i == 0 || len(fields) < 6 {
+			continue // header
+		}
+		notes := strings.Split(fields[5], ",")
+		snap := Snap{Name: fields[0], Version: fields[1], Channel: fields[3], Confinement: "strict"}
+		skip := false
+		for _, note := range notes {
+			switch note {
+			case "base", "core", "snapd":
+				skip = true // installed automatically for the snaps that need them
+			case "classic", "devmode":
+				snap.Confinement = note
+			}
+		}
+		if !skip {
+			snaps = append(snaps, snap)
+		}
+	}
+	return snaps
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseFlatpakList(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []FlatpakApp
+	}{
+		{"system and user apps", "org.mozilla.firefox\tstable\tx86_64\tflathub\tsystem\n" +
+			"com.valvesoftware.Steam\tstable\tx86_64\tflathub\tuser\n" +
+			"org.gnome.Builder\tmaster\taarch64\tgnome-nightly\tsystem\n",
+			[]FlatpakApp{
+				{ID: "org.mozilla.firefox", Branch: "stable", Arch: "x86_64", Remote: "flathub", Scope: "system"},
+				{ID: "com.valvesoftware.Steam", Branch: "stable", Arch: "x86_64", Remote: "flathub", Scope: "user"},
+				{ID: "org.gnome.Builder", Branch: "master", Arch: "aarch64", Remote: "gnome-nightly", Scope: "system"},
+			}},
+		{"short lines are skipped", "org.mozilla.firefox\tstable\n\n", nil},
+		{"no apps", "", nil},
+	}
+	for _, test := range tests {
+		if got := parseFlatpakList(test.out); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestParseFlatpakRemotes(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []FlatpakRemote
+	}{
+		{"system remote", "flathub\thttps://dl.flathub.org/repo/\tsystem\n",
+			[]FlatpakRemote{{Name: "flathub", URL: "https://dl.flathub.org/repo/", Scope: "system"}}},
+		{"user remote", "flathub-beta\thttps://dl.flathub.org/beta-repo/\tuser\n",
+			[]FlatpakRemote{{Name: "flathub-beta", URL: "https://dl.flathub.org/beta-repo/", Scope: "user"}}},
+		{"disabled remotes are left out", "fedora\toci+https://registry.fedoraproject.org\tsystem,disabled\n" +
+			"gnome-nightly\thttps://nightly.gnome.org/repo/\tuser,no-gpg-verify\n",
+			[]FlatpakRemote{{Name: "gnome-nightly", URL: "https://nightly.gnome.org/repo/", Scope: "user"}}},
+		{"empty options", "flathub\thttps://dl.flathub.org/repo/\t\n",
+			[]FlatpakRemote{{Name: "flathub", URL: "https://dl.flathub.org/repo/", Scope: "system"}}},
+	}
+	for _, test := range tests {
+		if got := parseFlatpakRemotes(test.out); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFlatpakTrustedKeys(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	repo := filepath.Join(home, ".local/share/flatpak/repo")
+	if err := os.MkdirAll(repo, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(repo, "flathub-beta.trustedkeys.gpg"), []byte("keyring"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := flatpakTrustedKeys(FlatpakRemote{Name: "flathub-beta", Scope: "user"}); string(got) != "keyring" {
+		t.Errorf("user remote key %q, want the keyring from the user repo", got)
+	}
+	if got := flatpakTrustedKeys(FlatpakRemote{Name: "missing", Scope: "user"}); got != nil {
+		t.Errorf("remote without a keyring has key %q", got)
+	}
+}
+
+// snap list output, notes mark bases, snapd itself and the confinement
+const snapList = `Name               Version          Rev    Tracking         Publisher     Notes
+bare               1.0              5      latest/stable    canonical**   base
+code               1.90.2           163    latest/stable    vscode**      classic
+core22             20240408         1380   latest/stable    canonical**   base
+firefox            127.0.2-1        4451   latest/stable/…  mozilla**     -
+hello-world        6.4              29     latest/edge      canonical**   devmode
+lxd                5.21.1-2d13beb   28463  5.21/stable      canonical**   -
+snapd              2.63             21759  latest/stable    canonical**   snapd
+`
+
+func TestParseSnapList(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []Snap
+	}{
+		{"snap list", snapList, []Snap{
+			{Name: "code", Version: "1.90.2", Channel: "latest/stable", Confinement: "classic"},
+			{Name: "firefox", Version: "127.0.2-1", Channel: "latest/stable/…", Confinement: "strict"},
+			{Name: "hello-world", Version: "6.4", Channel: "latest/edge", Confinement: "devmode"},
+			{Name: "lxd", Version: "5.21.1-2d13beb", Channel: "5.21/stable", Confinement: "strict"},
+		}},
+		{"only the header", "Name  Version  Rev  Tracking  Publisher  Notes\n", nil},
+		{"no snaps", "", nil},
+	}
+	for _, test := range tests {
+		if got := parseSnapList(test.out); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
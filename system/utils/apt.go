@@ -0,0 +1,314 @@
+package utils
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() { RegisterManager(aptManager{}) }
+
+// aptManager handles Debian and its derivatives through dpkg and apt.
+type aptManager struct{}
+
+func (aptManager) Name() string       { return "apt" }
+func (aptManager) Families() []string { return []string{"debian"} }
+func (aptManager) Detect() bool       { return commandExists("apt-get") }
+
+func (aptManager) ListInstalled() ([]Package, error) { return fetchDpkg() }
+
+func (m aptManager) ListExplicit() ([]Package, error) { return listExplicit(m) }
+
+func (aptManager) InstallCommand(packages ...Package) string {
+	return installCommand("sudo apt-get install -y", packageNames(packages))
+}
+
+// Available asks apt's package lists, apt-cache fails for names it does not know
+func (aptManager) Available(name string) (bool, error) {
+	return queryExits("apt-cache", "show", "--no-all-versions", name)
+}
+
+// InstalledCheck asks dpkg, which also keeps removed packages whose config files remain
+func (aptManager) InstalledCheck() string {
+	return `dpkg-query -W -f='${Status}' "$1" 2>/dev/null | grep -q ' installed$'`
+}
+
+// InstalledVersion prints the version dpkg records, the first one of a multiarch package
+func (aptManager) InstalledVersion() string {
+	return `dpkg-query -W -f='${Version}\n' "$1" 2>/dev/null | head -n 1`
+}
+
+func (aptManager) OlderVersion() string {
+	return `dpkg --compare-versions "$1" lt "$2"`
+}
+
+func (aptManager) ValidName(name string) bool { return debianName.MatchString(name) }
+
+// PinnedInstallCommand installs name=version, older versions than the candidate included.
+func (aptManager) PinnedInstallCommand(packages ...Package) string {
+	return installCommand("sudo apt-get install -y --allow-downgrades", pinnedNames(packages, "="))
+}
+
+func (aptManager) FetchSources(packages []Package) (*Sources, error) {
+	return fetchAptSources(packages)
+}
+
+func (aptManager) RefreshCommand() string { return "sudo apt-get update" }
+
+// fetchDpkg lists installed packages with their version and architecture.
+func fetchDpkg() ([]Package, error) {
+	out, err := runQuery("dpkg-query", "-W", "-f", "${db:Status-Abbrev}\t${Package}\t${Version}\t${Architecture}\n")
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, "\t")
+		// "ii " is installed, anything else was removed or only half configured
+		if len(fields) != 4 || !strings.HasPrefix(fields[0], "ii") {
+			continue
+		}
+		packages = append(packages, Package{Name: fields[1], Version: fields[2], Arch: fields[3]})
+	}
+
+	manual, err := runQuery("apt-mark", "showmanual")
+	if err != nil {
+		log.Println("Cannot tell manually installed packages apart:", err)
+		return packages, nil
+	}
+	markReasons(packages, splitLines(manual))
+	return packages, nil
+}
+
+// hosts of the Debian and Ubuntu archives, sources pointing only there are the distro's own
+var officialAptHosts = []string{
+	"deb.debian.org", "security.debian.org", "ftp.debian.org", "*.debian.org",
+	"archive.ubuntu.com", "*.archive.ubuntu.com", "security.ubuntu.com", "ports.ubuntu.com",
+}
+
+// fetchAptSources reads third-party one-line and deb822 sources and the keys they are signed by.
+func fetchAptSources(packages []Package) (*Sources, error) {
+	sources := &Sources{Manager: "apt"}
+	signedBy := make(map[string]bool)
+
+	lists, _ := filepath.Glob("/etc/apt/sources.list.d/*.list")
+	lists = append([]string{"/etc/apt/sources.list"}, lists...)
+	for _, path := range lists {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		repo, keys := parseAptList(string(data))
+		if repo == nil {
+			continue
+		}
+		repo.Name = strings.TrimSuffix(filepath.Base(path), ".list")
+		if path == "/etc/apt/sources.list" {
+			// the distro's own sources.list stays, extra lines go next to it
+			repo.Name = "sources-list"
+		}
+		repo.Path = "/etc/apt/sources.list.d/" + repo.Name + ".list"
+		sources.Repositories = append(sources.Repositories, *repo)
+		for _, key := range keys {
+			signedBy[key] = true
+		}
+	}
+
+	deb822, _ := filepath.Glob("/etc/apt/sources.list.d/*.sources")
+	for _, path := range deb822 {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		repo, keys := parseAptSources(string(data))
+		if repo == nil {
+			continue
+		}
+		repo.Name = strings.TrimSuffix(filepath.Base(path), ".sources")
+		repo.Path = path
+		sources.Repositories = append(sources.Repositories, *repo)
+		for _, key := range keys {
+			signedBy[key] = true
+		}
+	}
+
+	// keys added the old way are trusted for every source
+	legacy, _ := filepath.Glob("/etc/apt/trusted.gpg.d/*")
+	for _, path := range legacy {
+		base := filepath.Base(path)
+		if !strings.HasPrefix(base, "debian-archive-") && !strings.HasPrefix(base, "ubuntu-keyring-") {
+			signedBy[path] = true
+		}
+	}
+	sources.Keys = readKeyFiles(signedBy)
+
+	annotateAptPackages(packages, sources.Repositories)
+	return sources, nil
+}
+
+// parseAptList keeps the third-party deb lines of a one-line style list and returns
+// the keyrings they name with signed-by.
+func parseAptList(data string) (*Repository, []string) {
+	var kept []string
+	var uris, keys []string
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || (fields[0] != "deb" && fields[0] != "deb-src") {
+			continue
+		}
+		var options []string
+		rest := fields[1:]
+		if strings.HasPrefix(rest[0], "[") {
+			// options may contain spaces, they run up to the closing bracket
+			for i, field := range rest {
+				options = append(options, strings.Trim(field, "[]"))
+				if strings.HasSuffix(field, "]") {
+					rest = rest[i+1:]
+					break
+				}
+			}
+		}
+		if len(rest) == 0 {
+			continue
+		}
+		var lineKeys []string
+		for _, option := range options {
+			if value, ok := strings.CutPrefix(option, "signed-by="); ok {
+				lineKeys = append(lineKeys, strings.Split(value, ",")...)
+			}
+		}
+		if officialAptSource([]string{rest[0]}, lineKeys) {
+			continue
+		}
+		kept = append(kept, strings.TrimSpace(line))
+		uris = append(uris, rest[0])
+		keys = append(keys, lineKeys...)
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	return &Repository{URIs: uris, Definition: strings.Join(kept, "\n") + "\n"}, keys
+}
+
+// parseAptSources keeps the enabled third-party stanzas of a deb822 .sources file.
+// Keys given inline in Signed-By stay part of the definition.
+func parseAptSources(data string) (*Repository, []string) {
+	var kept []string
+	var uris, keys []string
+	for _, stanza := range strings.Split(data, "\n\n") {
+		fields := parseDeb822(stanza)
+		if len(fields) == 0 || strings.EqualFold(fields["Enabled"], "no") {
+			continue
+		}
+		stanzaURIs := strings.Fields(fields["URIs"])
+		var stanzaKeys []string
+		if signedBy := fields["Signed-By"]; signedBy != "" && !strings.Contains(signedBy, "\n") {
+			stanzaKeys = strings.Fields(signedBy)
+		}
+		if len(stanzaURIs) == 0 || officialAptSource(stanzaURIs, stanzaKeys) {
+			continue
+		}
+		kept = append(kept, strings.TrimSpace(stanza))
+		uris = append(uris, stanzaURIs...)
+		for _, key := range stanzaKeys {
+			// Signed-By may also hold a fingerprint, only key files are copied
+			if strings.HasPrefix(key, "/") {
+				keys = append(keys, key)
+			}
+		}
+	}
+	if len(kept) == 0 {
+		return nil, nil
+	}
+	return &Repository{URIs: uris, Definition: strings.Join(kept, "\n\n") + "\n"}, keys
+}
+
+// parseDeb822 reads the fields of one stanza, continuation lines start with a space.
+func parseDeb822(stanza string) map[string]string {
+	fields := make(map[string]string)
+	var last string
+	for _, line := range strings.Split(stanza, "\n") {
+		if strings.HasPrefix(line, "#") || strings.TrimSpace(line) == "" {
+			continue
+		}
+		if (strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")) && last != "" {
+			fields[last] += "\n" + strings.TrimSpace(line)
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		last = strings.TrimSpace(key)
+		fields[last] = strings.TrimSpace(value)
+	}
+	return fields
+}
+
+// officialAptSource tells the distro's own sources apart by their archive keyring or host.
+func officialAptSource(uris, keys []string) bool {
+	for _, key := range keys {
+		base := filepath.Base(key)
+		if strings.HasPrefix(base, "debian-archive-") || strings.HasPrefix(base, "ubuntu-archive-") {
+			return true
+		}
+	}
+	for _, uri := range uris {
+		parsed, err := url.Parse(uri)
+		if err != nil || !officialHost(parsed.Hostname()) {
+			return false
+		}
+	}
+	return true
+}
+
+func officialHost(host string) bool {
+	for _, pattern := range officialAptHosts {
+		if match, _ := filepath.Match(pattern, host); match {
+			return true
+		}
+	}
+	return false
+}
+
+// annotateAptPackages marks installed packages whose exact version one of the
+// repositories offers, using the package lists apt downloaded for it.
+func annotateAptPackages(packages []Package, repositories []Repository) {
+	offered := make(map[string]string) // name=version -> repository
+	for _, repo := range repositories {
+		for _, uri := range repo.URIs {
+			lists, _ := filepath.Glob(filepath.Join("/var/lib/apt/lists", aptListPrefix(uri)+"_*Packages*"))
+			for _, list := range lists {
+				// apt-helper reads the lists however apt compressed them
+				out, err := runQuery("/usr/lib/apt/apt-helper", "cat-file", list)
+				if err != nil {
+					continue
+				}
+				for _, fields := range strings.Split(out, "\n\n") {
+					stanza := parseDeb822(fields)
+					if stanza["Package"] != "" {
+						offered[stanza["Package"]+"="+stanza["Version"]] = repo.Name
+					}
+				}
+			}
+		}
+	}
+	for i := range packages {
+		if repo, ok := offered[packages[i].Name+"="+packages[i].Version]; ok && packages[i].Repository == "" {
+			packages[i].Repository = repo
+		}
+	}
+}
+
+// aptListPrefix is the file name apt gives the lists of a URI, the scheme is dropped,
+// underscores are escaped and slashes become underscores.
+func aptListPrefix(uri string) string {
+	if _, rest, ok := strings.Cut(uri, "://"); ok {
+		uri = rest
+	}
+	uri = strings.TrimRight(uri, "/")
+	uri = strings.ReplaceAll(uri, "_", "%5f")
+	return strings.ReplaceAll(uri, "/", "_")
+}
@@ -0,0 +1,113 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAptList(t *testing.T) {
+	tests := []struct {
+		name       string
+		data       string
+		definition string // "" when nothing is kept
+		uris       []string
+		keys       []string
+	}{
+		{"distro sources are left out",
+			"deb http://deb.debian.org/debian bookworm main\ndeb http://security.debian.org/debian-security bookworm-security main\n" +
+				"deb-src http://ftp.debian.org/debian bookworm main\ndeb http://fr.archive.ubuntu.com/ubuntu noble main\n",
+			"", nil, nil},
+		{"third-party source with signed-by",
+			"# Docker\ndeb [arch=amd64 signed-by=/etc/apt/keyrings/docker.asc] https://download.docker.com/linux/debian bookworm stable\n",
+			"deb [arch=amd64 signed-by=/etc/apt/keyrings/docker.asc] https://download.docker.com/linux/debian bookworm stable\n",
+			[]string{"https://download.docker.com/linux/debian"}, []string{"/etc/apt/keyrings/docker.asc"}},
+		{"options with spaces and several keys",
+			"deb [ arch=amd64 signed-by=/usr/share/keyrings/a.gpg,/usr/share/keyrings/b.gpg ] https://repo.example.com/apt stable main\n",
+			"deb [ arch=amd64 signed-by=/usr/share/keyrings/a.gpg,/usr/share/keyrings/b.gpg ] https://repo.example.com/apt stable main\n",
+			[]string{"https://repo.example.com/apt"}, []string{"/usr/share/keyrings/a.gpg", "/usr/share/keyrings/b.gpg"}},
+		{"mirror signed by the archive keyring",
+			"deb [signed-by=/usr/share/keyrings/debian-archive-keyring.gpg] https://mirror.example.com/debian bookworm main\n",
+			"", nil, nil},
+		{"mixed lines keep only the third-party ones",
+			"deb http://deb.debian.org/debian bookworm main\n#deb https://commented.example.com/ stable main\n" +
+				"deb https://packages.microsoft.com/repos/code stable main\n",
+			"deb https://packages.microsoft.com/repos/code stable main\n",
+			[]string{"https://packages.microsoft.com/repos/code"}, nil},
+		{"options without a URI", "deb [arch=amd64]\n", "", nil, nil},
+	}
+	for _, test := range tests {
+		repo, keys := parseAptList(test.data)
+		if test.definition == "" {
+			if repo != nil {
+				t.Errorf("%s: kept %q", test.name, repo.Definition)
+			}
+			continue
+		}
+		if repo == nil {
+			t.Errorf("%s: nothing kept", test.name)
+			continue
+		}
+		if repo.Definition != test.definition || !reflect.DeepEqual(repo.URIs, test.uris) || !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: got %q %v %v, want %q %v %v", test.name, repo.Definition, repo.URIs, keys, test.definition, test.uris, test.keys)
+		}
+	}
+}
+
+func TestParseAptSources(t *testing.T) {
+	debian := "Types: deb\nURIs: http://deb.debian.org/debian\nSuites: bookworm bookworm-updates\nComponents: main\n" +
+		"Signed-By: /usr/share/keyrings/debian-archive-keyring.gpg\n"
+	vscode := "Types: deb\nURIs: https://packages.microsoft.com/repos/code\nSuites: stable\nComponents: main\n" +
+		"Architectures: amd64,arm64,armhf\nSigned-By: /usr/share/keyrings/microsoft.gpg"
+	inline := "Types: deb\nURIs: https://repo.example.com/apt\nSuites: stable\nComponents: main\nSigned-By:\n" +
+		" -----BEGIN PGP PUBLIC KEY BLOCK-----\n .\n mQINBGR\n -----END PGP PUBLIC KEY BLOCK-----"
+	tests := []struct {
+		name       string
+		data       string
+		definition string // "" when nothing is kept
+		uris       []string
+		keys       []string
+	}{
+		{"distro stanza", debian, "", nil, nil},
+		{"third-party stanza next to the distro one", debian + "\n" + vscode + "\n", vscode + "\n",
+			[]string{"https://packages.microsoft.com/repos/code"}, []string{"/usr/share/keyrings/microsoft.gpg"}},
+		{"disabled stanza", "Enabled: no\n" + vscode + "\n", "", nil, nil},
+		{"inline key stays in the definition", inline + "\n", inline + "\n", []string{"https://repo.example.com/apt"}, nil},
+		{"fingerprint instead of a key file", "Types: deb\nURIs: https://ppa.launchpadcontent.net/x/y/ubuntu\nSuites: noble\n" +
+			"Signed-By: 0123456789ABCDEF0123456789ABCDEF01234567\n",
+			"Types: deb\nURIs: https://ppa.launchpadcontent.net/x/y/ubuntu\nSuites: noble\nSigned-By: 0123456789ABCDEF0123456789ABCDEF01234567\n",
+			[]string{"https://ppa.launchpadcontent.net/x/y/ubuntu"}, nil},
+		{"comments only", "# nothing here\n", "", nil, nil},
+	}
+	for _, test := range tests {
+		repo, keys := parseAptSources(test.data)
+		if test.definition == "" {
+			if repo != nil {
+				t.Errorf("%s: kept %q", test.name, repo.Definition)
+			}
+			continue
+		}
+		if repo == nil {
+			t.Errorf("%s: nothing kept", test.name)
+			continue
+		}
+		if repo.Definition != test.definition || !reflect.DeepEqual(repo.URIs, test.uris) || !reflect.DeepEqual(keys, test.keys) {
+			t.Errorf("%s: got %q %v %v, want %q %v %v", test.name, repo.Definition, repo.URIs, keys, test.definition, test.uris, test.keys)
+		}
+	}
+}
+
+func TestAptListPrefix(t *testing.T) {
+	tests := []struct {
+		uri  string
+		want string
+	}{
+		{"https://download.docker.com/linux/debian", "download.docker.com_linux_debian"},
+		{"http://ppa.launchpad.net/deadsnakes/ppa/ubuntu/", "ppa.launchpad.net_deadsnakes_ppa_ubuntu"},
+		{"https://repo.example.com/my_repo", "repo.example.com_my%5frepo"},
+	}
+	for _, test := range tests {
+		if got := aptListPrefix(test.uri); got != test.want {
+			t.Errorf("%s: got %q, want %q", test.uri, got, test.want)
+		}
+	}
+}
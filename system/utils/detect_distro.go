@@ -5,21 +5,148 @@ import (
 	"strings"
 )
 
+// OSRelease holds the fields of os-release(5) that identify a distro.
+type OSRelease struct {
+	ID         string
+	IDLike     []string
+	VersionID  string
+	Codename   string
+	PrettyName string
+}
+
+// os-release locations in order of precedence
+var osReleasePaths = []string{"/etc/os-release", "/usr/lib/os-release"}
+
+// IDs and ID_LIKE values that belong to a family under another name
+var familyAliases = map[string]string{
+	"ubuntu":    "debian",
+	"raspbian":  "debian",
+	"centos":    "rhel",
+	"rocky":     "rhel",
+	"almalinux": "rhel",
+	"ol":        "rhel",
+	"opensuse":  "suse",
+	"sles":      "suse",
+	"sled":      "suse",
+	"archarm":   "arch",
+	"manjaro":   "arch",
+}
+
 // DetectDistro returns the distro and base distro.
 func DetectDistro() (string, string) {
-	data, err := os.ReadFile("/etc/os-release")
+	release, err := ReadOSRelease()
 	if err != nil {
+		// without os-release the package manager still tells the family
+		if family := probeFamily(); family != "" {
+			return "unknown", family
+		}
 		return "unknown", "unknown"
 	}
-	var distro, baseDistro string
-	lines := strings.Split(string(data), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "ID=") {
-			distro = strings.Trim(strings.SplitN(line, "=", 2)[1], `"`)
+	return release.ID, release.Family()
+}
+
+// ReadOSRelease parses /etc/os-release, or /usr/lib/os-release when the former is missing.
+func ReadOSRelease() (*OSRelease, error) {
+	var err error
+	for _, path := range osReleasePaths {
+		var data []byte
+		data, err = os.ReadFile(path)
+		if err == nil {
+			return ParseOSRelease(string(data)), nil
+		}
+	}
+	return nil, err
+}
+
+// ParseOSRelease parses the KEY=value lines of os-release, values may be quoted like
+// shell strings.
+func ParseOSRelease(data string) *OSRelease {
+	fields := make(map[string]string)
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		fields[key] = unquoteOSRelease(value)
+	}
+
+	release := &OSRelease{
+		ID:         strings.ToLower(fields["ID"]),
+		IDLike:     strings.Fields(strings.ToLower(fields["ID_LIKE"])),
+		VersionID:  fields["VERSION_ID"],
+		Codename:   fields["VERSION_CODENAME"],
+		PrettyName: fields["PRETTY_NAME"],
+	}
+	// older Ubuntu derivatives only set their base's codename
+	if release.Codename == "" {
+		release.Codename = fields["UBUNTU_CODENAME"]
+	}
+	return release
+}
+
+// unquoteOSRelease strips single or double quotes, inside double quotes \" \\ \$ and \`
+// are escapes.
+func unquoteOSRelease(value string) string {
+	if len(value) < 2 {
+		return value
+	}
+	switch {
+	case value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1]
+	case value[0] == '"' && value[len(value)-1] == '"':
+		value = value[1 : len(value)-1]
+		var b strings.Builder
+		for i := 0; i < len(value); i++ {
+			if value[i] == '\\' && i+1 < len(value) && strings.ContainsRune("\"\\$`", rune(value[i+1])) {
+				i++
+			}
+			b.WriteByte(value[i])
 		}
-		if strings.HasPrefix(line, "ID_LIKE=") {
-			baseDistro = strings.Trim(strings.SplitN(line, "=", 2)[1], `"`)
+		return b.String()
+	}
+	return value
+}
+
+// Family resolves the base distro: the ID itself when it is a known family, otherwise the
+// first known entry of ID_LIKE, which lists the closest relative first. When neither is
+// known the installed package manager decides.
+func (r *OSRelease) Family() string {
+	for _, id := range append([]string{r.ID}, r.IDLike...) {
+		if family := familyOf(id); family != "" {
+			return family
+		}
+	}
+	if family := probeFamily(); family != "" {
+		return family
+	}
+	return "unknown"
+}
+
+// familyOf maps an ID or ID_LIKE value to its family, "" when unknown
+func familyOf(id string) string {
+	if ManagerFor(id) != nil {
+		return id
+	}
+	if family, ok := familyAliases[id]; ok {
+		return family
+	}
+	// opensuse-tumbleweed, opensuse-leap, opensuse-microos...
+	if strings.HasPrefix(id, "opensuse") {
+		return "suse"
+	}
+	return ""
+}
+
+// probeFamily returns the family of the first package manager found on the system
+func probeFamily() string {
+	for _, manager := range Managers() {
+		if manager.Detect() {
+			return manager.Families()[0]
 		}
 	}
-	return distro, baseDistro
-}
\ No newline at end of file
+	return ""
+}
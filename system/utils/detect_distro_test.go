@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseOSRelease(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want OSRelease
+	}{
+		{
+			name: "unquoted",
+			data: "NAME=Arch Linux\nID=arch\nBUILD_ID=rolling\n",
+			want: OSRelease{ID: "arch", IDLike: []string{}},
+		},
+		{
+			name: "double quoted with escapes",
+			data: `PRETTY_NAME="Fedora Linux 40 (Workstation \"Edition\")"` + "\nID=fedora\nVERSION_ID=40\n",
+			want: OSRelease{ID: "fedora", IDLike: []string{}, VersionID: "40", PrettyName: `Fedora Linux 40 (Workstation "Edition")`},
+		},
+		{
+			name: "single quoted",
+			data: "ID='debian'\nVERSION_CODENAME='bookworm'\n",
+			want: OSRelease{ID: "debian", IDLike: []string{}, Codename: "bookworm"},
+		},
+		{
+			name: "comments and blank lines",
+			data: "# written by the distro\n\nID=alpine\n  # ID=debian\nnot a field\n",
+			want: OSRelease{ID: "alpine", IDLike: []string{}},
+		},
+		{
+			name: "Mint",
+			data: "NAME=\"Linux Mint\"\nID=linuxmint\nID_LIKE=\"ubuntu debian\"\nVERSION_ID=\"21.3\"\nUBUNTU_CODENAME=jammy\n",
+			want: OSRelease{ID: "linuxmint", IDLike: []string{"ubuntu", "debian"}, VersionID: "21.3", Codename: "jammy"},
+		},
+		{
+			name: "uppercase ID",
+			data: "ID=\"Rocky\"\nID_LIKE=\"RHEL CentOS Fedora\"\n",
+			want: OSRelease{ID: "rocky", IDLike: []string{"rhel", "centos", "fedora"}},
+		},
+	}
+	for _, test := range tests {
+		got := ParseOSRelease(test.data)
+		if !reflect.DeepEqual(*got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, *got, test.want)
+		}
+	}
+}
+
+func TestOSReleaseFamily(t *testing.T) {
+	// with nothing on PATH probing finds no package manager
+	t.Setenv("PATH", t.TempDir())
+
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"ID=linuxmint\nID_LIKE=\"ubuntu debian\"\n", "debian"},
+		{"ID=debian\n", "debian"},
+		{"ID=arch\n", "arch"},
+		{"ID=fedora\n", "fedora"},
+		{"ID=ubuntu\nID_LIKE=debian\n", "debian"},
+		{"ID=manjaro\nID_LIKE=arch\n", "arch"},
+		{"ID=rocky\nID_LIKE=\"rhel centos fedora\"\n", "rhel"},
+		{"ID=opensuse-tumbleweed\nID_LIKE=\"opensuse suse\"\n", "suse"},
+		{"ID=pop\nID_LIKE=\"ubuntu debian\"\n", "debian"},
+		{"ID=somethingnew\nID_LIKE=alsonew\n", "unknown"},
+	}
+	for _, test := range tests {
+		if got := ParseOSRelease(test.data).Family(); got != test.want {
+			t.Errorf("%q: family %q, want %q", test.data, got, test.want)
+		}
+	}
+}
+
+func TestOSReleaseFamilyProbes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "xbps-install"), []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	// an unknown ID is resolved by the installed package manager
+	if got := ParseOSRelease("ID=somethingnew\n").Family(); got != "void" {
+		t.Errorf("probed family %q, want void", got)
+	}
+	// a known ID does not probe
+	if got := ParseOSRelease("ID=alpine\n").Family(); got != "alpine" {
+		t.Errorf("family %q, want alpine", got)
+	}
+}
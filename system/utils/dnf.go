@@ -0,0 +1,162 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() { RegisterManager(dnfManager{}) }
+
+// dnfManager handles Fedora and the RHEL family.
+type dnfManager struct{}
+
+func (dnfManager) Name() string       { return "dnf" }
+func (dnfManager) Families() []string { return []string{"fedora", "rhel"} }
+func (dnfManager) Detect() bool       { return commandExists("dnf") }
+
+func (dnfManager) ListInstalled() ([]Package, error) { return fetchRPM() }
+
+func (m dnfManager) ListExplicit() ([]Package, error) { return listExplicit(m) }
+
+// InstallCommand installs packages with dnf install, and comps groups named "@id" with
+// dnf group install.
+func (dnfManager) InstallCommand(packages ...Package) string {
+	return dnfInstallCommand(packageNames(packages))
+}
+
+// Available asks the enabled repositories, repoquery and group info print nothing for
+// unknown names
+func (dnfManager) Available(name string) (bool, error) {
+	if group, ok := strings.CutPrefix(name, "@"); ok {
+		out, err := runQuery("dnf", "group", "info", "--quiet", group)
+		return strings.TrimSpace(out) != "", err
+	}
+	out, err := runQuery("dnf", "repoquery", "--quiet", "--available", name)
+	return strings.TrimSpace(out) != "", err
+}
+
+// InstalledCheck asks the rpm database. Groups are not in it, they always count as missing
+// and installing an installed group changes nothing.
+func (dnfManager) InstalledCheck() string {
+	return `case "$1" in @*) false ;; *) rpm -q "$1" >/dev/null 2>&1 ;; esac`
+}
+
+func (dnfManager) InstalledVersion() string { return rpmInstalledVersion }
+
+func (dnfManager) OlderVersion() string { return rpmOlderVersion }
+
+func (dnfManager) ValidName(name string) bool { return dnfName.MatchString(name) }
+
+// PinnedInstallCommand installs name-version, the version carries the release and any epoch.
+func (dnfManager) PinnedInstallCommand(packages ...Package) string {
+	return dnfInstallCommand(pinnedNames(packages, "-"))
+}
+
+// dnfInstallCommand installs the names with dnf install, those starting with "@" are groups
+// and go to dnf group install
+func dnfInstallCommand(names []string) string {
+	var packages, groups []string
+	for _, name := range names {
+		if group, ok := strings.CutPrefix(name, "@"); ok {
+			groups = append(groups, group)
+		} else {
+			packages = append(packages, name)
+		}
+	}
+	if len(groups) == 0 {
+		return installCommand("sudo dnf install -y", packages)
+	}
+	if len(packages) == 0 {
+		return installCommand("sudo dnf group install -y", groups)
+	}
+	return installCommand("sudo dnf install -y", packages) + " && " + installCommand("sudo dnf group install -y", groups)
+}
+
+func (dnfManager) FetchSources(packages []Package) (*Sources, error) {
+	return fetchDnfSources(packages)
+}
+
+func (dnfManager) RefreshCommand() string { return "sudo dnf makecache" }
+
+// fetchRPM lists installed packages with the reasons dnf recorded.
+func fetchRPM() ([]Package, error) {
+	packages, err := listRPM()
+	if err != nil {
+		return nil, err
+	}
+
+	// only dnf keeps track of what the user asked for, rpm itself does not
+	userInstalled, err := runQuery("dnf", "repoquery", "--userinstalled", "--queryformat", "%{name}\n")
+	if err != nil {
+		log.Println("Cannot tell user installed packages apart:", err)
+		return packages, nil
+	}
+	markReasons(packages, splitLines(userInstalled))
+	return packages, nil
+}
+
+// packages shipping the distro's own .repo files
+var officialRepoPackages = map[string]bool{
+	"fedora-repos": true, "fedora-repos-modular": true, "fedora-repos-archive": true,
+	"fedora-repos-rawhide": true, "centos-stream-repos": true, "rocky-repos": true,
+	"almalinux-repos": true, "redhat-release": true,
+}
+
+// fetchDnfSources reads .repo files with an enabled repository that the distro does not ship,
+// like COPR and RPM Fusion, with the local keys their gpgkey options point to.
+func fetchDnfSources(packages []Package) (*Sources, error) {
+	files, err := filepath.Glob("/etc/yum.repos.d/*.repo")
+	if err != nil {
+		return nil, err
+	}
+	sources := &Sources{Manager: "dnf"}
+	keyPaths := make(map[string]bool)
+	for _, path := range files {
+		// redhat.repo is generated by subscription-manager for the subscribed system
+		if filepath.Base(path) == "redhat.repo" {
+			continue
+		}
+		if owner, err := runQuery("rpm", "-qf", "--queryformat", "%{NAME}", path); err == nil && officialRepoPackages[owner] {
+			continue
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		uris, keys, enabled := parseRepoFile(string(data))
+		if !enabled {
+			continue
+		}
+		sources.Repositories = append(sources.Repositories, Repository{
+			Name:       strings.TrimSuffix(filepath.Base(path), ".repo"),
+			Path:       path,
+			URIs:       uris,
+			Definition: string(data),
+		})
+		for _, key := range keys {
+			keyPaths[key] = true
+		}
+	}
+	sources.Keys = readKeyFiles(keyPaths)
+
+	// dnf remembers the repository id of every installed package
+	out, err := runQuery("dnf", "repoquery", "--installed", "--queryformat", "%{name}\t%{from_repo}\n")
+	if err != nil {
+		log.Println("Cannot tell which repository packages came from:", err)
+		return sources, nil
+	}
+	from := make(map[string]string)
+	for _, line := range splitLines(out) {
+		if name, repo, ok := strings.Cut(line, "\t"); ok && repo != "" && repo != "@System" {
+			from[name] = strings.TrimPrefix(repo, "@")
+		}
+	}
+	for i := range packages {
+		if packages[i].Repository == "" {
+			packages[i].Repository = from[packages[i].Name]
+		}
+	}
+	return sources, nil
+}
@@ -0,0 +1,25 @@
+package utils
+
+import "testing"
+
+func TestDnfGroups(t *testing.T) {
+	tests := []struct {
+		packages []Package
+		want     string
+	}{
+		{[]Package{{Name: "gcc"}, {Name: "make"}}, "sudo dnf install -y 'gcc' 'make'"},
+		{[]Package{{Name: "@development-tools"}}, "sudo dnf group install -y 'development-tools'"},
+		{[]Package{{Name: "gcc"}, {Name: "@development-tools"}}, "sudo dnf install -y 'gcc' && sudo dnf group install -y 'development-tools'"},
+	}
+	for _, test := range tests {
+		if got := (dnfManager{}).InstallCommand(test.packages...); got != test.want {
+			t.Errorf("got %q, want %q", got, test.want)
+		}
+	}
+
+	for name, valid := range map[string]bool{"@development-tools": true, "gcc-c++": true, "@-y": false, "@@x": false, "-y": false} {
+		if (dnfManager{}).ValidName(name) != valid {
+			t.Errorf("ValidName(%q) is %v", name, !valid)
+		}
+	}
+}
@@ -2,48 +2,113 @@ package utils
 
 import (
 	"log"
+	"os"
 	"os/exec"
 	"strings"
 )
 
-// FetchPackages returns a list of installed packages for the given base distro.
-func FetchPackages(baseDistro string) []string {
-	var cmd *exec.Cmd
-	var cmdYay *exec.Cmd
-	switch baseDistro {
-	case "debian":
-		cmd = exec.Command("dpkg", "--get-selections")
-	case "arch":
-		cmd = exec.Command("pacman", "-Qn")
-		cmdYay = exec.Command("pacman", "-Qm")
-	case "rhel", "fedora":
-		cmd = exec.Command("rpm", "-qa")
-	case "void":
-		cmd = exec.Command("xbps-query", "-l")
-	default:
+// FetchPackages returns the installed packages for the given base distro.
+// With explicitOnly, packages that were only pulled in as dependencies are left out.
+func FetchPackages(baseDistro string, explicitOnly bool) []Package {
+	manager := ManagerFor(baseDistro)
+	if manager == nil {
 		log.Println("Your distro is unsupported, cannot identify package manager!")
-		return []string{"unknown"}
+		return nil
 	}
+	list := manager.ListInstalled
+	if explicitOnly {
+		list = manager.ListExplicit
+	}
+	packages, err := list()
+	if err != nil {
+		log.Println("Error in retrieving packages:", err)
+	}
+	return packages
+}
+
+// explicitPackages keeps explicitly installed packages and those whose reason is unknown.
+func explicitPackages(packages []Package) []Package {
+	var explicit []Package
+	unknown := 0
+	for _, pkg := range packages {
+		switch pkg.Reason {
+		case ReasonExplicit:
+			explicit = append(explicit, pkg)
+		case ReasonDependency:
+		default:
+			unknown++
+			explicit = append(explicit, pkg)
+		}
+	}
+	if unknown > 0 {
+		log.Printf("Install reason unknown for %d packages, keeping them all\n", unknown)
+	}
+	return explicit
+}
 
-	if baseDistro != "arch" {
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			log.Println("Error in retrieving packages:", err)
+// markReasons sets every package's reason from the names the manager reports as user installed.
+func markReasons(packages []Package, explicitNames []string) {
+	explicit := make(map[string]bool)
+	for _, name := range explicitNames {
+		explicit[name] = true
+	}
+	for i := range packages {
+		pkg := &packages[i]
+		// apt-mark adds the architecture to packages of a foreign architecture
+		if explicit[pkg.Name] || explicit[pkg.Name+":"+pkg.Arch] {
+			pkg.Reason = ReasonExplicit
+		} else {
+			pkg.Reason = ReasonDependency
 		}
-		return strings.Split(strings.TrimSpace(string(output)), "\n")
 	}
+}
 
-	outputPacman, err := cmd.CombinedOutput()
-	outPutYay, errYay := cmdYay.CombinedOutput()
+// the installed version of an rpm package in the format listRPM records, and the version
+// comparison built into rpm's lua, which prints -1 when the second version is newer.
+// The versions are passed through the environment so they are never parsed as macros.
+const (
+	rpmInstalledVersion = `rpm -q --queryformat '%{EPOCHNUM}:%{VERSION}-%{RELEASE}\n' "$1" 2>/dev/null | head -n 1 | sed 's/^0://'`
+	rpmOlderVersion     = `[ "$(older="$1" newer="$2" rpm --eval '%{lua: print(rpm.vercmp(os.getenv("older"), os.getenv("newer")))}')" = -1 ]`
+)
+
+// listRPM lists installed packages, the version includes release and a non-zero epoch.
+func listRPM() ([]Package, error) {
+	out, err := runQuery("rpm", "-qa", "--queryformat", "%{NAME}\t%{EPOCHNUM}:%{VERSION}-%{RELEASE}\t%{ARCH}\n")
 	if err != nil {
-		log.Println("Error in retrieving Pacman packages:", err)
+		return nil, err
+	}
+	var packages []Package
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, "\t")
+		// imported signing keys show up as gpg-pubkey packages
+		if len(fields) != 3 || fields[0] == "gpg-pubkey" {
+			continue
+		}
+		packages = append(packages, Package{
+			Name:    fields[0],
+			Version: strings.TrimPrefix(fields[1], "0:"),
+			Arch:    fields[2],
+		})
 	}
-	if errYay != nil {
-		log.Println("Error in retrieving Yay packages:", errYay)
+	return packages, nil
+}
+
+// runQuery runs a package manager query with the C locale so its output can be parsed.
+func runQuery(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Env = append(os.Environ(), "LC_ALL=C")
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// splitLines returns the non-empty lines of command output.
+func splitLines(out string) []string {
+	var lines []string
+	for _, line := range strings.Split(out, "\n") {
+		// only blank lines go, tab separated output may end in an empty field
+		if strings.TrimSpace(line) != "" {
+			lines = append(lines, strings.TrimRight(line, "\r"))
+		}
 	}
-	pacmanPackages := strings.Split(strings.TrimSpace(string(outputPacman)), "\n")
-	yayPackages := strings.Split(strings.TrimSpace(string(outPutYay)), "\n")
-	// Mark the split between official and AUR packages
-	yayPackages = append([]string{"YayPackages"}, yayPackages...)
-	return append(pacmanPackages, yayPackages...)
-}
\ No newline at end of file
+	return lines
+}
@@ -0,0 +1,171 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// stubQuery puts a command first on PATH that prints the captured output stored for its
+// arguments and fails for any other arguments
+func stubQuery(t *testing.T, name string, outputs map[string]string) {
+	t.Helper()
+	dir := filepath.Join(t.TempDir(), name)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	script := "#!/bin/sh\ncase \"$*\" in\n"
+	i := 0
+	for args, output := range outputs {
+		file := filepath.Join(dir, "output"+string(rune('a'+i)))
+		i++
+		if err := os.WriteFile(file, []byte(output), 0644); err != nil {
+			t.Fatal(err)
+		}
+		script += "'" + args + "') cat '" + file + "' ;;\n"
+	}
+	script += "*) exit 1 ;;\nesac\n"
+	if err := os.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+// pacman -Qi output, descriptions and optional dependencies wrap onto continuation lines
+const pacmanInfo = `Name            : git
+Version         : 2.45.2-1
+Description     : the fast distributed version control system
+Architecture    : x86_64
+URL             : https://git-scm.com/
+Optional Deps   : tk: gitk and git gui
+                  openssh: ssh transport and crypto [installed]
+Install Reason  : Explicitly installed
+
+Name            : yay
+Version         : 12.3.5-1
+Description     : Yet another yogurt. Pacman wrapper : AUR helper
+Architecture    : x86_64
+Install Reason  : Explicitly installed
+
+Name            : zlib
+Version         : 1:1.3.1-2
+Architecture    : x86_64
+Install Reason  : Installed as a dependency for another package
+`
+
+func TestParseInfoBlocks(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []map[string]string
+	}{
+		{"blocks", pacmanInfo, []map[string]string{
+			{"Name": "git", "Version": "2.45.2-1", "Description": "the fast distributed version control system",
+				"Architecture": "x86_64", "URL": "https://git-scm.com/", "Optional Deps": "tk: gitk and git gui",
+				"Install Reason": "Explicitly installed"},
+			{"Name": "yay", "Version": "12.3.5-1", "Description": "Yet another yogurt. Pacman wrapper : AUR helper",
+				"Architecture": "x86_64", "Install Reason": "Explicitly installed"},
+			{"Name": "zlib", "Version": "1:1.3.1-2", "Architecture": "x86_64",
+				"Install Reason": "Installed as a dependency for another package"},
+		}},
+		{"no trailing newline", "Name : vim\nVersion : 9.1", []map[string]string{{"Name": "vim", "Version": "9.1"}}},
+		{"extra blank lines", "\n\nName : vim\n\n\n\nName : nano\n\n", []map[string]string{{"Name": "vim"}, {"Name": "nano"}}},
+		{"empty", "", nil},
+	}
+	for _, test := range tests {
+		if got := parseInfoBlocks(test.out); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFetchPacman(t *testing.T) {
+	stubQuery(t, "pacman", map[string]string{
+		"-Qi":  pacmanInfo,
+		"-Qqm": "yay\n",
+		"-Sl":  "extra git 2.45.2-1 [installed]\ncore zlib 1:1.3.1-2 [installed]\nextra vim 9.1-1\n",
+	})
+	packages, err := fetchPacman()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{
+		{Name: "git", Version: "2.45.2-1", Arch: "x86_64", Repository: "extra", Reason: ReasonExplicit},
+		{Name: "yay", Version: "12.3.5-1", Arch: "x86_64", Repository: RepositoryAUR, Reason: ReasonExplicit},
+		{Name: "zlib", Version: "1:1.3.1-2", Arch: "x86_64", Repository: "core", Reason: ReasonDependency},
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("got %+v, want %+v", packages, want)
+	}
+}
+
+func TestListRPM(t *testing.T) {
+	stubQuery(t, "rpm", map[string]string{
+		"-qa --queryformat %{NAME}\t%{EPOCHNUM}:%{VERSION}-%{RELEASE}\t%{ARCH}\n": strings.Join([]string{
+			"bash\t0:5.2.26-3.fc40\tx86_64",
+			"gpg-pubkey\t0:a15b79cc-63d04c2c\t(none)",
+			"perl-Git\t4:2.45.2-2.fc40\tnoarch",
+			"not a package line",
+		}, "\n") + "\n",
+	})
+	packages, err := listRPM()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{
+		{Name: "bash", Version: "5.2.26-3.fc40", Arch: "x86_64"},
+		{Name: "perl-Git", Version: "4:2.45.2-2.fc40", Arch: "noarch"},
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("got %+v, want %+v", packages, want)
+	}
+}
+
+func TestFetchDpkg(t *testing.T) {
+	stubQuery(t, "dpkg-query", map[string]string{
+		"-W -f ${db:Status-Abbrev}\t${Package}\t${Version}\t${Architecture}\n": strings.Join([]string{
+			"ii \tvim\t2:9.1.0016-1\tamd64",
+			"rc \told-package\t1.0\tamd64",
+			"ii \tlibc6\t2.39-0ubuntu8\ti386",
+			"ii \tlibc6\t2.39-0ubuntu8\tamd64",
+			"ii \tca-certificates\t20240203\tall",
+		}, "\n") + "\n",
+	})
+	stubQuery(t, "apt-mark", map[string]string{"showmanual": "vim\nlibc6:i386\n"})
+	packages, err := fetchDpkg()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{
+		{Name: "vim", Version: "2:9.1.0016-1", Arch: "amd64", Reason: ReasonExplicit},
+		{Name: "libc6", Version: "2.39-0ubuntu8", Arch: "i386", Reason: ReasonExplicit},
+		{Name: "libc6", Version: "2.39-0ubuntu8", Arch: "amd64", Reason: ReasonDependency},
+		{Name: "ca-certificates", Version: "20240203", Arch: "all", Reason: ReasonDependency},
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("got %+v, want %+v", packages, want)
+	}
+}
+
+func TestExplicitPackages(t *testing.T) {
+	tests := []struct {
+		name     string
+		packages []Package
+		want     []string
+	}{
+		{"dependencies are dropped", []Package{{Name: "vim", Reason: ReasonExplicit}, {Name: "libc6", Reason: ReasonDependency}}, []string{"vim"}},
+		{"unknown reasons are kept", []Package{{Name: "vim"}, {Name: "libc6", Reason: ReasonDependency}, {Name: "git"}}, []string{"vim", "git"}},
+		{"nothing explicit", []Package{{Name: "libc6", Reason: ReasonDependency}}, nil},
+	}
+	for _, test := range tests {
+		var got []string
+		for _, pkg := range explicitPackages(test.packages) {
+			got = append(got, pkg.Name)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Package name grammars of each manager. None lets a name start with "-", which the
+// manager would take for an option.
+var (
+	// Debian policy 5.6.1, dpkg adds ":arch" to multiarch names
+	debianName = regexp.MustCompile(`^[a-z0-9][a-z0-9+.-]+(:[a-z0-9-]+)?$`)
+	// pacman forbids a leading dot or hyphen
+	pacmanName = regexp.MustCompile(`^[a-z0-9@_+][a-z0-9@._+-]*$`)
+	// rpm, xbps and apk names
+	plainName = regexp.MustCompile(`^[A-Za-z0-9_+][A-Za-z0-9._+-]*$`)
+	// rpm names and dnf comps groups, "@development-tools"
+	dnfName = regexp.MustCompile(`^@?[A-Za-z0-9_+][A-Za-z0-9._+-]*$`)
+	// category/package, PMS 3.1
+	portageName = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9+_.-]*/[A-Za-z0-9_][A-Za-z0-9+_-]*$`)
+	// nixpkgs attributes and derivation names like python3.12-requests
+	nixName = regexp.MustCompile(`^[A-Za-z0-9_+][A-Za-z0-9._+'-]*$`)
+
+	// versions of every manager, Debian epochs and tildes, rpm carets, Portage revisions
+	packageVersion = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.+~:_^-]*$`)
+)
+
+// ValidatePackage checks a package's name against its manager's grammar, and its version
+// when it has one.
+func ValidatePackage(manager PackageManager, pkg Package) error {
+	if !manager.ValidName(pkg.Name) {
+		return fmt.Errorf("%q is not a valid %s package name", pkg.Name, manager.Name())
+	}
+	if pkg.Version != "" && !packageVersion.MatchString(pkg.Version) {
+		return fmt.Errorf("%q is not a valid version of %s", pkg.Version, pkg.Name)
+	}
+	return nil
+}
+
+// SafeArgument tells whether a value can be passed to a command as a single operand: it is
+// not empty, does not start with "-" and holds no control characters.
+func SafeArgument(value string) bool {
+	if value == "" || strings.HasPrefix(value, "-") {
+		return false
+	}
+	for _, r := range value {
+		if r < 0x20 || r == 0x7f {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,112 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+func init() { RegisterManager(nixManager{}) }
+
+// nixManager handles NixOS. Profile packages are installed with nix-env, system packages
+// are declared in configuration.nix.
+type nixManager struct{}
+
+func (nixManager) Name() string       { return "nix" }
+func (nixManager) Families() []string { return []string{"nixos"} }
+
+// Detect looks for NixOS itself, nix alone is also used on other distros
+func (nixManager) Detect() bool {
+	_, err := os.Stat("/etc/NIXOS")
+	return err == nil || commandExists("nixos-rebuild")
+}
+
+func (nixManager) ListInstalled() ([]Package, error) { return fetchNix() }
+
+func (m nixManager) ListExplicit() ([]Package, error) { return listExplicit(m) }
+
+// InstallCommand installs attributes of the nixos channel into the user's profile.
+func (nixManager) InstallCommand(packages ...Package) string {
+	attributes := make([]string, len(packages))
+	for i, pkg := range packages {
+		attributes[i] = "nixos." + pkg.Name
+	}
+	return installCommand("nix-env -iA", attributes)
+}
+
+// Available evaluates the attribute in the nixos channel
+func (nixManager) Available(name string) (bool, error) {
+	return queryExits("nix-env", "-qaA", "nixos."+name)
+}
+
+// InstalledCheck looks for a derivation of that name in the user's profile
+func (nixManager) InstalledCheck() string {
+	return `nix-env -q "$1" >/dev/null 2>&1`
+}
+
+// InstalledVersion strips the name off the derivation name in the user's profile
+func (nixManager) InstalledVersion() string {
+	return `drv="$(nix-env -q "$1" 2>/dev/null | head -n 1)" && [ -n "$drv" ] && echo "${drv#"$1"-}"`
+}
+
+func (nixManager) OlderVersion() string {
+	return `[ "$(nix-instantiate --eval --argstr a "$1" --argstr b "$2" -E '{ a, b }: builtins.compareVersions a b')" = -1 ]`
+}
+
+func (nixManager) ValidName(name string) bool { return nixName.MatchString(name) }
+
+// fetchNix lists the packages of the NixOS system profile and of the user's nix-env profile.
+// System packages are declared in configuration.nix and carry no install reason.
+func fetchNix() ([]Package, error) {
+	out, err := runQuery("nix-store", "--query", "--references", "/run/current-system/sw")
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	for _, path := range splitLines(out) {
+		name, version := splitDrvName(strings.TrimSpace(path))
+		if name == "" {
+			continue
+		}
+		packages = append(packages, Package{Name: name, Version: version, Repository: RepositoryNixSystem})
+	}
+
+	out, err = runQuery("nix-env", "--query", "--json")
+	if err != nil {
+		log.Println("Cannot read the user's nix profile:", err)
+		return packages, nil
+	}
+	var profile map[string]struct {
+		Pname   string `json:"pname"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(out), &profile); err != nil {
+		return packages, err
+	}
+	var installed []Package
+	for _, pkg := range profile {
+		installed = append(installed, Package{Name: pkg.Pname, Version: pkg.Version, Repository: RepositoryNixProfile, Reason: ReasonExplicit})
+	}
+	sort.Slice(installed, func(i, j int) bool { return installed[i].Name < installed[j].Name })
+	return append(packages, installed...), nil
+}
+
+// splitDrvName splits a store path like /nix/store/<hash>-git-2.44.0 into name and version,
+// the version starts at the first dash that is not followed by a letter.
+func splitDrvName(path string) (string, string) {
+	base := filepath.Base(path)
+	_, drv, ok := strings.Cut(base, "-")
+	if !ok {
+		return "", ""
+	}
+	for i := 0; i < len(drv)-1; i++ {
+		if drv[i] == '-' && !unicode.IsLetter(rune(drv[i+1])) {
+			return drv[:i], drv[i+1:]
+		}
+	}
+	return drv, ""
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSplitDrvName(t *testing.T) {
+	tests := []struct {
+		path          string
+		name, version string
+	}{
+		{"/nix/store/0a1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p-git-2.44.0", "git", "2.44.0"},
+		{"/nix/store/0a1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p-xdg-utils-1.2.1", "xdg-utils", "1.2.1"},
+		{"/nix/store/0a1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p-python3-3.11.9-env", "python3", "3.11.9-env"},
+		{"/nix/store/0a1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p-nixos-help", "nixos-help", ""},
+		{"/nix/store/0a1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p-fc-cache-unstable-2024-01-01", "fc-cache-unstable", "2024-01-01"},
+		{"/nix/store/nohash", "", ""},
+	}
+	for _, test := range tests {
+		if name, version := splitDrvName(test.path); name != test.name || version != test.version {
+			t.Errorf("%s: got %q %q, want %q %q", test.path, name, version, test.name, test.version)
+		}
+	}
+}
+
+func TestFetchNix(t *testing.T) {
+	stubQuery(t, "nix-store", map[string]string{
+		"--query --references /run/current-system/sw": "/nix/store/0a1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p-git-2.44.0\n" +
+			"/nix/store/1b2c3d4e5f6g7h8i9j0k1l2m3n4o5p6q-nixos-help\n",
+	})
+	stubQuery(t, "nix-env", map[string]string{
+		"--query --json": `{"ripgrep-14.1.0": {"pname": "ripgrep", "version": "14.1.0"},
+			"bat-0.24.0": {"pname": "bat", "version": "0.24.0"}}`,
+	})
+	packages, err := fetchNix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{
+		{Name: "git", Version: "2.44.0", Repository: RepositoryNixSystem},
+		{Name: "nixos-help", Repository: RepositoryNixSystem},
+		{Name: "bat", Version: "0.24.0", Repository: RepositoryNixProfile, Reason: ReasonExplicit},
+		{Name: "ripgrep", Version: "14.1.0", Repository: RepositoryNixProfile, Reason: ReasonExplicit},
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("got %+v, want %+v", packages, want)
+	}
+}
@@ -0,0 +1,25 @@
+package utils
+
+// Package is one installed package as recorded in package.json.
+type Package struct {
+	Name       string `json:"name"`
+	Version    string `json:"version,omitempty"`
+	Arch       string `json:"arch,omitempty"`
+	Repository string `json:"repository,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+// Install reasons, left empty when the package manager does not track them.
+const (
+	ReasonExplicit   = "explicit"
+	ReasonDependency = "dependency"
+)
+
+// RepositoryAUR marks Arch packages that are not in any sync repository.
+const RepositoryAUR = "aur"
+
+// Nix packages either come from the NixOS system configuration or the user's profile.
+const (
+	RepositoryNixSystem  = "nixos-system"
+	RepositoryNixProfile = "nix-profile"
+)
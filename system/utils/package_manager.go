@@ -0,0 +1,151 @@
+package utils
+
+import (
+	"os/exec"
+	"sort"
+	"strings"
+)
+
+// PackageManager is the backend of one distro family's package manager. Each backend
+// registers itself from an init function in its own file.
+type PackageManager interface {
+	// Name is the manager's command name, e.g. "apt".
+	Name() string
+	// Families are the base distros the manager serves, the first one is canonical.
+	Families() []string
+	// Detect tells whether the manager is installed on this system.
+	Detect() bool
+	// ListInstalled returns all installed packages, with install reasons when known.
+	ListInstalled() ([]Package, error)
+	// ListExplicit returns the packages the user asked for.
+	ListExplicit() ([]Package, error)
+	// InstallCommand renders a shell command installing the packages.
+	InstallCommand(packages ...Package) string
+	// Available tells whether a package of that name can be installed from the
+	// configured repositories.
+	Available(name string) (bool, error)
+	// InstalledCheck renders a shell command that succeeds when the package named "$1"
+	// is installed.
+	InstalledCheck() string
+	// ValidName tells whether a name follows the manager's package name grammar.
+	ValidName(name string) bool
+}
+
+// SourceManager is implemented by managers that can collect their third-party repositories.
+type SourceManager interface {
+	FetchSources(packages []Package) (*Sources, error)
+	// RefreshCommand renders the command that loads newly added repositories.
+	RefreshCommand() string
+}
+
+// VersionPinner is implemented by managers that can install given versions of packages.
+type VersionPinner interface {
+	// PinnedInstallCommand renders a shell command installing the packages at their
+	// recorded versions, packages without one at the latest.
+	PinnedInstallCommand(packages ...Package) string
+}
+
+// VersionComparer is implemented by managers that can compare installed versions with
+// recorded ones.
+type VersionComparer interface {
+	// InstalledVersion renders a shell command printing the installed version of the
+	// package named "$1", in the format ListInstalled records.
+	InstalledVersion() string
+	// OlderVersion renders a shell command that succeeds when version "$1" is older than
+	// version "$2".
+	OlderVersion() string
+}
+
+// Bootstrapper is implemented by managers that need helpers set up before some packages
+// can be installed, like an AUR helper.
+type Bootstrapper interface {
+	Bootstrap(packages []Package) string
+}
+
+var managers = make(map[string]PackageManager)
+
+// RegisterManager makes a backend available for the families it serves.
+func RegisterManager(manager PackageManager) {
+	for _, family := range manager.Families() {
+		managers[family] = manager
+	}
+}
+
+// ManagerFor returns the backend of a base distro, nil when unsupported.
+func ManagerFor(baseDistro string) PackageManager {
+	return managers[baseDistro]
+}
+
+// Managers returns every registered backend once, ordered by name.
+func Managers() []PackageManager {
+	seen := make(map[string]bool)
+	var list []PackageManager
+	for _, manager := range managers {
+		if !seen[manager.Name()] {
+			seen[manager.Name()] = true
+			list = append(list, manager)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list
+}
+
+// listExplicit is the ListExplicit of managers that record install reasons in ListInstalled
+func listExplicit(manager PackageManager) ([]Package, error) {
+	packages, err := manager.ListInstalled()
+	return explicitPackages(packages), err
+}
+
+// commandExists tells whether a command is on PATH
+func commandExists(name string) bool {
+	_, err := exec.LookPath(name)
+	return err == nil
+}
+
+// queryExits tells whether a query command succeeds, a non-zero exit means no
+func queryExits(name string, args ...string) (bool, error) {
+	if !commandExists(name) {
+		return false, exec.ErrNotFound
+	}
+	_, err := runQuery(name, args...)
+	if _, ok := err.(*exec.ExitError); ok {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// installCommand joins an install command prefix and the quoted package names
+func installCommand(prefix string, names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = ShellQuote(name)
+	}
+	return prefix + " " + strings.Join(quoted, " ")
+}
+
+// packageNames returns the names of packages
+func packageNames(packages []Package) []string {
+	names := make([]string, len(packages))
+	for i, pkg := range packages {
+		names[i] = pkg.Name
+	}
+	return names
+}
+
+// pinnedNames joins name and version with the separator the manager expects, packages
+// without a version keep their bare name
+func pinnedNames(packages []Package, separator string) []string {
+	names := make([]string, len(packages))
+	for i, pkg := range packages {
+		names[i] = pkg.Name
+		if pkg.Version != "" {
+			names[i] += separator + pkg.Version
+		}
+	}
+	return names
+}
+
+// ShellQuote wraps a value in single quotes for bash
+func ShellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
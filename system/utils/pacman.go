@@ -0,0 +1,368 @@
+package utils
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() { RegisterManager(pacmanManager{}) }
+
+// pacmanManager handles Arch and its derivatives, AUR packages are installed with an AUR
+// helper, yay unless another one is set.
+type pacmanManager struct {
+	helper string
+}
+
+// AURHelpers are the helpers sysreplicate can detect and bootstrap, each is built from the
+// AUR package of the same name and installs packages with "-S --noconfirm".
+var AURHelpers = []string{"yay", "paru", "pikaur", "trizen"}
+
+// AURManager is implemented by managers that install AUR packages through a helper.
+type AURManager interface {
+	// WithAURHelper returns the manager using that helper, unknown helpers are ignored.
+	WithAURHelper(helper string) PackageManager
+}
+
+// DetectAURHelper returns the first AUR helper installed on this system, "" when none is.
+func DetectAURHelper() string {
+	for _, helper := range AURHelpers {
+		if commandExists(helper) {
+			return helper
+		}
+	}
+	return ""
+}
+
+func (m pacmanManager) WithAURHelper(helper string) PackageManager {
+	for _, known := range AURHelpers {
+		if helper == known {
+			m.helper = helper
+		}
+	}
+	return m
+}
+
+func (m pacmanManager) aurHelper() string {
+	if m.helper == "" {
+		return AURHelpers[0]
+	}
+	return m.helper
+}
+
+func (pacmanManager) Name() string       { return "pacman" }
+func (pacmanManager) Families() []string { return []string{"arch"} }
+func (pacmanManager) Detect() bool       { return commandExists("pacman") }
+
+func (pacmanManager) ListInstalled() ([]Package, error) { return fetchPacman() }
+
+func (m pacmanManager) ListExplicit() ([]Package, error) { return listExplicit(m) }
+
+// InstallCommand installs official packages with pacman and AUR packages with the helper, as
+// the user Bootstrap picked.
+func (m pacmanManager) InstallCommand(packages ...Package) string {
+	var official, aur []string
+	for _, pkg := range packages {
+		if pkg.Repository == RepositoryAUR {
+			aur = append(aur, pkg.Name)
+		} else {
+			official = append(official, pkg.Name)
+		}
+	}
+	var commands []string
+	if len(official) > 0 {
+		commands = append(commands, installCommand("sudo pacman -S --noconfirm", official))
+	}
+	if len(aur) > 0 {
+		commands = append(commands, installCommand(`"${aur_run[@]}" `+m.aurHelper()+" -S --noconfirm", aur))
+	}
+	return strings.Join(commands, " && ")
+}
+
+// Available looks the name up in the sync repositories, AUR packages are not found
+func (pacmanManager) Available(name string) (bool, error) {
+	return queryExits("pacman", "-Si", name)
+}
+
+// InstalledCheck asks the local database, which knows official and AUR packages alike
+func (pacmanManager) InstalledCheck() string {
+	return `pacman -Q "$1" >/dev/null 2>&1`
+}
+
+// InstalledVersion prints the version from the local database, "name version"
+func (pacmanManager) InstalledVersion() string {
+	return `pacman -Q "$1" 2>/dev/null | awk '{ print $2 }'`
+}
+
+func (pacmanManager) OlderVersion() string {
+	return `[ "$(vercmp "$1" "$2")" -lt 0 ]`
+}
+
+func (pacmanManager) ValidName(name string) bool { return pacmanName.MatchString(name) }
+
+// Bootstrap builds the AUR helper when there are AUR packages and it is missing. The helper
+// itself comes from the AUR, so it is built with makepkg, which refuses to run as root: a
+// script run through sudo builds as the invoking user.
+func (m pacmanManager) Bootstrap(packages []Package) string {
+	for _, pkg := range packages {
+		if pkg.Repository == RepositoryAUR {
+			return strings.ReplaceAll(aurHelperBootstrap, "HELPER", m.aurHelper())
+		}
+	}
+	return ""
+}
+
+// aur_run runs the helper and makepkg as the user who ran sudo setup.sh, as neither
+// works as root
+const aurHelperBootstrap = `aur_user="$(id -un)"
+aur_run=()
+if [ "$(id -u)" -eq 0 ] && [ -n "${SUDO_USER:-}" ]; then
+  aur_user="$SUDO_USER"
+  aur_run=(sudo -u "$SUDO_USER")
+fi
+if ! command -v HELPER >/dev/null; then
+  echo 'HELPER not found, building it from the AUR...'
+  if [ "$aur_user" = root ]; then
+    echo 'makepkg does not run as root, run setup.sh as a user with sudo rights to build HELPER'
+    install_failed 'AUR helper HELPER'
+  elif sudo pacman -S --needed --noconfirm base-devel git; then
+    aur_build="$(mktemp -d)"
+    [ "$aur_user" = "$(id -un)" ] || chown "$aur_user" "$aur_build"
+    if ! { "${aur_run[@]}" git clone https://aur.archlinux.org/HELPER.git "$aur_build/HELPER" &&
+      (cd "$aur_build/HELPER" && "${aur_run[@]}" makepkg -si --noconfirm); }; then
+      install_failed 'AUR helper HELPER'
+    fi
+    rm -rf "$aur_build"
+  else
+    install_failed 'AUR helper HELPER'
+  fi
+fi
+`
+
+func (pacmanManager) FetchSources(packages []Package) (*Sources, error) {
+	return fetchPacmanSources()
+}
+
+func (pacmanManager) RefreshCommand() string { return "sudo pacman -Sy" }
+
+// fetchPacman lists packages from pacman's local database, foreign ones are marked as AUR.
+func fetchPacman() ([]Package, error) {
+	out, err := runQuery("pacman", "-Qi")
+	if err != nil {
+		return nil, err
+	}
+	foreign, err := runQuery("pacman", "-Qqm")
+	if err != nil {
+		// pacman exits non-zero when there are no foreign packages
+		foreign = ""
+	}
+	isForeign := make(map[string]bool)
+	for _, name := range splitLines(foreign) {
+		isForeign[name] = true
+	}
+
+	// sync repositories know which repo each native package came from
+	repos := make(map[string]string)
+	if synced, err := runQuery("pacman", "-Sl"); err == nil {
+		for _, line := range splitLines(synced) {
+			if fields := strings.Fields(line); len(fields) >= 2 {
+				repos[fields[1]] = fields[0]
+			}
+		}
+	}
+
+	var packages []Package
+	for _, info := range parseInfoBlocks(out) {
+		pkg := Package{
+			Name:       info["Name"],
+			Version:    info["Version"],
+			Arch:       info["Architecture"],
+			Repository: repos[info["Name"]],
+		}
+		if pkg.Name == "" {
+			continue
+		}
+		if isForeign[pkg.Name] {
+			pkg.Repository = RepositoryAUR
+		}
+		switch {
+		case strings.HasPrefix(info["Install Reason"], "Explicitly"):
+			pkg.Reason = ReasonExplicit
+		case strings.HasPrefix(info["Install Reason"], "Installed as a dependency"):
+			pkg.Reason = ReasonDependency
+		}
+		packages = append(packages, pkg)
+	}
+	return packages, nil
+}
+
+// parseInfoBlocks parses "Key : Value" blocks separated by blank lines, as printed by pacman -Qi.
+func parseInfoBlocks(out string) []map[string]string {
+	var blocks []map[string]string
+	current := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			if len(current) > 0 {
+				blocks = append(blocks, current)
+				current = make(map[string]string)
+			}
+			continue
+		}
+		key, value, ok := strings.Cut(line, " : ")
+		if !ok {
+			continue // continuation of a wrapped value
+		}
+		current[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if len(current) > 0 {
+		blocks = append(blocks, current)
+	}
+	return blocks
+}
+
+// repositories of Arch Linux itself, everything else in pacman.conf is third-party
+var officialPacmanRepos = map[string]bool{
+	"options": true, "core": true, "extra": true, "multilib": true, "community": true,
+	"testing": true, "core-testing": true, "extra-testing": true, "multilib-testing": true,
+	"community-testing": true, "gnome-unstable": true, "kde-unstable": true,
+}
+
+// fetchPacmanSources reads the custom repositories of pacman.conf and the keys added to
+// pacman's keyring that do not come from the distro keyrings.
+func fetchPacmanSources() (*Sources, error) {
+	data, err := os.ReadFile("/etc/pacman.conf")
+	if err != nil {
+		return nil, err
+	}
+	sources := &Sources{Manager: "pacman"}
+	for _, section := range parsePacmanSections(string(data)) {
+		if officialPacmanRepos[section.Name] {
+			continue
+		}
+		section.Path = "/etc/pacman.conf"
+		sources.Repositories = append(sources.Repositories, section)
+	}
+	if len(sources.Repositories) > 0 {
+		sources.Keys = pacmanKeys()
+	}
+	return sources, nil
+}
+
+// parsePacmanSections splits pacman.conf into sections. Servers from included files other
+// than the distro mirrorlist are written into the section so it stands on its own.
+func parsePacmanSections(data string) []Repository {
+	var repos []Repository
+	var current *Repository
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			name := strings.Trim(line, "[]")
+			repos = append(repos, Repository{Name: name, Definition: line + "\n"})
+			current = &repos[len(repos)-1]
+			continue
+		}
+		if current == nil {
+			continue
+		}
+		key, value, _ := strings.Cut(line, "=")
+		key, value = strings.TrimSpace(key), strings.TrimSpace(value)
+		switch {
+		case key == "Server":
+			current.URIs = append(current.URIs, value)
+		case key == "Include" && value != "/etc/pacman.d/mirrorlist":
+			included, err := os.ReadFile(value)
+			if err != nil {
+				break
+			}
+			for _, server := range parsePacmanSections("[include]\n" + string(included))[0].URIs {
+				current.URIs = append(current.URIs, server)
+				current.Definition += "Server = " + server + "\n"
+			}
+			continue
+		}
+		current.Definition += line + "\n"
+	}
+	return repos
+}
+
+// pacmanKeys exports the keys in pacman's keyring that none of the distro keyrings ship.
+func pacmanKeys() []SigningKey {
+	distro := make(map[string]bool)
+	keyrings, _ := filepath.Glob("/usr/share/pacman/keyrings/*.gpg")
+	for _, keyring := range keyrings {
+		out, err := runQuery("gpg", "--batch", "--with-colons", "--show-keys", keyring)
+		if err != nil {
+			continue
+		}
+		for _, fingerprint := range keyFingerprints(out) {
+			distro[fingerprint] = true
+		}
+	}
+
+	out, err := runQuery("gpg", "--homedir", "/etc/pacman.d/gnupg", "--batch", "--with-colons", "--list-keys")
+	if err != nil {
+		log.Println("Cannot read pacman's keyring:", err)
+		return nil
+	}
+	var keys []SigningKey
+	for _, fingerprint := range keyFingerprints(out) {
+		// the local master key only exists on this machine
+		if distro[fingerprint] || isMasterKey(out, fingerprint) {
+			continue
+		}
+		data, err := runQuery("gpg", "--homedir", "/etc/pacman.d/gnupg", "--batch", "--armor", "--export", fingerprint)
+		if err != nil || data == "" {
+			continue
+		}
+		keys = append(keys, SigningKey{Fingerprint: fingerprint, Data: []byte(data)})
+	}
+	return keys
+}
+
+// keyFingerprints returns the primary key fingerprints of gpg --with-colons output
+func keyFingerprints(out string) []string {
+	var fingerprints []string
+	primary := false
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, ":")
+		switch fields[0] {
+		case "pub":
+			primary = true
+		case "sub":
+			primary = false
+		case "fpr":
+			if primary && len(fields) > 9 {
+				fingerprints = append(fingerprints, fields[9])
+				primary = false
+			}
+		}
+	}
+	return fingerprints
+}
+
+// isMasterKey tells whether the key with the fingerprint carries the local master key's user id
+func isMasterKey(out, fingerprint string) bool {
+	current := ""
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, ":")
+		switch {
+		case fields[0] == "fpr" && current == "" && len(fields) > 9:
+			current = fields[9]
+		case fields[0] == "pub":
+			current = ""
+		case fields[0] == "uid" && current == fingerprint && len(fields) > 9:
+			if strings.Contains(fields[9], "Pacman Keyring Master Key") {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,87 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParsePacmanSections(t *testing.T) {
+	included := filepath.Join(t.TempDir(), "chaotic-mirrorlist")
+	if err := os.WriteFile(included, []byte("# mirrors\nServer = https://cdn-mirror.chaotic.cx/$repo/$arch\n\nServer = https://geo-mirror.chaotic.cx/$repo/$arch\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		data string
+		want []Repository
+	}{
+		{"options and distro repositories",
+			"# pacman.conf\n[options]\nHoldPkg = pacman glibc\nArchitecture = auto\n\n[core]\nInclude = /etc/pacman.d/mirrorlist\n",
+			[]Repository{
+				{Name: "options", Definition: "[options]\nHoldPkg = pacman glibc\nArchitecture = auto\n"},
+				{Name: "core", Definition: "[core]\nInclude = /etc/pacman.d/mirrorlist\n"},
+			}},
+		{"custom server",
+			"[archlinuxcn]\nSigLevel = Optional TrustAll\nServer = https://repo.archlinuxcn.org/$arch\n",
+			[]Repository{{Name: "archlinuxcn", URIs: []string{"https://repo.archlinuxcn.org/$arch"},
+				Definition: "[archlinuxcn]\nSigLevel = Optional TrustAll\nServer = https://repo.archlinuxcn.org/$arch\n"}}},
+		{"included servers are written into the section",
+			"[chaotic-aur]\nInclude = " + included + "\n",
+			[]Repository{{Name: "chaotic-aur",
+				URIs:       []string{"https://cdn-mirror.chaotic.cx/$repo/$arch", "https://geo-mirror.chaotic.cx/$repo/$arch"},
+				Definition: "[chaotic-aur]\nServer = https://cdn-mirror.chaotic.cx/$repo/$arch\nServer = https://geo-mirror.chaotic.cx/$repo/$arch\n"}}},
+		{"unreadable include is kept as it is",
+			"[custom]\nInclude = /nonexistent/mirrorlist\n",
+			[]Repository{{Name: "custom", Definition: "[custom]\nInclude = /nonexistent/mirrorlist\n"}}},
+		{"lines before the first section", "Server = https://stray.example.com\n", nil},
+	}
+	for _, test := range tests {
+		if got := parsePacmanSections(test.data); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+// gpg --with-colons --list-keys of a pacman keyring, the master key first
+const pacmanKeyring = `tru::1:1718000000:0:3:1:5
+pub:u:4096:1:1111111111111111:1718000000:::u:::scSC::::::23::0:
+fpr:::::::::AAAA1111111111111111AAAA1111111111111111:
+uid:u::::1718000000::HASH1::Pacman Keyring Master Key <pacman@localhost>::::::::::0:
+pub:f:4096:1:2222222222222222:1600000000:::-:::scSC::::::23::0:
+fpr:::::::::BBBB2222222222222222BBBB2222222222222222:
+uid:f::::1600000000::HASH2::Chaotic AUR <chaotic@example.com>::::::::::0:
+sub:f:4096:1:3333333333333333:1600000000::::::e::::::23:
+fpr:::::::::CCCC3333333333333333CCCC3333333333333333:
+pub:f:255:22:4444444444444444:1650000000:::-:::scSC::::::ed25519::0:
+fpr:::::::::DDDD4444444444444444DDDD4444444444444444:
+uid:f::::1650000000::HASH3::archlinuxcn <repo@archlinuxcn.org>::::::::::0:
+`
+
+func TestKeyFingerprints(t *testing.T) {
+	want := []string{
+		"AAAA1111111111111111AAAA1111111111111111",
+		"BBBB2222222222222222BBBB2222222222222222",
+		"DDDD4444444444444444DDDD4444444444444444",
+	}
+	if got := keyFingerprints(pacmanKeyring); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		fingerprint string
+		want        bool
+	}{
+		{"AAAA1111111111111111AAAA1111111111111111", true},
+		{"BBBB2222222222222222BBBB2222222222222222", false},
+		{"CCCC3333333333333333CCCC3333333333333333", false}, // subkeys are never master keys
+		{"DDDD4444444444444444DDDD4444444444444444", false},
+	}
+	for _, test := range tests {
+		if got := isMasterKey(pacmanKeyring, test.fingerprint); got != test.want {
+			t.Errorf("%s: master key %v, want %v", test.fingerprint, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+func init() { RegisterManager(portageManager{}) }
+
+// portageManager handles Gentoo, packages are named category/package.
+type portageManager struct{}
+
+func (portageManager) Name() string       { return "portage" }
+func (portageManager) Families() []string { return []string{"gentoo"} }
+func (portageManager) Detect() bool       { return commandExists("emerge") }
+
+func (portageManager) ListInstalled() ([]Package, error) { return fetchPortage() }
+
+func (m portageManager) ListExplicit() ([]Package, error) { return listExplicit(m) }
+
+// InstallCommand skips packages already installed and records the rest in @world.
+func (portageManager) InstallCommand(packages ...Package) string {
+	return installCommand("sudo emerge --noreplace", packageNames(packages))
+}
+
+// Available asks for the best visible version, nothing is printed when there is none
+func (portageManager) Available(name string) (bool, error) {
+	out, err := runQuery("portageq", "best_visible", "/", name)
+	if _, ok := err.(*exec.ExitError); ok {
+		return false, nil
+	}
+	return strings.TrimSpace(out) != "", err
+}
+
+// InstalledCheck matches the category/package atom against /var/db/pkg
+func (portageManager) InstalledCheck() string {
+	return `portageq has_version / "$1"`
+}
+
+// InstalledVersion strips the atom off the best installed category/package-version
+func (portageManager) InstalledVersion() string {
+	return `cpv="$(portageq best_version / "$1")" && [ -n "$cpv" ] && echo "${cpv#"$1"-}"`
+}
+
+// OlderVersion compares with portage's own vercmp, there is no shell command for it
+func (portageManager) OlderVersion() string {
+	return `python3 -c 'import sys, portage.versions; sys.exit(portage.versions.vercmp(sys.argv[1], sys.argv[2]) >= 0)' "$1" "$2"`
+}
+
+func (portageManager) ValidName(name string) bool { return portageName.MatchString(name) }
+
+// PinnedInstallCommand emerges the exact version atom, =category/package-version.
+func (portageManager) PinnedInstallCommand(packages ...Package) string {
+	atoms := pinnedNames(packages, "-")
+	for i, pkg := range packages {
+		if pkg.Version != "" {
+			atoms[i] = "=" + atoms[i]
+		}
+	}
+	return installCommand("sudo emerge --noreplace", atoms)
+}
+
+// PortageConfig holds the USE flags a Gentoo system builds its packages with.
+type PortageConfig struct {
+	// Use is the global USE of make.conf.
+	Use string `json:"use,omitempty"`
+	// PackageUse are the "category/package flags" lines of /etc/portage/package.use.
+	PackageUse []string `json:"package_use,omitempty"`
+}
+
+// FetchPortageConfig reads the global and per-package USE flags.
+func FetchPortageConfig() (*PortageConfig, error) {
+	config := &PortageConfig{}
+	data, err := os.ReadFile("/etc/portage/make.conf")
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if value, ok := strings.CutPrefix(strings.TrimSpace(line), "USE="); ok {
+			config.Use = strings.Trim(value, `"'`)
+		}
+	}
+
+	// package.use is either a file or a directory of files
+	files := []string{"/etc/portage/package.use"}
+	if info, err := os.Stat(files[0]); err == nil && info.IsDir() {
+		files = nil
+		filepath.WalkDir("/etc/portage/package.use", func(path string, d os.DirEntry, err error) error {
+			if err == nil && !d.IsDir() {
+				files = append(files, path)
+			}
+			return nil
+		})
+		sort.Strings(files)
+	}
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		for _, line := range splitLines(string(data)) {
+			line = strings.TrimSpace(line)
+			if !strings.HasPrefix(line, "#") {
+				config.PackageUse = append(config.PackageUse, line)
+			}
+		}
+	}
+	return config, nil
+}
+
+// "name-1.2.3_p1-r2" directory names of the Portage database, names may contain dashes
+// and digits but never end in something that looks like a version
+var portagePkgver = regexp.MustCompile(`^(.+?)-(\d[^-]*(?:-r\d+)?)$`)
+
+// fetchPortage reads installed packages from /var/db/pkg, the @world set holds what the
+// user emerged explicitly.
+func fetchPortage() ([]Package, error) {
+	dirs, err := filepath.Glob("/var/db/pkg/*/*")
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	for _, dir := range dirs {
+		match := portagePkgver.FindStringSubmatch(filepath.Base(dir))
+		if match == nil {
+			continue
+		}
+		pkg := Package{Name: filepath.Base(filepath.Dir(dir)) + "/" + match[1], Version: match[2]}
+		if chost, err := os.ReadFile(filepath.Join(dir, "CHOST")); err == nil {
+			pkg.Arch, _, _ = strings.Cut(strings.TrimSpace(string(chost)), "-")
+		}
+		if repo, err := os.ReadFile(filepath.Join(dir, "repository")); err == nil {
+			pkg.Repository = strings.TrimSpace(string(repo))
+		}
+		packages = append(packages, pkg)
+	}
+
+	world, err := os.ReadFile("/var/lib/portage/world")
+	if err != nil {
+		log.Println("Cannot read the @world set:", err)
+		return packages, nil
+	}
+	var names []string
+	for _, atom := range splitLines(string(world)) {
+		// atoms may be pinned to a slot or repository, "dev-lang/python:3.12"
+		if i := strings.IndexAny(atom, ":"); i >= 0 {
+			atom = atom[:i]
+		}
+		names = append(names, strings.TrimSpace(atom))
+	}
+	markReasons(packages, names)
+	return packages, nil
+}
@@ -0,0 +1,28 @@
+package utils
+
+import "testing"
+
+func TestPortagePkgver(t *testing.T) {
+	tests := []struct {
+		dir           string
+		name, version string // "" when the name is not a package directory
+	}{
+		{"vim-9.1.0394", "vim", "9.1.0394"},
+		{"python-3.12.3-r1", "python", "3.12.3-r1"},
+		{"gtk+-3.24.41-r1", "gtk+", "3.24.41-r1"},
+		{"openssh-9.7_p1-r5", "openssh", "9.7_p1-r5"},
+		{"font-adobe-100dpi-1.0.4", "font-adobe-100dpi", "1.0.4"},
+		{"libX11-1.8.9", "libX11", "1.8.9"},
+		{"go-bootstrap-1.20.6", "go-bootstrap", "1.20.6"},
+		{"notapackage", "", ""},
+	}
+	for _, test := range tests {
+		var name, version string
+		if match := portagePkgver.FindStringSubmatch(test.dir); match != nil {
+			name, version = match[1], match[2]
+		}
+		if name != test.name || version != test.version {
+			t.Errorf("%s: got %q %q, want %q %q", test.dir, name, version, test.name, test.version)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package utils
+
+import (
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Sources are the third-party repositories a system installs packages from, with the keys
+// their packages are verified with. The distro's own repositories are left out.
+type Sources struct {
+	Manager      string       `json:"manager"` // "apt", "pacman", "dnf", "xbps", "zypper" or "apk"
+	Repositories []Repository `json:"repositories,omitempty"`
+	Keys         []SigningKey `json:"keys,omitempty"`
+}
+
+// Repository is one enabled repository definition.
+type Repository struct {
+	Name string `json:"name"`
+	// Path is the file the definition is written to, for pacman it is appended to pacman.conf.
+	Path       string   `json:"path"`
+	URIs       []string `json:"uris,omitempty"`
+	Definition string   `json:"definition"`
+}
+
+// SigningKey is a repository key, either a file at Path or a key in the package manager's
+// keyring identified by Fingerprint.
+type SigningKey struct {
+	Path        string `json:"path,omitempty"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Data        []byte `json:"data"`
+}
+
+// Count returns the number of repositories.
+func (s *Sources) Count() int {
+	if s == nil {
+		return 0
+	}
+	return len(s.Repositories)
+}
+
+// FetchSources collects the enabled third-party repositories for the given base distro and
+// annotates packages with the repository they were installed from.
+func FetchSources(baseDistro string, packages []Package) *Sources {
+	manager, ok := ManagerFor(baseDistro).(SourceManager)
+	if !ok {
+		return nil
+	}
+	sources, err := manager.FetchSources(packages)
+	if err != nil {
+		log.Println("Error in retrieving repositories:", err)
+	}
+	return sources
+}
+
+// parseRepoFile returns the base URLs and local gpgkey files of a .repo file and whether
+// any of its repositories is enabled, which they are unless enabled=0.
+func parseRepoFile(data string) (uris, keys []string, enabled bool) {
+	inSection, sectionEnabled := false, true
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "[") {
+			if inSection && sectionEnabled {
+				enabled = true
+			}
+			inSection, sectionEnabled = true, true
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok || strings.HasPrefix(line, "#") {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "enabled":
+			sectionEnabled = strings.TrimSpace(value) != "0"
+		case "baseurl", "metalink", "mirrorlist":
+			uris = append(uris, strings.Fields(value)...)
+		case "gpgkey":
+			for _, key := range strings.Fields(value) {
+				if path, ok := strings.CutPrefix(key, "file://"); ok {
+					keys = append(keys, path)
+				}
+			}
+		}
+	}
+	if inSection && sectionEnabled {
+		enabled = true
+	}
+	return uris, keys, enabled
+}
+
+// readKeyFiles reads the key files that exist, in path order
+func readKeyFiles(paths map[string]bool) []SigningKey {
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	var keys []SigningKey
+	for _, path := range sorted {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			log.Println("Cannot read repository key:", err)
+			continue
+		}
+		keys = append(keys, SigningKey{Path: path, Data: data})
+	}
+	return keys
+}
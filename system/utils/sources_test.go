@@ -0,0 +1,60 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestParseRepoFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		uris    []string
+		keys    []string
+		enabled bool
+	}{
+		{"enabled by default",
+			"[code]\nname=Visual Studio Code\nbaseurl=https://packages.microsoft.com/yumrepos/vscode\ngpgcheck=1\ngpgkey=https://packages.microsoft.com/keys/microsoft.asc\n",
+			[]string{"https://packages.microsoft.com/yumrepos/vscode"}, nil, true},
+		{"local key files",
+			"[docker-ce-stable]\nbaseurl=https://download.docker.com/linux/fedora/$releasever/$basearch/stable\nenabled=1\n" +
+				"gpgkey=file:///etc/pki/rpm-gpg/docker.gpg https://download.docker.com/linux/fedora/gpg\n",
+			[]string{"https://download.docker.com/linux/fedora/$releasever/$basearch/stable"}, []string{"/etc/pki/rpm-gpg/docker.gpg"}, true},
+		{"every section disabled",
+			"[a]\nmetalink=https://mirrors.example.com/metalink?repo=a\nenabled=0\n[a-source]\nmirrorlist=https://mirrors.example.com/list\nenabled=0\n",
+			[]string{"https://mirrors.example.com/metalink?repo=a", "https://mirrors.example.com/list"}, nil, false},
+		{"one of several sections enabled",
+			"[a]\nbaseurl=https://a.example.com\nenabled=0\n\n[b]\nbaseurl=https://b.example.com\n# enabled=0\n",
+			[]string{"https://a.example.com", "https://b.example.com"}, nil, true},
+		{"no sections", "# empty\n", nil, nil, false},
+	}
+	for _, test := range tests {
+		uris, keys, enabled := parseRepoFile(test.data)
+		if !reflect.DeepEqual(uris, test.uris) || !reflect.DeepEqual(keys, test.keys) || enabled != test.enabled {
+			t.Errorf("%s: got %v %v %v, want %v %v %v", test.name, uris, keys, enabled, test.uris, test.keys, test.enabled)
+		}
+	}
+}
+
+func TestReadKeyFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range map[string]string{"b.gpg": "key b", "a.asc": "key a"} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys := readKeyFiles(map[string]bool{
+		filepath.Join(dir, "b.gpg"):       true,
+		filepath.Join(dir, "missing.gpg"): true,
+		filepath.Join(dir, "a.asc"):       true,
+	})
+	want := []SigningKey{
+		{Path: filepath.Join(dir, "a.asc"), Data: []byte("key a")},
+		{Path: filepath.Join(dir, "b.gpg"), Data: []byte("key b")},
+	}
+	if !reflect.DeepEqual(keys, want) {
+		t.Errorf("got %+v, want %+v", keys, want)
+	}
+}
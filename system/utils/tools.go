@@ -0,0 +1,264 @@
+package utils
+
+import (
+	"encoding/json"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Tool is a globally installed language-level package.
+type Tool struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+	// Source is where it was installed from when that is not the ecosystem's registry,
+	// e.g. a git URL, or the package path for go.
+	Source string `json:"source,omitempty"`
+}
+
+// Tools holds one section per ecosystem.
+type Tools struct {
+	Pipx  []Tool `json:"pipx,omitempty"`
+	Pip   []Tool `json:"pip,omitempty"`
+	Npm   []Tool `json:"npm,omitempty"`
+	Cargo []Tool `json:"cargo,omitempty"`
+	Go    []Tool `json:"go,omitempty"`
+	Gem   []Tool `json:"gem,omitempty"`
+}
+
+// Count returns the number of tools over all ecosystems.
+func (t *Tools) Count() int {
+	return len(t.Pipx) + len(t.Pip) + len(t.Npm) + len(t.Cargo) + len(t.Go) + len(t.Gem)
+}
+
+// FetchTools collects the tools of every ecosystem whose command is installed.
+func FetchTools() *Tools {
+	tools := &Tools{}
+	collectors := []struct {
+		ecosystem string
+		command   string
+		fetch     func() ([]Tool, error)
+		into      *[]Tool
+	}{
+		{"pipx", "pipx", fetchPipx, &tools.Pipx},
+		{"pip", "python3", fetchPip, &tools.Pip},
+		{"npm", "npm", fetchNpm, &tools.Npm},
+		{"cargo", "cargo", fetchCargo, &tools.Cargo},
+		{"go", "go", fetchGo, &tools.Go},
+		{"gem", "gem", fetchGem, &tools.Gem},
+	}
+	for _, collector := range collectors {
+		if _, err := exec.LookPath(collector.command); err != nil {
+			continue
+		}
+		found, err := collector.fetch()
+		if err != nil {
+			log.Printf("Error in retrieving %s tools: %v\n", collector.ecosystem, err)
+		}
+		*collector.into = found
+	}
+	return tools
+}
+
+// fetchPipx reads the apps pipx manages, each in its own virtualenv.
+func fetchPipx() ([]Tool, error) {
+	out, err := runQuery("pipx", "list", "--json")
+	if err != nil {
+		return nil, err
+	}
+	var list struct {
+		Venvs map[string]struct {
+			Metadata struct {
+				MainPackage struct {
+					Package        string `json:"package"`
+					PackageVersion string `json:"package_version"`
+					PackageOrURL   string `json:"package_or_url"`
+				} `json:"main_package"`
+			} `json:"metadata"`
+		} `json:"venvs"`
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, err
+	}
+
+	var tools []Tool
+	for _, venv := range list.Venvs {
+		main := venv.Metadata.MainPackage
+		tool := Tool{Name: main.Package, Version: main.PackageVersion}
+		if main.PackageOrURL != main.Package {
+			tool.Source = main.PackageOrURL
+		}
+		tools = append(tools, tool)
+	}
+	sortTools(tools)
+	return tools, nil
+}
+
+// fetchPip lists top-level packages installed with pip install --user, system wide ones
+// belong to the OS packages.
+func fetchPip() ([]Tool, error) {
+	out, err := runQuery("python3", "-m", "pip", "list", "--user", "--not-required", "--format=json")
+	if err != nil {
+		return nil, err
+	}
+	var list []struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, err
+	}
+	var tools []Tool
+	for _, pkg := range list {
+		tools = append(tools, Tool{Name: pkg.Name, Version: pkg.Version})
+	}
+	return tools, nil
+}
+
+// npm itself and corepack ship with node
+var bundledNpmPackages = map[string]bool{"npm": true, "corepack": true}
+
+// fetchNpm lists global npm packages, git and local installs keep where they came from.
+func fetchNpm() ([]Tool, error) {
+	out, err := runQuery("npm", "ls", "-g", "--depth=0", "--json")
+	if err != nil && out == "" {
+		return nil, err
+	}
+	var list struct {
+		Dependencies map[string]struct {
+			Version  string `json:"version"`
+			Resolved string `json:"resolved"`
+		} `json:"dependencies"`
+	}
+	if err := json.Unmarshal([]byte(out), &list); err != nil {
+		return nil, err
+	}
+
+	var tools []Tool
+	for name, dep := range list.Dependencies {
+		if bundledNpmPackages[name] {
+			continue
+		}
+		tool := Tool{Name: name, Version: dep.Version}
+		if strings.HasPrefix(dep.Resolved, "git") || strings.HasPrefix(dep.Resolved, "file:") {
+			tool.Source = dep.Resolved
+		}
+		tools = append(tools, tool)
+	}
+	sortTools(tools)
+	return tools, nil
+}
+
+// "name v1.2.3:" or "name v1.2.3 (https://github.com/x/name#rev):" lines of cargo install --list
+var cargoInstallLine = regexp.MustCompile(`^(\S+) v(\S+)(?: \((.+)\))?:$`)
+
+// fetchCargo lists crates installed with cargo install.
+func fetchCargo() ([]Tool, error) {
+	out, err := runQuery("cargo", "install", "--list")
+	if err != nil {
+		return nil, err
+	}
+	var tools []Tool
+	for _, line := range splitLines(out) {
+		// binaries provided by a crate are listed indented below it
+		match := cargoInstallLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		tools = append(tools, Tool{Name: match[1], Version: match[2], Source: match[3]})
+	}
+	return tools, nil
+}
+
+// fetchGo reads the build info of the binaries installed with go install.
+func fetchGo() ([]Tool, error) {
+	out, err := runQuery("go", "env", "GOBIN", "GOPATH")
+	if err != nil {
+		return nil, err
+	}
+	env := strings.Split(out, "\n")
+	binDir := strings.TrimSpace(env[0])
+	if binDir == "" && len(env) > 1 {
+		// GOPATH may be a list, go install uses the first entry
+		gopath := filepath.SplitList(strings.TrimSpace(env[1]))
+		if len(gopath) == 0 {
+			return nil, nil
+		}
+		binDir = filepath.Join(gopath[0], "bin")
+	}
+
+	out, err = runQuery("go", "version", "-m", binDir)
+	if err != nil {
+		return nil, err
+	}
+	return parseGoVersion(out), nil
+}
+
+// parseGoVersion parses go version -m output, a "binary: go1.x" line followed by
+// tab indented path, mod and dep lines.
+func parseGoVersion(out string) []Tool {
+	var tools []Tool
+	var current *Tool
+	for _, line := range splitLines(out) {
+		if !strings.HasPrefix(line, "\t") {
+			binary, _, _ := strings.Cut(line, ":")
+			tools = append(tools, Tool{Name: filepath.Base(binary)})
+			current = &tools[len(tools)-1]
+			continue
+		}
+		fields := strings.Fields(line)
+		if current == nil || len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "path":
+			current.Source = fields[1]
+		case "mod":
+			if len(fields) >= 3 {
+				current.Version = fields[2]
+			}
+		}
+	}
+
+	// binaries built from a local checkout cannot be installed again by path
+	var installable []Tool
+	for _, tool := range tools {
+		if tool.Source != "" && tool.Version != "" && tool.Version != "(devel)" {
+			installable = append(installable, tool)
+		}
+	}
+	return installable
+}
+
+// "name (1.2.3, 1.1.0)" or "name (default: 2.0.0)" lines of gem list
+var gemListLine = regexp.MustCompile(`^(\S+) \((.+)\)$`)
+
+// fetchGem lists installed gems, leaving out the default gems that ship with ruby.
+func fetchGem() ([]Tool, error) {
+	out, err := runQuery("gem", "list", "--local")
+	if err != nil {
+		return nil, err
+	}
+	var tools []Tool
+	for _, line := range splitLines(out) {
+		match := gemListLine.FindStringSubmatch(line)
+		if match == nil {
+			continue
+		}
+		// the newest version comes first
+		version := strings.TrimSpace(strings.Split(match[2], ",")[0])
+		if strings.HasPrefix(version, "default:") {
+			continue
+		}
+		tools = append(tools, Tool{Name: match[1], Version: version})
+	}
+	return tools, nil
+}
+
+// sortTools orders tools read from JSON maps by name
+func sortTools(tools []Tool) {
+	sort.Slice(tools, func(i, j int) bool { return tools[i].Name < tools[j].Name })
+}
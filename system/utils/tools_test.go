@@ -0,0 +1,97 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+// go version -m output for a directory of installed binaries
+const goVersionOutput = `/home/me/go/bin/gopls: go1.22.4
+	path	golang.org/x/tools/gopls
+	mod	golang.org/x/tools/gopls	v0.16.0	h1:abc=
+	dep	golang.org/x/mod	v0.18.0	h1:def=
+	build	-buildmode=exe
+/home/me/go/bin/dlv: go1.22.4
+	path	github.com/go-delve/delve/cmd/dlv
+	mod	github.com/go-delve/delve	v1.23.0	h1:ghi=
+/home/me/go/bin/mytool: go1.22.4
+	path	example.com/mytool
+	mod	example.com/mytool	(devel)
+/home/me/go/bin/script.sh: could not read Go build info from /home/me/go/bin/script.sh: unrecognized file format
+`
+
+func TestParseGoVersion(t *testing.T) {
+	tests := []struct {
+		name string
+		out  string
+		want []Tool
+	}{
+		{"installed binaries", goVersionOutput, []Tool{
+			{Name: "gopls", Version: "v0.16.0", Source: "golang.org/x/tools/gopls"},
+			{Name: "dlv", Version: "v1.23.0", Source: "github.com/go-delve/delve/cmd/dlv"},
+		}},
+		{"no binaries", "", nil},
+	}
+	for _, test := range tests {
+		if got := parseGoVersion(test.out); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestFetchTools(t *testing.T) {
+	tests := []struct {
+		command string
+		outputs map[string]string // captured output by arguments
+		fetch   func() ([]Tool, error)
+		want    []Tool
+	}{
+		{"cargo", map[string]string{"install --list": "bat v0.24.0:\n    bat\nripgrep v14.1.0:\n    rg\n" +
+			"zellij v0.40.1 (https://github.com/zellij-org/zellij#4f7d5a3c):\n    zellij\n"}, fetchCargo, []Tool{
+			{Name: "bat", Version: "0.24.0"},
+			{Name: "ripgrep", Version: "14.1.0"},
+			{Name: "zellij", Version: "0.40.1", Source: "https://github.com/zellij-org/zellij#4f7d5a3c"},
+		}},
+		{"gem", map[string]string{"list --local": "\n*** LOCAL GEMS ***\n\nbigdecimal (default: 3.1.1)\nbundler (2.5.11, default: 2.5.9)\n" +
+			"rails (7.1.3.4)\nrake (13.2.1, 13.1.0)\n"}, fetchGem, []Tool{
+			{Name: "bundler", Version: "2.5.11"},
+			{Name: "rails", Version: "7.1.3.4"},
+			{Name: "rake", Version: "13.2.1"},
+		}},
+		{"npm", map[string]string{"ls -g --depth=0 --json": `{"dependencies": {
+			"typescript": {"version": "5.5.3", "resolved": "https://registry.npmjs.org/typescript/-/typescript-5.5.3.tgz"},
+			"npm": {"version": "10.8.1"},
+			"corepack": {"version": "0.28.2"},
+			"mytool": {"version": "1.0.0", "resolved": "git+ssh://git@github.com/me/mytool.git#1a2b3c"},
+			"local": {"version": "0.1.0", "resolved": "file:../local"}
+		}}`}, fetchNpm, []Tool{
+			{Name: "local", Version: "0.1.0", Source: "file:../local"},
+			{Name: "mytool", Version: "1.0.0", Source: "git+ssh://git@github.com/me/mytool.git#1a2b3c"},
+			{Name: "typescript", Version: "5.5.3"},
+		}},
+		{"pipx", map[string]string{"list --json": `{"venvs": {
+			"black": {"metadata": {"main_package": {"package": "black", "package_version": "24.4.2", "package_or_url": "black"}}},
+			"httpie": {"metadata": {"main_package": {"package": "httpie", "package_version": "3.2.3", "package_or_url": "git+https://github.com/httpie/cli"}}}
+		}}`}, fetchPipx, []Tool{
+			{Name: "black", Version: "24.4.2"},
+			{Name: "httpie", Version: "3.2.3", Source: "git+https://github.com/httpie/cli"},
+		}},
+		{"python3", map[string]string{"-m pip list --user --not-required --format=json": `[{"name": "requests", "version": "2.32.3"}]`}, fetchPip,
+			[]Tool{{Name: "requests", Version: "2.32.3"}}},
+		{"go", map[string]string{"env GOBIN GOPATH": "\n/home/me/go:/opt/go\n", "version -m /home/me/go/bin": goVersionOutput}, fetchGo, []Tool{
+			{Name: "gopls", Version: "v0.16.0", Source: "golang.org/x/tools/gopls"},
+			{Name: "dlv", Version: "v1.23.0", Source: "github.com/go-delve/delve/cmd/dlv"},
+		}},
+	}
+	for _, test := range tests {
+		stubQuery(t, test.command, test.outputs)
+		got, err := test.fetch()
+		if err != nil {
+			t.Errorf("%s: %v", test.command, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %+v, want %+v", test.command, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package utils
+
+import (
+	"encoding/xml"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() { RegisterManager(xbpsManager{}) }
+
+// xbpsManager handles Void Linux.
+type xbpsManager struct{}
+
+func (xbpsManager) Name() string       { return "xbps" }
+func (xbpsManager) Families() []string { return []string{"void"} }
+func (xbpsManager) Detect() bool       { return commandExists("xbps-install") }
+
+func (xbpsManager) ListInstalled() ([]Package, error) { return fetchXBPS() }
+
+func (m xbpsManager) ListExplicit() ([]Package, error) { return listExplicit(m) }
+
+func (xbpsManager) InstallCommand(packages ...Package) string {
+	return installCommand("sudo xbps-install -y", packageNames(packages))
+}
+
+// Available asks the remote repositories for the package
+func (xbpsManager) Available(name string) (bool, error) {
+	return queryExits("xbps-query", "-R", name)
+}
+
+// InstalledCheck asks the pkgdb, xbps-query fails for packages that are not installed
+func (xbpsManager) InstalledCheck() string {
+	return `xbps-query "$1" >/dev/null 2>&1`
+}
+
+// InstalledVersion prints the version_revision part of the installed pkgver
+func (xbpsManager) InstalledVersion() string {
+	return `pkgver="$(xbps-query -p pkgver "$1" 2>/dev/null)" && echo "${pkgver##*-}"`
+}
+
+// OlderVersion uses xbps-uhelper, which exits 255 when the first version is older
+func (xbpsManager) OlderVersion() string {
+	return `xbps-uhelper cmpver "$1" "$2"; [ $? -eq 255 ]`
+}
+
+func (xbpsManager) ValidName(name string) bool { return plainName.MatchString(name) }
+
+// PinnedInstallCommand installs the exact pkgver, name-version_revision.
+func (xbpsManager) PinnedInstallCommand(packages ...Package) string {
+	return installCommand("sudo xbps-install -y", pinnedNames(packages, "-"))
+}
+
+func (xbpsManager) FetchSources(packages []Package) (*Sources, error) {
+	return fetchXBPSSources(packages)
+}
+
+func (xbpsManager) RefreshCommand() string { return "sudo xbps-install -S" }
+
+// fetchXBPS lists installed packages from "ii name-version_revision description" lines.
+func fetchXBPS() ([]Package, error) {
+	out, err := runQuery("xbps-query", "-l")
+	if err != nil {
+		return nil, err
+	}
+	var packages []Package
+	for _, line := range splitLines(out) {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || fields[0] != "ii" {
+			continue
+		}
+		name, version := splitPkgver(fields[1])
+		packages = append(packages, Package{Name: name, Version: version})
+	}
+
+	manual, err := runQuery("xbps-query", "-m")
+	if err != nil {
+		log.Println("Cannot tell manually installed packages apart:", err)
+		return packages, nil
+	}
+	var names []string
+	for _, pkgver := range splitLines(manual) {
+		name, _ := splitPkgver(strings.TrimSpace(pkgver))
+		names = append(names, name)
+	}
+	markReasons(packages, names)
+	return packages, nil
+}
+
+// splitPkgver splits an xbps pkgver at the last dash, names may contain dashes but versions may not.
+func splitPkgver(pkgver string) (string, string) {
+	i := strings.LastIndex(pkgver, "-")
+	if i <= 0 {
+		return pkgver, ""
+	}
+	return pkgver[:i], pkgver[i+1:]
+}
+
+// the key every official Void Linux repository is signed with
+const voidRepoKey = "60:ae:0c:d6:f0:95:17:80:bc:93:46:7a:89:af:a3:2d.plist"
+
+// fetchXBPSSources reads the repositories configured in /etc/xbps.d and the repository keys
+// xbps was told to trust besides the official one.
+func fetchXBPSSources(packages []Package) (*Sources, error) {
+	files, err := filepath.Glob("/etc/xbps.d/*.conf")
+	if err != nil {
+		return nil, err
+	}
+	sources := &Sources{Manager: "xbps"}
+	names := make(map[string]string) // URL -> repository
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var uris []string
+		for _, line := range strings.Split(string(data), "\n") {
+			if value, ok := strings.CutPrefix(strings.TrimSpace(line), "repository="); ok {
+				uris = append(uris, strings.TrimSpace(value))
+			}
+		}
+		if len(uris) == 0 {
+			continue
+		}
+		name := strings.TrimSuffix(filepath.Base(path), ".conf")
+		sources.Repositories = append(sources.Repositories, Repository{Name: name, Path: path, URIs: uris, Definition: string(data)})
+		for _, uri := range uris {
+			names[uri] = name
+		}
+	}
+
+	keyFiles, _ := filepath.Glob("/var/db/xbps/keys/*.plist")
+	keyPaths := make(map[string]bool)
+	for _, path := range keyFiles {
+		if filepath.Base(path) != voidRepoKey {
+			keyPaths[path] = true
+		}
+	}
+	sources.Keys = readKeyFiles(keyPaths)
+
+	// the package database records the repository URL of every package
+	repos, err := xbpsPackageRepositories()
+	if err != nil {
+		log.Println("Cannot tell which repository packages came from:", err)
+		return sources, nil
+	}
+	for i := range packages {
+		if name, ok := names[repos[packages[i].Name]]; ok && packages[i].Repository == "" {
+			packages[i].Repository = name
+		}
+	}
+	return sources, nil
+}
+
+// xbpsPackageRepositories reads package name -> repository URL from the xbps package
+// database, a plist dictionary of package dictionaries.
+func xbpsPackageRepositories() (map[string]string, error) {
+	paths, _ := filepath.Glob("/var/db/xbps/pkgdb-*.plist")
+	if len(paths) == 0 {
+		return nil, os.ErrNotExist
+	}
+	f, err := os.Open(paths[0])
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parsePkgdb(f)
+}
+
+// parsePkgdb reads the repository string of every package dictionary in a pkgdb plist.
+func parsePkgdb(r io.Reader) (map[string]string, error) {
+	repos := make(map[string]string)
+	decoder := xml.NewDecoder(r)
+	depth := 0
+	var pkgname, lastKey string
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			return repos, nil
+		}
+		if err != nil {
+			return repos, err
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			if t.Name.Local == "dict" {
+				depth++
+				continue
+			}
+			if t.Name.Local != "key" && t.Name.Local != "string" {
+				continue
+			}
+			var text string
+			if err := decoder.DecodeElement(&text, &t); err != nil {
+				return repos, err
+			}
+			switch {
+			case t.Name.Local == "key" && depth == 1:
+				pkgname = text
+			case t.Name.Local == "key":
+				lastKey = text
+			case t.Name.Local == "string" && depth == 2 && lastKey == "repository":
+				repos[pkgname] = text
+			}
+		case xml.EndElement:
+			if t.Name.Local == "dict" {
+				depth--
+			}
+		}
+	}
+}
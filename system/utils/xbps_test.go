@@ -0,0 +1,115 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// an excerpt of /var/db/xbps/pkgdb-0.38.plist
+const pkgdb = `<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple Computer//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+	<key>base-system</key>
+	<dict>
+		<key>automatic-install</key>
+		<false/>
+		<key>pkgver</key>
+		<string>base-system-0.114_2</string>
+		<key>repository</key>
+		<string>https://repo-default.voidlinux.org/current</string>
+		<key>run_depends</key>
+		<array>
+			<string>xbps&gt;=0</string>
+			<string>repository</string>
+		</array>
+	</dict>
+	<key>spotify</key>
+	<dict>
+		<key>pkgver</key>
+		<string>spotify-1.2.31_1</string>
+		<key>repository</key>
+		<string>https://repo-default.voidlinux.org/current/nonfree</string>
+	</dict>
+	<key>local-build</key>
+	<dict>
+		<key>pkgver</key>
+		<string>local-build-1.0_1</string>
+	</dict>
+	<key>_XBPS_ALTERNATIVES_</key>
+	<dict>
+		<key>sh</key>
+		<dict>
+			<key>repository</key>
+			<array>
+				<string>dash</string>
+			</array>
+		</dict>
+	</dict>
+</dict>
+</plist>
+`
+
+func TestParsePkgdb(t *testing.T) {
+	tests := []struct {
+		name  string
+		plist string
+		want  map[string]string
+		fails bool
+	}{
+		{"pkgdb", pkgdb, map[string]string{
+			"base-system": "https://repo-default.voidlinux.org/current",
+			"spotify":     "https://repo-default.voidlinux.org/current/nonfree",
+		}, false},
+		{"empty dictionary", "<plist version=\"1.0\"><dict></dict></plist>", map[string]string{}, false},
+		{"truncated", "<plist version=\"1.0\"><dict><key>vim</key><dict><key>repository</key><string>https://", nil, true},
+	}
+	for _, test := range tests {
+		got, err := parsePkgdb(strings.NewReader(test.plist))
+		if (err != nil) != test.fails {
+			t.Errorf("%s: parse returned %v", test.name, err)
+			continue
+		}
+		if !test.fails && !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestSplitPkgver(t *testing.T) {
+	tests := []struct {
+		pkgver        string
+		name, version string
+	}{
+		{"vim-9.1.0_1", "vim", "9.1.0_1"},
+		{"xorg-server-xwayland-24.1.0_1", "xorg-server-xwayland", "24.1.0_1"},
+		{"python3-3.12.4_1", "python3", "3.12.4_1"},
+		{"noversion", "noversion", ""},
+	}
+	for _, test := range tests {
+		if name, version := splitPkgver(test.pkgver); name != test.name || version != test.version {
+			t.Errorf("%s: got %q %q, want %q %q", test.pkgver, name, version, test.name, test.version)
+		}
+	}
+}
+
+func TestFetchXBPS(t *testing.T) {
+	stubQuery(t, "xbps-query", map[string]string{
+		"-l": "ii base-system-0.114_2   Void Linux base system meta package\n" +
+			"ii xorg-server-xwayland-24.1.0_1 Nested X server that runs as a wayland client\n" +
+			"uu half-configured-1.0_1  Unpacked only\n",
+		"-m": "base-system-0.114_2\n",
+	})
+	packages, err := fetchXBPS()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Package{
+		{Name: "base-system", Version: "0.114_2", Reason: ReasonExplicit},
+		{Name: "xorg-server-xwayland", Version: "24.1.0_1", Reason: ReasonDependency},
+	}
+	if !reflect.DeepEqual(packages, want) {
+		t.Errorf("got %+v, want %+v", packages, want)
+	}
+}
@@ -0,0 +1,156 @@
+package utils
+
+import (
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func init() { RegisterManager(zypperManager{}) }
+
+// zypperManager handles openSUSE and SLE.
+type zypperManager struct{}
+
+func (zypperManager) Name() string       { return "zypper" }
+func (zypperManager) Families() []string { return []string{"suse"} }
+func (zypperManager) Detect() bool       { return commandExists("zypper") }
+
+func (zypperManager) ListInstalled() ([]Package, error) { return fetchZypper() }
+
+func (m zypperManager) ListExplicit() ([]Package, error) { return listExplicit(m) }
+
+func (zypperManager) InstallCommand(packages ...Package) string {
+	return installCommand("sudo zypper --non-interactive install", packageNames(packages))
+}
+
+// Available searches the repositories for the exact name, zypper exits 104 when nothing matches
+func (zypperManager) Available(name string) (bool, error) {
+	return queryExits("zypper", "--non-interactive", "--quiet", "search", "--match-exact", name)
+}
+
+// InstalledCheck asks the rpm database
+func (zypperManager) InstalledCheck() string {
+	return `rpm -q "$1" >/dev/null 2>&1`
+}
+
+func (zypperManager) InstalledVersion() string { return rpmInstalledVersion }
+
+func (zypperManager) OlderVersion() string { return rpmOlderVersion }
+
+func (zypperManager) ValidName(name string) bool { return plainName.MatchString(name) }
+
+// PinnedInstallCommand installs name=version, --oldpackage allows versions older than the newest.
+func (zypperManager) PinnedInstallCommand(packages ...Package) string {
+	return installCommand("sudo zypper --non-interactive install --oldpackage", pinnedNames(packages, "="))
+}
+
+func (zypperManager) FetchSources(packages []Package) (*Sources, error) {
+	return fetchZypperSources(packages)
+}
+
+func (zypperManager) RefreshCommand() string {
+	return "sudo zypper --non-interactive --gpg-auto-import-keys refresh"
+}
+
+// fetchZypper lists installed packages, libzypp records the ones installed automatically.
+func fetchZypper() ([]Package, error) {
+	packages, err := listRPM()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile("/var/lib/zypp/AutoInstalled")
+	if err != nil {
+		log.Println("Cannot tell automatically installed packages apart:", err)
+		return packages, nil
+	}
+	auto := make(map[string]bool)
+	for _, name := range splitLines(string(data)) {
+		if !strings.HasPrefix(name, "#") {
+			auto[strings.TrimSpace(name)] = true
+		}
+	}
+	var explicit []string
+	for _, pkg := range packages {
+		if !auto[pkg.Name] {
+			explicit = append(explicit, pkg.Name)
+		}
+	}
+	markReasons(packages, explicit)
+	return packages, nil
+}
+
+// fetchZypperSources reads .repo files with an enabled repository outside openSUSE's own
+// download servers, like OBS home projects or Packman.
+func fetchZypperSources(packages []Package) (*Sources, error) {
+	files, err := filepath.Glob("/etc/zypp/repos.d/*.repo")
+	if err != nil {
+		return nil, err
+	}
+	sources := &Sources{Manager: "zypper"}
+	keyPaths := make(map[string]bool)
+	aliases := make(map[string]string) // display name -> alias
+	for _, path := range files {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		uris, keys, enabled := parseRepoFile(string(data))
+		if !enabled || officialZypperRepo(uris) {
+			continue
+		}
+		alias := strings.TrimSuffix(filepath.Base(path), ".repo")
+		sources.Repositories = append(sources.Repositories, Repository{Name: alias, Path: path, URIs: uris, Definition: string(data)})
+		for _, key := range keys {
+			keyPaths[key] = true
+		}
+		aliases[alias] = alias
+		for _, line := range strings.Split(string(data), "\n") {
+			if name, ok := strings.CutPrefix(strings.TrimSpace(line), "name="); ok {
+				aliases[strings.TrimSpace(name)] = alias
+			}
+		}
+	}
+	sources.Keys = readKeyFiles(keyPaths)
+
+	// "i | name | package | version | arch | repository" rows
+	out, err := runQuery("zypper", "--quiet", "--non-interactive", "search", "--installed-only", "--details")
+	if err != nil {
+		log.Println("Cannot tell which repository packages came from:", err)
+		return sources, nil
+	}
+	from := make(map[string]string)
+	for _, line := range splitLines(out) {
+		fields := strings.Split(line, "|")
+		if len(fields) < 6 {
+			continue
+		}
+		if alias, ok := aliases[strings.TrimSpace(fields[5])]; ok {
+			from[strings.TrimSpace(fields[1])] = alias
+		}
+	}
+	for i := range packages {
+		if packages[i].Repository == "" {
+			packages[i].Repository = from[packages[i].Name]
+		}
+	}
+	return sources, nil
+}
+
+// officialZypperRepo tells openSUSE's repositories and installation media apart by URL
+func officialZypperRepo(uris []string) bool {
+	for _, uri := range uris {
+		parsed, err := url.Parse(uri)
+		if err != nil {
+			return false
+		}
+		switch {
+		case parsed.Scheme == "cd" || parsed.Scheme == "dvd" || parsed.Scheme == "hd":
+		case parsed.Hostname() == "download.opensuse.org" || parsed.Hostname() == "cdn.opensuse.org":
+		default:
+			return false
+		}
+	}
+	return len(uris) > 0
+}